@@ -9,11 +9,31 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/eventbus"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/handler"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/quota"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/ratelimit"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/workspace"
 )
 
+// Delivers webhook events in the background across invocations
+var webhooks *webhook.Dispatcher
+
+// Streams click events in batches across invocations, if EVENT_SINK is set
+var clickEvents eventbus.EventPublisher
+
+// Limits requests per client and route class, if RATE_LIMIT_STORE is set
+var rateLimiter *ratelimit.Limiter
+
+// Caps the links each owner creates and holds, if quotas are configured
+var quotas *quota.Plans
+
+// Maps owners and short domains to workspaces, if WORKSPACES is set
+var workspaces *workspace.Directory
+
 func router(ctx context.Context, event events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
 	startTime := time.Now()
 	path := event.RawPath
@@ -38,17 +58,69 @@ func router(ctx context.Context, event events.LambdaFunctionURLRequest) (events.
 	
 	// Create handler with database
 	h := handler.NewHandler(db)
+	h.SetWebhooks(webhooks)
+	if clickEvents != nil {
+		h.SetEventPublisher(clickEvents)
+	}
+	h.SetRateLimiter(rateLimiter)
+	h.SetQuotas(quotas)
+	h.SetWorkspaces(workspaces)
 
 	var response events.LambdaFunctionURLResponse
 	var routeErr error
 
+	// Rate limit each client per route class before doing any work
+	rateLimit, limitedResponse, allowed := h.CheckRateLimit(ctx, event, rateLimitClass(method, path))
+
 	switch {
+	case !allowed:
+		response = limitedResponse
+
 	case method == http.MethodPost && path == "/shorten":
 		response, routeErr = h.ShortenURL(ctx, event)
 	
 	case method == http.MethodGet && strings.HasPrefix(path, "/stats/"):
 		response, routeErr = h.GetURLStats(ctx, event)
 	
+	case method == http.MethodPost && strings.HasSuffix(path, "/unlock"):
+		response, routeErr = h.UnlockURL(ctx, event)
+
+	case method == http.MethodGet && strings.HasPrefix(path, "/campaigns/") && strings.HasSuffix(path, "/stats"):
+		response, routeErr = h.GetCampaignStats(ctx, event)
+
+	case method == http.MethodPost && strings.HasPrefix(path, "/links/") && strings.HasSuffix(path, "/stats-token"):
+		response, routeErr = h.IssueStatsToken(ctx, event)
+
+	case method == http.MethodGet && path == "/links":
+		response, routeErr = h.ListLinks(ctx, event)
+
+	case method == http.MethodGet && strings.HasPrefix(path, "/links/"):
+		response, routeErr = h.GetLink(ctx, event)
+
+	case method == http.MethodPatch && strings.HasPrefix(path, "/links/"):
+		response, routeErr = h.UpdateLink(ctx, event)
+
+	case method == http.MethodDelete && strings.HasPrefix(path, "/links/"):
+		response, routeErr = h.DeleteLink(ctx, event)
+
+	case method == http.MethodPost && path == "/webhooks":
+		response, routeErr = h.CreateWebhook(ctx, event)
+
+	case method == http.MethodGet && path == "/webhooks":
+		response, routeErr = h.ListWebhooks(ctx, event)
+
+	case method == http.MethodGet && strings.HasPrefix(path, "/webhooks/") && strings.HasSuffix(path, "/deliveries"):
+		response, routeErr = h.ListWebhookDeliveries(ctx, event)
+
+	case method == http.MethodDelete && strings.HasPrefix(path, "/webhooks/"):
+		response, routeErr = h.DeleteWebhook(ctx, event)
+
+	case method == http.MethodGet && path == "/usage":
+		response, routeErr = h.GetUsage(ctx, event)
+
+	case method == http.MethodGet && strings.HasSuffix(path, "/qr") && path != "/qr":
+		response, routeErr = h.QRCode(ctx, event)
+
 	case method == http.MethodGet && path != "/":
 		// Any other GET request is treated as a redirect
 		response, routeErr = h.RedirectURL(ctx, event)
@@ -64,6 +136,27 @@ func router(ctx context.Context, event events.LambdaFunctionURLRequest) (events.
 		}
 	}
 
+	response = handler.WithRateLimitHeaders(response, rateLimit)
+
+	// Deliver the webhook and click events this request raised before
+	// returning: Lambda freezes the environment once the response is sent
+	flushCtx, cancel := flushContext(ctx)
+	if err := webhooks.Flush(flushCtx); err != nil {
+		logger.Warn("Abandoned webhook deliveries at the end of the invocation", map[string]interface{}{
+			"requestId": event.RequestContext.RequestID,
+			"error":     err.Error(),
+		})
+	}
+	if clickEvents != nil {
+		if err := clickEvents.Flush(flushCtx); err != nil {
+			logger.Warn("Failed to publish click events at the end of the invocation", map[string]interface{}{
+				"requestId": event.RequestContext.RequestID,
+				"error":     err.Error(),
+			})
+		}
+	}
+	cancel()
+
 	// Record overall API latency
 	if metricClient != nil {
 		latencyMs := float64(time.Since(startTime).Milliseconds())
@@ -73,6 +166,26 @@ func router(ctx context.Context, event events.LambdaFunctionURLRequest) (events.
 			endpoint = "/shorten"
 		case method == http.MethodGet && strings.HasPrefix(path, "/stats/"):
 			endpoint = "/stats/{shortCode}"
+		case method == http.MethodPost && strings.HasSuffix(path, "/unlock"):
+			endpoint = "/{shortCode}/unlock"
+		case method == http.MethodGet && strings.HasPrefix(path, "/campaigns/") && strings.HasSuffix(path, "/stats"):
+			endpoint = "/campaigns/{name}/stats"
+		case method == http.MethodPost && strings.HasPrefix(path, "/links/") && strings.HasSuffix(path, "/stats-token"):
+			endpoint = "/links/{shortCode}/stats-token"
+		case method == http.MethodGet && path == "/links":
+			endpoint = "/links"
+		case (method == http.MethodGet || method == http.MethodPatch || method == http.MethodDelete) && strings.HasPrefix(path, "/links/"):
+			endpoint = "/links/{shortCode}"
+		case (method == http.MethodGet || method == http.MethodPost) && path == "/webhooks":
+			endpoint = "/webhooks"
+		case method == http.MethodGet && strings.HasPrefix(path, "/webhooks/") && strings.HasSuffix(path, "/deliveries"):
+			endpoint = "/webhooks/{id}/deliveries"
+		case method == http.MethodDelete && strings.HasPrefix(path, "/webhooks/"):
+			endpoint = "/webhooks/{id}"
+		case method == http.MethodGet && path == "/usage":
+			endpoint = "/usage"
+		case method == http.MethodGet && strings.HasSuffix(path, "/qr") && path != "/qr":
+			endpoint = "/{shortCode}/qr"
 		case method == http.MethodGet && path != "/":
 			endpoint = "/{shortCode}"
 		default:
@@ -85,7 +198,72 @@ func router(ctx context.Context, event events.LambdaFunctionURLRequest) (events.
 	return response, routeErr
 }
 
+// Time left at the end of an invocation for the Lambda runtime to send the
+// response
+const flushMargin = 500 * time.Millisecond
+
+// flushContext bounds work done at the end of an invocation so it ends
+// before the function times out
+func flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline.Add(-flushMargin))
+	}
+	return context.WithCancel(ctx)
+}
+
+// rateLimitClass returns the rate limit class of a route
+func rateLimitClass(method, path string) string {
+	switch {
+	case method == http.MethodPost && path == "/shorten":
+		return ratelimit.ClassShorten
+	case method == http.MethodGet && (strings.HasPrefix(path, "/stats/") || strings.HasPrefix(path, "/campaigns/") || path == "/usage"):
+		return ratelimit.ClassStats
+	case method == http.MethodPost && strings.HasSuffix(path, "/unlock"):
+		return ratelimit.ClassUnlock
+	case path == "/links" || strings.HasPrefix(path, "/links/") || path == "/webhooks" || strings.HasPrefix(path, "/webhooks/"):
+		return ratelimit.ClassAdmin
+	case method == http.MethodGet && path != "/":
+		return ratelimit.ClassRedirect
+	default:
+		return ""
+	}
+}
+
 func main() {
 	logger.Info("URL Shortener Lambda starting up")
+	webhooks = webhook.NewDispatcher(database.NewDynamoDB(nil), webhook.DefaultOptions())
+
+	publisher, err := eventbus.FromEnv(context.Background())
+	if err != nil {
+		logger.Error("Failed to set up the click event stream", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	clickEvents = publisher
+
+	limiter, err := ratelimit.FromEnv(context.Background())
+	if err != nil {
+		logger.Error("Failed to set up rate limiting", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	rateLimiter = limiter
+
+	plans, err := quota.FromEnv()
+	if err != nil {
+		logger.Error("Failed to set up quotas", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	quotas = plans
+
+	directory, err := workspace.FromEnv()
+	if err != nil {
+		logger.Error("Failed to set up workspaces", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	workspaces = directory
+
 	lambda.Start(router)
 }
\ No newline at end of file
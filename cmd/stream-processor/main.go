@@ -0,0 +1,69 @@
+// Command stream-processor is a Lambda function that keeps analytics
+// aggregates up to date from the links table's DynamoDB stream and from the
+// click event stream, away from the redirect path. It also sends the
+// link.expired webhook event, since only the stream sees every expiry.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/aggregate"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/handler"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+)
+
+// Time left at the end of an invocation to report the batch result
+const flushMargin = 500 * time.Millisecond
+
+func main() {
+	logger.Info("Stream processor starting up")
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		logger.Error("Failed to load AWS config", err)
+		os.Exit(1)
+	}
+
+	// The aggregates table is named after the links table unless
+	// AGGREGATE_TABLE_NAME says otherwise
+	tableName := os.Getenv("AGGREGATE_TABLE_NAME")
+	if tableName == "" {
+		linksTable := os.Getenv("TABLE_NAME")
+		if linksTable == "" {
+			linksTable = database.TableName
+		}
+		tableName = linksTable + aggregate.TableSuffix
+	}
+	processor := aggregate.NewProcessor(aggregate.NewDynamoDBStore(dynamodb.NewFromConfig(cfg), tableName))
+
+	webhooks := webhook.NewDispatcher(database.NewDynamoDB(nil), webhook.DefaultOptions())
+	processor.OnExpired(func(urlItem *model.URLItem) {
+		webhooks.Notify(webhook.NewEvent(webhook.EventLinkExpired, handler.LinkResponse(urlItem), time.Now()))
+	})
+
+	lambda.Start(func(ctx context.Context, payload json.RawMessage) error {
+		err := processor.Handle(ctx, payload)
+
+		// Deliver the events of this batch before the environment is frozen
+		flushCtx, cancel := context.WithCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			flushCtx, cancel = context.WithDeadline(ctx, deadline.Add(-flushMargin))
+		}
+		defer cancel()
+		if flushErr := webhooks.Flush(flushCtx); flushErr != nil {
+			logger.Warn("Abandoned link.expired deliveries at the end of the batch", map[string]interface{}{
+				"error": flushErr.Error(),
+			})
+		}
+		return err
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/bulk"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+)
+
+const usage = `Usage:
+  urlbulk export [-format jsonl|csv] [-segments N] [-out FILE]
+  urlbulk import [-format jsonl|csv|bitly] [-codes keep|regenerate] [-on-conflict skip|overwrite] [-dry-run] [-in FILE]
+`
+
+func main() {
+	// Keep structured logs on stderr so exports can be piped from stdout
+	logger.SetOutput(os.Stderr)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	db := database.NewDynamoDB(nil)
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = bulk.ExportCommand(ctx, db, os.Args[2:])
+	case "import":
+		err = bulk.ImportCommand(ctx, db, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "urlbulk %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
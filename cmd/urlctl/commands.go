@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/bulk"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/handler"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+)
+
+// errListLimit stops a list scan once enough links have been collected
+var errListLimit = errors.New("list limit reached")
+
+func runCreate(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	originalURL := flags.String("url", "", "destination URL (required)")
+	code := flags.String("code", "", "custom short code (random if empty)")
+	expireInDays := flags.Int("expire-in-days", 0, "days until the link expires, 0 for never")
+	fallbackURL := flags.String("fallback-url", "", "destination used once the link expires or is disabled")
+	redirectType := flags.Int("redirect-type", 0, "redirect status: 301, 302, 307 or 308 (default from config)")
+	forwardQuery := flags.String("forward-query", "", "forward incoming query parameters: merge or override")
+	forwardPath := flags.Bool("forward-path", false, "append any path after the code to the destination")
+	campaign := flags.String("campaign", "", "campaign the link belongs to")
+	interstitial := flags.Bool("interstitial", false, "show a \"you are leaving\" page before redirecting")
+	activeFrom := flags.String("active-from", "", "RFC 3339 time before which the link does not redirect yet")
+	maxClicks := flags.Int("max-clicks", 0, "clicks after which the link stops redirecting, 0 for no limit")
+	password := flags.String("password", "", "password visitors must enter before being redirected")
+	flags.Parse(args)
+
+	if *originalURL == "" {
+		return fmt.Errorf("-url is required")
+	}
+
+	activeFromUnix, err := utils.ParseActivationTime(*activeFrom)
+	if err != nil {
+		return err
+	}
+	passwordHash, err := hashPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	urlItem := &model.URLItem{
+		ShortCode:    *code,
+		OriginalURL:  *originalURL,
+		CreatedAt:    time.Now().Format(time.RFC3339),
+		Expiration:   utils.CalculateExpirationTime(*expireInDays),
+		ActiveFrom:   activeFromUnix,
+		MaxClicks:    *maxClicks,
+		PasswordHash: passwordHash,
+		FallbackURL:  *fallbackURL,
+		RedirectType: *redirectType,
+		ForwardQuery: *forwardQuery,
+		ForwardPath:  *forwardPath,
+		Campaign:     *campaign,
+		Interstitial: *interstitial,
+	}
+
+	// Validated and stored exactly as the import would
+	if err := bulk.Create(ctx, a.db, urlItem, a.config); err != nil {
+		return err
+	}
+	return a.printURLs(urlItem)
+}
+
+func runGet(ctx context.Context, a *app, args []string) error {
+	code, _, err := codeArg(args)
+	if err != nil {
+		return err
+	}
+
+	urlItem, err := a.db.GetURL(ctx, code)
+	if err != nil {
+		return err
+	}
+	return a.printURLs(urlItem)
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	limit := flags.Int("limit", 100, "maximum number of links to show, 0 for all")
+	flags.Parse(args)
+
+	var urlItems []*model.URLItem
+	err := a.db.ScanURLs(ctx, 1, func(urlItem *model.URLItem) error {
+		copied := *urlItem
+		urlItems = append(urlItems, &copied)
+		if *limit > 0 && len(urlItems) >= *limit {
+			return errListLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errListLimit) {
+		return err
+	}
+	return a.printURLs(urlItems...)
+}
+
+func runUpdate(ctx context.Context, a *app, args []string) error {
+	code, rest, err := codeArg(args)
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	originalURL := flags.String("url", "", "new destination URL")
+	expireInDays := flags.Int("expire-in-days", 0, "days from now until the link expires")
+	noExpiration := flags.Bool("no-expiration", false, "remove the expiration")
+	fallbackURL := flags.String("fallback-url", "", "new fallback destination")
+	noFallback := flags.Bool("no-fallback", false, "remove the fallback destination")
+	redirectType := flags.Int("redirect-type", -1, "redirect status: 301, 302, 307 or 308, 0 for the default")
+	forwardQuery := flags.String("forward-query", "", "forward incoming query parameters: merge, override or off")
+	forwardPath := flags.String("forward-path", "", "append any path after the code: true or false")
+	campaign := flags.String("campaign", "", "move the link to this campaign")
+	noCampaign := flags.Bool("no-campaign", false, "remove the link from its campaign")
+	interstitial := flags.String("interstitial", "", "show a \"you are leaving\" page: true or false")
+	activeFrom := flags.String("active-from", "", "RFC 3339 time before which the link does not redirect yet")
+	noActiveFrom := flags.Bool("no-active-from", false, "let the link redirect straight away")
+	maxClicks := flags.Int("max-clicks", -1, "clicks after which the link stops redirecting, 0 for no limit")
+	password := flags.String("password", "", "new password visitors must enter before being redirected")
+	noPassword := flags.Bool("no-password", false, "remove the password")
+	flags.Parse(rest)
+
+	urlItem, err := a.db.GetURL(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if *originalURL != "" {
+		urlItem.OriginalURL = *originalURL
+	}
+	if *expireInDays > 0 {
+		urlItem.Expiration = utils.CalculateExpirationTime(*expireInDays)
+	}
+	if *noExpiration {
+		urlItem.Expiration = 0
+	}
+	if *fallbackURL != "" {
+		urlItem.FallbackURL = *fallbackURL
+	}
+	if *noFallback {
+		urlItem.FallbackURL = ""
+	}
+	if *redirectType >= 0 {
+		urlItem.RedirectType = *redirectType
+	}
+	switch *forwardQuery {
+	case "":
+	case "off":
+		urlItem.ForwardQuery = ""
+	default:
+		urlItem.ForwardQuery = *forwardQuery
+	}
+	if *forwardPath != "" {
+		enabled, err := strconv.ParseBool(*forwardPath)
+		if err != nil {
+			return fmt.Errorf("-forward-path must be true or false")
+		}
+		urlItem.ForwardPath = enabled
+	}
+	if *campaign != "" {
+		urlItem.Campaign = *campaign
+	}
+	if *noCampaign {
+		urlItem.Campaign = ""
+	}
+	if *interstitial != "" {
+		enabled, err := strconv.ParseBool(*interstitial)
+		if err != nil {
+			return fmt.Errorf("-interstitial must be true or false")
+		}
+		urlItem.Interstitial = enabled
+	}
+	if *activeFrom != "" {
+		if urlItem.ActiveFrom, err = utils.ParseActivationTime(*activeFrom); err != nil {
+			return err
+		}
+	}
+	if *noActiveFrom {
+		urlItem.ActiveFrom = 0
+	}
+	if *maxClicks >= 0 {
+		urlItem.MaxClicks = *maxClicks
+	}
+	if *password != "" {
+		if urlItem.PasswordHash, err = hashPassword(*password); err != nil {
+			return err
+		}
+	}
+	if *noPassword {
+		urlItem.PasswordHash = ""
+	}
+
+	if err := bulk.ValidateItem(urlItem, a.config); err != nil {
+		return err
+	}
+	if err := a.db.UpdateURL(ctx, urlItem); err != nil {
+		return err
+	}
+	return a.printURLs(urlItem)
+}
+
+func runDisable(ctx context.Context, a *app, args []string) error {
+	code, rest, err := codeArg(args)
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("disable", flag.ExitOnError)
+	enable := flags.Bool("enable", false, "re-enable a disabled link instead")
+	flags.Parse(rest)
+
+	urlItem, err := a.db.GetURL(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	urlItem.Disabled = !*enable
+	if err := a.db.UpdateURL(ctx, urlItem); err != nil {
+		return err
+	}
+	return a.printURLs(urlItem)
+}
+
+func runDelete(ctx context.Context, a *app, args []string) error {
+	code, _, err := codeArg(args)
+	if err != nil {
+		return err
+	}
+
+	// Load the link first so the event can describe it, as the API does
+	urlItem, err := a.db.GetURL(ctx, code)
+	if err != nil {
+		return err
+	}
+	if err := a.db.DeleteURL(ctx, code); err != nil {
+		return err
+	}
+
+	a.webhooks.Notify(webhook.NewEvent(webhook.EventLinkDeleted, handler.LinkResponse(urlItem), time.Now()))
+	if err := a.webhooks.Flush(ctx); err != nil {
+		return err
+	}
+	return a.printMessage(fmt.Sprintf("deleted %s", code), map[string]string{"deleted": code})
+}
+
+func runStats(ctx context.Context, a *app, args []string) error {
+	code, _, err := codeArg(args)
+	if err != nil {
+		return err
+	}
+
+	urlItem, err := a.db.GetURL(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	// The same report GET /stats/{shortCode} returns
+	stats := handler.StatsResponse(urlItem, time.Now())
+	return a.printStats(code, &stats)
+}
+
+func runExport(ctx context.Context, a *app, args []string) error {
+	return bulk.ExportCommand(ctx, a.db, args)
+}
+
+func runImport(ctx context.Context, a *app, args []string) error {
+	return bulk.ImportCommand(ctx, a.db, args)
+}
+
+// hashPassword hashes a link password the way the API does, or returns ""
+// for no password
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	return utils.HashPassword(password)
+}
+
+// codeArg splits the leading short code argument from the remaining flags
+func codeArg(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, fmt.Errorf("a short code argument is required")
+	}
+	return args[0], args[1:], nil
+}
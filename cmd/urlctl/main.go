@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/bulk"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+)
+
+const usage = `Usage: urlctl [global flags] <command> [flags] [args]
+
+Commands:
+  create  -url URL [-code CODE] [-expire-in-days N] [-fallback-url URL] [-redirect-type N]
+          [-forward-query merge|override] [-forward-path] [-campaign NAME] [-interstitial]
+          [-active-from TIME] [-max-clicks N] [-password PASSWORD]
+  get     CODE
+  list    [-limit N]
+  update  CODE [-url URL] [-expire-in-days N] [-no-expiration] [-fallback-url URL] [-no-fallback] [-redirect-type N]
+          [-forward-query merge|override|off] [-forward-path true|false]
+          [-campaign NAME] [-no-campaign] [-interstitial true|false]
+          [-active-from TIME] [-no-active-from] [-max-clicks N] [-password PASSWORD] [-no-password]
+  disable CODE [-enable]
+  delete  CODE
+  stats   CODE
+  export  [-format jsonl|csv] [-segments N] [-out FILE]
+  import  [-format jsonl|csv|bitly] [-codes keep|regenerate] [-on-conflict skip|overwrite] [-dry-run] [-in FILE]
+
+Global flags:
+`
+
+// command is a urlctl subcommand. It receives the arguments after its name.
+type command func(ctx context.Context, app *app, args []string) error
+
+var commands = map[string]command{
+	"create":  runCreate,
+	"get":     runGet,
+	"list":    runList,
+	"update":  runUpdate,
+	"disable": runDisable,
+	"delete":  runDelete,
+	"stats":   runStats,
+	"export":  runExport,
+	"import":  runImport,
+}
+
+// app holds the state shared by every subcommand
+type app struct {
+	db       database.DynamoDBInterface
+	config   bulk.Config
+	webhooks *webhook.Dispatcher
+	output   string
+}
+
+func main() {
+	// Keep structured logs on stderr so command output stays machine readable
+	logger.SetOutput(os.Stderr)
+
+	tableName := flag.String("table", envOr("TABLE_NAME", database.TableName), "DynamoDB table name")
+	endpoint := flag.String("endpoint", os.Getenv("DYNAMODB_ENDPOINT"), "custom DynamoDB endpoint, e.g. http://localhost:8000")
+	region := flag.String("region", "", "AWS region (defaults to the AWS config)")
+	output := flag.String("o", "table", "output format: table or json")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "urlctl: unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "urlctl: invalid output format %q\n", *output)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	client, err := newClient(ctx, *endpoint, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "urlctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := bulk.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "urlctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	db := database.NewDynamoDBWithTable(client, *tableName)
+	a := &app{
+		db:       db,
+		config:   cfg,
+		webhooks: webhook.NewDispatcher(db, webhook.DefaultOptions()),
+		output:   *output,
+	}
+	if err := run(ctx, a, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "urlctl %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// newClient builds a DynamoDB client, optionally pointed at a custom
+// endpoint such as DynamoDB Local
+func newClient(ctx context.Context, endpoint, region string) (*dynamodb.Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// envOr returns the environment variable named key, or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// printURLs writes links to stdout as a table or JSON. A single link is
+// written as a JSON object, several as an array.
+func (a *app) printURLs(urlItems ...*model.URLItem) error {
+	if a.output == "json" {
+		if len(urlItems) == 1 {
+			return printJSON(urlItems[0])
+		}
+		if urlItems == nil {
+			urlItems = []*model.URLItem{}
+		}
+		return printJSON(urlItems)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tURL\tCREATED\tEXPIRES\tCLICKS\tDISABLED")
+	for _, urlItem := range urlItems {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\n",
+			urlItem.ShortCode,
+			urlItem.OriginalURL,
+			urlItem.CreatedAt,
+			formatExpiration(urlItem.Expiration),
+			urlItem.ClickCount,
+			urlItem.Disabled,
+		)
+	}
+	return w.Flush()
+}
+
+// printStats writes the analytics for a link
+func (a *app) printStats(code string, stats *model.StatsResponse) error {
+	if a.output == "json" {
+		return printJSON(stats)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Code:\t%s\n", code)
+	fmt.Fprintf(w, "Original URL:\t%s\n", stats.OriginalURL)
+	fmt.Fprintf(w, "Created:\t%s\n", stats.CreatedAt)
+	fmt.Fprintf(w, "Expires:\t%s\n", formatExpiration(stats.Expiration))
+	fmt.Fprintf(w, "Clicks:\t%d\n", stats.ClickCount)
+	fmt.Fprintf(w, "Disabled:\t%t\n", stats.Disabled)
+
+	// Optional settings are only listed when they are set
+	optional := []struct {
+		label string
+		value string
+	}{
+		{"Domain", stats.Domain},
+		{"Owner", stats.Owner},
+		{"Campaign", stats.Campaign},
+		{"Tags", strings.Join(stats.Tags, ", ")},
+		{"Title", stats.Title},
+		{"Active from", stats.ActiveFrom},
+		{"Max clicks", formatCount(stats.MaxClicks)},
+		{"Fallback URL", stats.FallbackURL},
+		{"Forward query", stats.ForwardQuery},
+		{"Schedule", formatSchedule(stats.Schedule)},
+		{"Rule clicks", formatCounts(stats.RuleClicks)},
+		{"Country clicks", formatCounts(stats.CountryClicks)},
+		{"Variant clicks", formatVariants(stats.Variants)},
+	}
+	for _, field := range optional {
+		if field.value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", field.label, field.value)
+		}
+	}
+	fmt.Fprintf(w, "Redirect type:\t%d\n", stats.RedirectType)
+	for _, flag := range []struct {
+		label string
+		set   bool
+	}{
+		{"Expired", stats.Expired},
+		{"Password protected", stats.PasswordProtected},
+		{"Interstitial", stats.Interstitial},
+		{"Private", stats.Private},
+		{"Forward path", stats.ForwardPath},
+		{"Sticky", stats.Sticky},
+	} {
+		if flag.set {
+			fmt.Fprintf(w, "%s:\ttrue\n", flag.label)
+		}
+	}
+	return w.Flush()
+}
+
+// formatCount renders an optional count, or "" for 0
+func formatCount(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// formatCounts renders a click breakdown as "key=count" pairs sorted by key
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%d", key, counts[key])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// formatVariants renders each variant's clicks and share
+func formatVariants(variants []model.VariantStats) string {
+	parts := make([]string, len(variants))
+	for i, variant := range variants {
+		parts[i] = fmt.Sprintf("%s=%d (%g%%)", variant.Name, variant.Clicks, variant.Share)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatSchedule renders a link's schedule windows and their timezone
+func formatSchedule(schedule *model.Schedule) string {
+	if schedule == nil {
+		return ""
+	}
+	windows := make([]string, len(schedule.Windows))
+	for i, window := range schedule.Windows {
+		windows[i] = fmt.Sprintf("%s..%s -> %s", window.From, window.To, window.URL)
+	}
+	text := strings.Join(windows, "; ")
+	if schedule.Timezone != "" {
+		text += " (" + schedule.Timezone + ")"
+	}
+	return text
+}
+
+// printMessage writes a short confirmation, or its JSON equivalent
+func (a *app) printMessage(message string, value interface{}) error {
+	if a.output == "json" {
+		return printJSON(value)
+	}
+	_, err := fmt.Println(message)
+	return err
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// formatExpiration renders a Unix expiration timestamp for table output
+func formatExpiration(expiration int64) string {
+	if expiration == 0 {
+		return "never"
+	}
+	return time.Unix(expiration, 0).UTC().Format(time.RFC3339)
+}
@@ -35,9 +35,27 @@ SHORT_CODE=$(echo $SHORT_URL | awk -F'/' '{print $NF}')
 
 echo "Short code: $SHORT_CODE"
 
+# Extract the stats token, which is needed to read the link's stats
+STATS_TOKEN=$(echo $RESPONSE | grep -o '"stats_token":"[^"]*"' | cut -d'"' -f4)
+
+if [ -z "$STATS_TOKEN" ]; then
+  echo "Failed to get a stats token"
+  exit 1
+fi
+
 # Test 2: Get URL stats
 echo "Test 2: Get URL stats"
-STATS_RESPONSE=$(curl -s -X GET "$API_URL/stats/$SHORT_CODE")
+
+# Check stats are not shown without the token
+STATUS=$(curl -s -o /dev/null -w "%{http_code}" -X GET "$API_URL/stats/$SHORT_CODE")
+
+if [ "$STATUS" != "404" ]; then
+  echo "Expected 404 for stats without a token, got $STATUS"
+  exit 1
+fi
+
+STATS_RESPONSE=$(curl -s -X GET "$API_URL/stats/$SHORT_CODE" \
+  -H "X-Stats-Token: $STATS_TOKEN")
 
 echo "Stats response: $STATS_RESPONSE"
 
@@ -0,0 +1,189 @@
+// Package aggregate maintains analytics derived from the links table's
+// DynamoDB stream and from click events: daily rollups, per-owner totals and
+// a search index, all kept in their own table. Each source record is applied
+// exactly once, together with a checkpoint, so retried batches never count
+// twice.
+package aggregate
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Key layout of the aggregates table. Every item has a partition key "pk"
+// and a sort key "sk".
+const (
+	// Daily rollup of one link: pk "link#<code>", sk "day#<YYYY-MM-DD>"
+	linkPrefix = "link#"
+	dayPrefix  = "day#"
+	// Daily totals across all links: pk "daily", sk "day#<YYYY-MM-DD>"
+	dailyPK = "daily"
+	// Running totals of an owner: pk "owner#<owner>", sk "totals"
+	ownerPrefix = "owner#"
+	totalsSK    = "totals"
+	// Quota usage of an owner: pk "owner#<owner>", sk "usage#active" for
+	// the links it holds and "usage#<YYYY-MM>" for those created that month
+	usagePrefix = "usage#"
+	activeUsage = "active"
+	// Search index entry: pk "search#<term>", sk "link#<code>"
+	searchPrefix = "search#"
+	// Wrong passwords for a protected link from one client: pk
+	// "unlock#<code>", sk "client#<client>"
+	unlockPrefix = "unlock#"
+	clientPrefix = "client#"
+	// Applied source record: pk "checkpoint#<id>", sk "checkpoint"
+	checkpointPrefix = "checkpoint#"
+	checkpointSK     = "checkpoint"
+
+	// How long checkpoints are kept, comfortably longer than any source
+	// retains and retries records
+	CheckpointTTL = 14 * 24 * time.Hour
+
+	// TableSuffix follows the links table name to give the aggregates table
+	// name, used when AGGREGATE_TABLE_NAME is not set
+	TableSuffix = "Aggregates"
+)
+
+// Counter names
+const (
+	Clicks  = "clicks"
+	Created = "created"
+	Deleted = "deleted"
+	Expired = "expired"
+	Links   = "links"
+)
+
+// Key identifies an item in the aggregates table
+type Key struct {
+	PK string
+	SK string
+}
+
+// LinkDayKey is the key of a link's rollup for the day of t (UTC)
+func LinkDayKey(code string, t time.Time) Key {
+	return Key{PK: linkPrefix + code, SK: dayPrefix + day(t)}
+}
+
+// DailyKey is the key of the totals across all links for the day of t (UTC)
+func DailyKey(t time.Time) Key {
+	return Key{PK: dailyPK, SK: dayPrefix + day(t)}
+}
+
+// OwnerKey is the key of an owner's running totals
+func OwnerKey(owner string) Key {
+	return Key{PK: ownerPrefix + owner, SK: totalsSK}
+}
+
+// ActiveUsageKey is the key of the count of links an owner holds, kept in
+// its Links counter
+func ActiveUsageKey(owner string) Key {
+	return Key{PK: ownerPrefix + owner, SK: usagePrefix + activeUsage}
+}
+
+// MonthlyUsageKey is the key of the count of links an owner created in the
+// month of t (UTC), kept in its Created counter
+func MonthlyUsageKey(owner string, t time.Time) Key {
+	return Key{PK: ownerPrefix + owner, SK: usagePrefix + t.UTC().Format("2006-01")}
+}
+
+// UnlockKey is the key of the failed unlock attempts of one client on a
+// password protected link
+func UnlockKey(code, client string) Key {
+	return Key{PK: unlockPrefix + code, SK: clientPrefix + client}
+}
+
+// SearchKey is the key of a link's entry under a search term
+func SearchKey(term, code string) Key {
+	return Key{PK: searchPrefix + term, SK: linkPrefix + code}
+}
+
+// BreakdownCounter names the counter of clicks with a given dimension value
+// in a link's daily rollup, such as "country#NL" or "device#mobile"
+func BreakdownCounter(dimension, value string) string {
+	return dimension + "#" + strings.ToLower(value)
+}
+
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Update changes one item: counters in Add are incremented (negative values
+// decrement), attributes in Set are overwritten, and Delete removes the item
+// instead.
+type Update struct {
+	Key    Key
+	Add    map[string]int
+	Set    map[string]string
+	Delete bool
+}
+
+// Store applies the updates derived from one source record
+type Store interface {
+	// Apply makes every update and records the checkpoint atomically. It
+	// returns false, making no changes, if the checkpoint was recorded
+	// before.
+	Apply(ctx context.Context, checkpoint string, updates []Update) (bool, error)
+}
+
+// updateSet collects updates by key, merging those to the same item since
+// a transaction can only touch each item once
+type updateSet struct {
+	keys    []Key
+	updates map[Key]*Update
+}
+
+func newUpdateSet() *updateSet {
+	return &updateSet{updates: map[Key]*Update{}}
+}
+
+func (s *updateSet) get(key Key) *Update {
+	update, ok := s.updates[key]
+	if !ok {
+		update = &Update{Key: key}
+		s.updates[key] = update
+		s.keys = append(s.keys, key)
+	}
+	return update
+}
+
+// add increments a counter
+func (s *updateSet) add(key Key, counter string, n int) {
+	update := s.get(key)
+	if update.Add == nil {
+		update.Add = map[string]int{}
+	}
+	update.Add[counter] += n
+}
+
+// set overwrites attributes, undoing an earlier delete of the item
+func (s *updateSet) set(key Key, attributes map[string]string) {
+	update := s.get(key)
+	update.Delete = false
+	update.Set = attributes
+}
+
+// remove deletes the item
+func (s *updateSet) remove(key Key) {
+	update := s.get(key)
+	*update = Update{Key: key, Delete: true}
+}
+
+// list returns the updates in the order their items were first touched,
+// leaving out counters that cancelled out
+func (s *updateSet) list() []Update {
+	var updates []Update
+	for _, key := range s.keys {
+		update := *s.updates[key]
+		for counter, n := range update.Add {
+			if n == 0 {
+				delete(update.Add, counter)
+			}
+		}
+		if !update.Delete && len(update.Add) == 0 && len(update.Set) == 0 {
+			continue
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
@@ -0,0 +1,400 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/eventbus"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+const (
+	// Most terms a link is indexed under, keeping each stream record within
+	// one transaction
+	maxSearchTerms = 20
+	// Shortest indexed term
+	minSearchTermLength = 2
+)
+
+// Processor turns source records into aggregate updates
+type Processor struct {
+	store Store
+
+	// Called once for every link that expires, if set
+	onExpired func(urlItem *model.URLItem)
+}
+
+// NewProcessor creates a processor writing to store
+func NewProcessor(store Store) *Processor {
+	return &Processor{store: store}
+}
+
+// OnExpired sets a function to call once for every link that expires:
+// when a visit first finds it expired, or when DynamoDB's TTL removes it
+// without any visit having done so. It is called after the record is
+// applied, so a retried batch does not report a link twice.
+func (p *Processor) OnExpired(fn func(urlItem *model.URLItem)) {
+	p.onExpired = fn
+}
+
+// Handle processes a Lambda invocation from a DynamoDB stream, a Kinesis
+// stream or SQS queue of click events, or an EventBridge click event.
+// Records are applied in order; on an error the rest of the batch is left
+// for the retry, which skips the records already applied.
+func (p *Processor) Handle(ctx context.Context, payload json.RawMessage) error {
+	var envelope struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+		DetailType string `json:"detail-type"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return fmt.Errorf("failed to parse event: %w", err)
+	}
+
+	switch {
+	case envelope.DetailType != "":
+		var event events.EventBridgeEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return fmt.Errorf("failed to parse EventBridge event: %w", err)
+		}
+		return p.handleClickData(ctx, "eventbridge", event.ID, event.Detail)
+	case len(envelope.Records) == 0:
+		return nil
+	}
+
+	switch source := envelope.Records[0].EventSource; source {
+	case "aws:dynamodb":
+		var event events.DynamoDBEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return fmt.Errorf("failed to parse DynamoDB stream event: %w", err)
+		}
+		return p.HandleStream(ctx, event)
+	case "aws:kinesis":
+		var event events.KinesisEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return fmt.Errorf("failed to parse Kinesis event: %w", err)
+		}
+		for _, record := range event.Records {
+			if err := p.handleClickData(ctx, "kinesis", record.EventID, record.Kinesis.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "aws:sqs":
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return fmt.Errorf("failed to parse SQS event: %w", err)
+		}
+		for _, record := range event.Records {
+			if err := p.handleClickData(ctx, "sqs", record.MessageId, []byte(record.Body)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported event source %q", source)
+	}
+}
+
+// handleClickData decodes one click event. Events that cannot be read, or
+// were written with a newer schema, are logged and skipped rather than
+// blocking the stream.
+func (p *Processor) handleClickData(ctx context.Context, source, recordID string, data []byte) error {
+	var event eventbus.ClickEvent
+	err := json.Unmarshal(data, &event)
+	if err == nil {
+		_, err = time.Parse(time.RFC3339Nano, event.Timestamp)
+	}
+	if err != nil || event.Type != eventbus.EventTypeClick || event.ShortCode == "" {
+		logger.Warn("Skipping unreadable click event", map[string]interface{}{
+			"source":   source,
+			"recordId": recordID,
+		})
+		return nil
+	}
+	if event.SchemaVersion > eventbus.SchemaVersion {
+		logger.Warn("Skipping click event with a newer schema", map[string]interface{}{
+			"eventId":       event.ID,
+			"schemaVersion": event.SchemaVersion,
+		})
+		return nil
+	}
+	return p.HandleClick(ctx, event)
+}
+
+// HandleClick adds a click to its link's daily rollup, the daily totals and
+// its owner's totals. The event ID is the checkpoint, so an event delivered
+// twice is counted once.
+func (p *Processor) HandleClick(ctx context.Context, event eventbus.ClickEvent) error {
+	clickedAt, err := time.Parse(time.RFC3339Nano, event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("click event %s has an invalid timestamp: %w", event.ID, err)
+	}
+
+	updates := newUpdateSet()
+	linkDay := LinkDayKey(event.ShortCode, clickedAt)
+	updates.add(linkDay, Clicks, 1)
+	for _, breakdown := range []struct{ dimension, value string }{
+		{"country", event.Country},
+		{"os", event.OS},
+		{"device", event.Device},
+		{"browser", event.Browser},
+		{"rule", event.Rule},
+		{"variant", event.Variant},
+	} {
+		if breakdown.value != "" {
+			updates.add(linkDay, BreakdownCounter(breakdown.dimension, breakdown.value), 1)
+		}
+	}
+	updates.add(DailyKey(clickedAt), Clicks, 1)
+	if event.Owner != "" {
+		updates.add(OwnerKey(event.Owner), Clicks, 1)
+	}
+
+	_, err = p.apply(ctx, "click#"+event.ID, updates)
+	return err
+}
+
+// HandleStream applies the changes to links in a DynamoDB stream batch. The
+// stream must include new and old images.
+func (p *Processor) HandleStream(ctx context.Context, event events.DynamoDBEvent) error {
+	for _, record := range event.Records {
+		oldItem, err := streamImage(record.Change.OldImage)
+		if err != nil {
+			return fmt.Errorf("failed to read old image of %s: %w", record.EventID, err)
+		}
+		newItem, err := streamImage(record.Change.NewImage)
+		if err != nil {
+			return fmt.Errorf("failed to read new image of %s: %w", record.EventID, err)
+		}
+
+		updates := linkChanges(record.EventName, oldItem, newItem, record.Change.ApproximateCreationDateTime.Time, expiredByTTL(record))
+		applied, err := p.apply(ctx, "stream#"+record.EventID, updates)
+		if err != nil {
+			return err
+		}
+		if expired := expiredLink(record.EventName, oldItem, newItem, expiredByTTL(record)); applied && expired != nil && p.onExpired != nil {
+			p.onExpired(expired)
+		}
+	}
+	return nil
+}
+
+// apply writes a record's updates, logging records applied before. It
+// reports whether the updates were applied now.
+func (p *Processor) apply(ctx context.Context, checkpoint string, updates *updateSet) (bool, error) {
+	applied, err := p.store.Apply(ctx, checkpoint, updates.list())
+	if err != nil {
+		logger.Error("Failed to apply aggregate updates", map[string]interface{}{
+			"checkpoint": checkpoint,
+			"error":      err.Error(),
+		})
+		return false, err
+	}
+	if !applied {
+		logger.Info("Skipping record applied before", map[string]interface{}{
+			"checkpoint": checkpoint,
+		})
+	}
+	return applied, nil
+}
+
+// expiredLink returns the link a record shows expiring for the first time:
+// one a visit has just marked as expired, or one removed by TTL that no
+// visit marked. Otherwise it returns nil.
+func expiredLink(eventName string, oldItem, newItem *model.URLItem, byTTL bool) *model.URLItem {
+	switch {
+	case eventName == "MODIFY" && newItem != nil && newItem.ExpiryNotified && (oldItem == nil || !oldItem.ExpiryNotified):
+		return newItem
+	case eventName == "REMOVE" && byTTL && oldItem != nil && !oldItem.ExpiryNotified:
+		return oldItem
+	}
+	return nil
+}
+
+// linkChanges derives the updates for a link being created, changed or
+// removed. Click counts are left alone; clicks are counted from click
+// events, which carry the visitor details.
+func linkChanges(eventName string, oldItem, newItem *model.URLItem, at time.Time, expired bool) *updateSet {
+	updates := newUpdateSet()
+
+	switch eventName {
+	case "INSERT":
+		if newItem == nil {
+			return updates
+		}
+		updates.add(DailyKey(at), Created, 1)
+	case "REMOVE":
+		if oldItem == nil {
+			return updates
+		}
+		if expired {
+			updates.add(DailyKey(at), Expired, 1)
+		} else {
+			updates.add(DailyKey(at), Deleted, 1)
+		}
+	case "MODIFY":
+	default:
+		return updates
+	}
+
+	// Owner link counts follow the owner before and after the change
+	if oldItem != nil && oldItem.Owner != "" {
+		updates.add(OwnerKey(oldItem.Owner), Links, -1)
+	}
+	if newItem != nil && newItem.Owner != "" {
+		updates.add(OwnerKey(newItem.Owner), Links, 1)
+	}
+
+	// Quota usage is counted when a link is created, in the same transaction,
+	// so only removals and owner changes are applied here
+	if oldOwner, newOwner := ownerOf(oldItem), ownerOf(newItem); eventName != "INSERT" && oldOwner != newOwner {
+		if oldOwner != "" {
+			updates.add(ActiveUsageKey(oldOwner), Links, -1)
+		}
+		if newOwner != "" {
+			updates.add(ActiveUsageKey(newOwner), Links, 1)
+		}
+	}
+
+	// Search entries are only rewritten when what they show changes, so
+	// the MODIFY on every click costs nothing
+	oldTerms, oldEntry := searchEntry(oldItem)
+	newTerms, newEntry := searchEntry(newItem)
+	for _, term := range oldTerms {
+		if !slices.Contains(newTerms, term) {
+			updates.remove(SearchKey(term, oldItem.ShortCode))
+		}
+	}
+	for _, term := range newTerms {
+		if !slices.Contains(oldTerms, term) || !sameEntry(oldEntry, newEntry) {
+			updates.set(SearchKey(term, newItem.ShortCode), newEntry)
+		}
+	}
+	return updates
+}
+
+// ownerOf returns a link's owner, or "" when there is no link
+func ownerOf(urlItem *model.URLItem) string {
+	if urlItem == nil {
+		return ""
+	}
+	return urlItem.Owner
+}
+
+// searchEntry returns the terms a link is indexed under and the attributes
+// stored with each entry
+func searchEntry(urlItem *model.URLItem) ([]string, map[string]string) {
+	if urlItem == nil {
+		return nil, nil
+	}
+
+	text := []string{urlItem.Title, urlItem.Description, urlItem.Campaign}
+	text = append(text, urlItem.Tags...)
+	if parsed, err := url.Parse(urlItem.OriginalURL); err == nil {
+		text = append(text, strings.TrimPrefix(parsed.Hostname(), "www."))
+	}
+
+	var terms []string
+	for _, words := range text {
+		for _, term := range strings.FieldsFunc(strings.ToLower(words), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(term) >= minSearchTermLength && !slices.Contains(terms, term) && len(terms) < maxSearchTerms {
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	entry := map[string]string{
+		"shortCode":   urlItem.ShortCode,
+		"originalURL": urlItem.OriginalURL,
+	}
+	if urlItem.Title != "" {
+		entry["title"] = urlItem.Title
+	}
+	if urlItem.Owner != "" {
+		entry["owner"] = urlItem.Owner
+	}
+	return terms, entry
+}
+
+func sameEntry(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if b[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// expiredByTTL reports whether a REMOVE record was made by DynamoDB's TTL
+// process rather than a delete request
+func expiredByTTL(record events.DynamoDBEventRecord) bool {
+	return record.UserIdentity != nil && record.UserIdentity.Type == "Service" &&
+		record.UserIdentity.PrincipalID == "dynamodb.amazonaws.com"
+}
+
+// streamImage reads a link from a stream record image
+func streamImage(image map[string]events.DynamoDBAttributeValue) (*model.URLItem, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		item[name] = attributeValue(value)
+	}
+	var urlItem model.URLItem
+	if err := attributevalue.UnmarshalMap(item, &urlItem); err != nil {
+		return nil, err
+	}
+	return &urlItem, nil
+}
+
+// attributeValue converts a stream attribute value to the SDK's type
+func attributeValue(value events.DynamoDBAttributeValue) types.AttributeValue {
+	switch value.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: value.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: value.Number()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: value.Binary()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: value.Boolean()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: value.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: value.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: value.BinarySet()}
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, 0, len(value.List()))
+		for _, element := range value.List() {
+			list = append(list, attributeValue(element))
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case events.DataTypeMap:
+		m := make(map[string]types.AttributeValue, len(value.Map()))
+		for name, element := range value.Map() {
+			m[name] = attributeValue(element)
+		}
+		return &types.AttributeValueMemberM{Value: m}
+	default:
+		return &types.AttributeValueMemberNULL{Value: true}
+	}
+}
@@ -0,0 +1,303 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/eventbus"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// recordingStore remembers the updates of the last Apply
+type recordingStore struct {
+	*MemoryStore
+	last []Update
+}
+
+func (r *recordingStore) Apply(ctx context.Context, checkpoint string, updates []Update) (bool, error) {
+	r.last = updates
+	return r.MemoryStore.Apply(ctx, checkpoint, updates)
+}
+
+// streamValue converts an SDK attribute value to a stream record value
+func streamValue(value types.AttributeValue) events.DynamoDBAttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return events.NewStringAttribute(v.Value)
+	case *types.AttributeValueMemberN:
+		return events.NewNumberAttribute(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return events.NewBooleanAttribute(v.Value)
+	case *types.AttributeValueMemberSS:
+		return events.NewStringSetAttribute(v.Value)
+	case *types.AttributeValueMemberL:
+		list := []events.DynamoDBAttributeValue{}
+		for _, element := range v.Value {
+			list = append(list, streamValue(element))
+		}
+		return events.NewListAttribute(list)
+	case *types.AttributeValueMemberM:
+		return events.NewMapAttribute(streamImageOf(v.Value))
+	default:
+		return events.NewNullAttribute()
+	}
+}
+
+func streamImageOf(item map[string]types.AttributeValue) map[string]events.DynamoDBAttributeValue {
+	image := map[string]events.DynamoDBAttributeValue{}
+	for name, value := range item {
+		image[name] = streamValue(value)
+	}
+	return image
+}
+
+func streamRecord(t *testing.T, id, name string, oldItem, newItem *model.URLItem) events.DynamoDBEventRecord {
+	record := events.DynamoDBEventRecord{EventID: id, EventName: name, EventSource: "aws:dynamodb"}
+	record.Change.ApproximateCreationDateTime = events.SecondsEpochTime{Time: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	for _, image := range []struct {
+		item   *model.URLItem
+		target *map[string]events.DynamoDBAttributeValue
+	}{{oldItem, &record.Change.OldImage}, {newItem, &record.Change.NewImage}} {
+		if image.item == nil {
+			continue
+		}
+		item, err := attributevalue.MarshalMap(image.item)
+		if err != nil {
+			t.Fatalf("Failed to marshal link: %v", err)
+		}
+		*image.target = streamImageOf(item)
+	}
+	return record
+}
+
+func handle(t *testing.T, processor *Processor, event interface{}) {
+	payload, _ := json.Marshal(event)
+	if err := processor.Handle(context.Background(), payload); err != nil {
+		t.Fatalf("Handle returned an error: %v", err)
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	store := &recordingStore{MemoryStore: NewMemoryStore()}
+	processor := NewProcessor(store)
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	link := &model.URLItem{
+		ShortCode:   "aB3xY",
+		OriginalURL: "https://www.example.com/spring",
+		Title:       "Spring Launch",
+		Tags:        []string{"promo"},
+		Owner:       "team-a",
+	}
+	insert := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{streamRecord(t, "1", "INSERT", nil, link)}}
+
+	// Test a new link is counted and indexed, once even when retried
+	handle(t, processor, insert)
+	handle(t, processor, insert)
+	if daily, _ := store.Item(DailyKey(day)); daily.Counters[Created] != 1 {
+		t.Errorf("Expected 1 link created, got %v", daily.Counters)
+	}
+	if owner, _ := store.Item(OwnerKey("team-a")); owner.Counters[Links] != 1 {
+		t.Errorf("Expected the owner to have 1 link, got %v", owner.Counters)
+	}
+	if _, ok := store.Item(ActiveUsageKey("team-a")); ok {
+		t.Errorf("Expected quota usage to be left to the creating transaction")
+	}
+	for _, term := range []string{"spring", "launch", "promo", "example"} {
+		entry, ok := store.Item(SearchKey(term, "aB3xY"))
+		if !ok || entry.Attributes["title"] != "Spring Launch" || entry.Attributes["owner"] != "team-a" {
+			t.Errorf("Expected a search entry under %q, got %v", term, entry)
+		}
+	}
+
+	// Test a click count change writes nothing
+	clicked := *link
+	clicked.ClickCount = 1
+	handle(t, processor, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{streamRecord(t, "2", "MODIFY", link, &clicked)}})
+	if len(store.last) != 0 {
+		t.Errorf("Expected no updates for a click, got %+v", store.last)
+	}
+
+	// Test a new title moves the link between terms and updates the rest
+	renamed := clicked
+	renamed.Title = "Summer Launch"
+	handle(t, processor, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{streamRecord(t, "3", "MODIFY", &clicked, &renamed)}})
+	if _, ok := store.Item(SearchKey("spring", "aB3xY")); ok {
+		t.Errorf("Expected the spring entry to be removed")
+	}
+	if entry, _ := store.Item(SearchKey("launch", "aB3xY")); entry.Attributes["title"] != "Summer Launch" {
+		t.Errorf("Expected the launch entry to show the new title, got %v", entry.Attributes)
+	}
+	if keys := store.Keys(SearchKey("summer", "").PK); len(keys) != 1 {
+		t.Errorf("Expected one summer entry, got %v", keys)
+	}
+
+	// Test expiry by TTL is told apart from a delete and cleans up
+	expired := streamRecord(t, "4", "REMOVE", &renamed, nil)
+	expired.UserIdentity = &events.DynamoDBUserIdentity{Type: "Service", PrincipalID: "dynamodb.amazonaws.com"}
+	handle(t, processor, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{expired}})
+	if daily, _ := store.Item(DailyKey(day)); daily.Counters[Expired] != 1 || daily.Counters[Deleted] != 0 {
+		t.Errorf("Expected 1 link expired, got %v", daily.Counters)
+	}
+	if owner, _ := store.Item(OwnerKey("team-a")); owner.Counters[Links] != 0 {
+		t.Errorf("Expected the owner to have no links, got %v", owner.Counters)
+	}
+	if usage, _ := store.Item(ActiveUsageKey("team-a")); usage.Counters[Links] != -1 {
+		t.Errorf("Expected the removed link to be released from the owner's quota, got %v", usage.Counters)
+	}
+	for _, term := range []string{"summer", "launch", "promo", "example"} {
+		if _, ok := store.Item(SearchKey(term, "aB3xY")); ok {
+			t.Errorf("Expected the %q entry to be removed", term)
+		}
+	}
+}
+
+func TestExpiredLinks(t *testing.T) {
+	processor := NewProcessor(NewMemoryStore())
+	var expired []string
+	processor.OnExpired(func(urlItem *model.URLItem) {
+		expired = append(expired, urlItem.ShortCode)
+	})
+	ttl := &events.DynamoDBUserIdentity{Type: "Service", PrincipalID: "dynamodb.amazonaws.com"}
+
+	// Test a visit marking a link expired reports it, once even when retried,
+	// and its later removal by TTL does not report it again
+	visited := &model.URLItem{ShortCode: "visit", OriginalURL: "https://example.com"}
+	marked := *visited
+	marked.ExpiryNotified = true
+	modify := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{streamRecord(t, "1", "MODIFY", visited, &marked)}}
+	handle(t, processor, modify)
+	handle(t, processor, modify)
+	removed := streamRecord(t, "2", "REMOVE", &marked, nil)
+	removed.UserIdentity = ttl
+	handle(t, processor, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{removed}})
+
+	// Test a link TTL removes without a visit is reported, and a deleted
+	// one is not
+	unvisited := &model.URLItem{ShortCode: "quiet", OriginalURL: "https://example.com"}
+	removed = streamRecord(t, "3", "REMOVE", unvisited, nil)
+	removed.UserIdentity = ttl
+	deleted := streamRecord(t, "4", "REMOVE", &model.URLItem{ShortCode: "gone", OriginalURL: "https://example.com"}, nil)
+	handle(t, processor, events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{removed, deleted}})
+
+	if fmt.Sprint(expired) != "[visit quiet]" {
+		t.Errorf("Expected visit and quiet to be reported once each, got %v", expired)
+	}
+}
+
+func TestHandleClicks(t *testing.T) {
+	store := NewMemoryStore()
+	processor := NewProcessor(store)
+	clickedAt := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+
+	click := eventbus.NewClickEvent(clickedAt)
+	click.ShortCode = "aB3xY"
+	click.Owner = "team-a"
+	click.Destination = "https://example.com"
+	click.Country = "NL"
+	click.Device = "mobile"
+	data, _ := json.Marshal(click)
+
+	// Test the same event through Kinesis twice and through SQS counts once
+	kinesisEvent := events.KinesisEvent{Records: []events.KinesisEventRecord{
+		{EventSource: "aws:kinesis", EventID: "shard-1:1", Kinesis: events.KinesisRecord{Data: data}},
+		{EventSource: "aws:kinesis", EventID: "shard-1:2", Kinesis: events.KinesisRecord{Data: data}},
+	}}
+	handle(t, processor, kinesisEvent)
+	handle(t, processor, events.SQSEvent{Records: []events.SQSMessage{{EventSource: "aws:sqs", MessageId: "m1", Body: string(data)}}})
+
+	// Test EventBridge delivers a second click, from another day
+	second := eventbus.NewClickEvent(clickedAt.Add(time.Minute))
+	second.ShortCode = "aB3xY"
+	second.Owner = "team-a"
+	second.Country = "nl"
+	detail, _ := json.Marshal(second)
+	handle(t, processor, events.EventBridgeEvent{ID: "eb-1", DetailType: eventbus.EventBridgeDetailType, Detail: detail})
+
+	rollup, _ := store.Item(LinkDayKey("aB3xY", clickedAt))
+	if fmt.Sprint(rollup.Counters) != "map[clicks:1 country#nl:1 device#mobile:1]" {
+		t.Errorf("Unexpected daily rollup: %v", rollup.Counters)
+	}
+	if next, _ := store.Item(LinkDayKey("aB3xY", clickedAt.Add(time.Minute))); next.Counters[Clicks] != 1 || next.Counters["country#nl"] != 1 {
+		t.Errorf("Unexpected rollup for the next day: %v", next.Counters)
+	}
+	if owner, _ := store.Item(OwnerKey("team-a")); owner.Counters[Clicks] != 2 {
+		t.Errorf("Expected 2 clicks for the owner, got %v", owner.Counters)
+	}
+
+	// Test unreadable events and newer schemas are skipped, not retried
+	newer := eventbus.NewClickEvent(clickedAt)
+	newer.ShortCode = "aB3xY"
+	newer.SchemaVersion = eventbus.SchemaVersion + 1
+	newerData, _ := json.Marshal(newer)
+	handle(t, processor, events.SQSEvent{Records: []events.SQSMessage{
+		{EventSource: "aws:sqs", MessageId: "m2", Body: "not json"},
+		{EventSource: "aws:sqs", MessageId: "m3", Body: string(newerData)},
+	}})
+	if rollup, _ := store.Item(LinkDayKey("aB3xY", clickedAt)); rollup.Counters[Clicks] != 1 {
+		t.Errorf("Expected skipped events not to count, got %v", rollup.Counters)
+	}
+
+	// Test an unknown source is an error
+	if err := processor.Handle(context.Background(), json.RawMessage(`{"Records": [{"eventSource": "aws:s3"}]}`)); err == nil {
+		t.Errorf("Expected an error for an unknown event source")
+	}
+}
+
+type fakeDynamoDB struct {
+	input    *dynamodb.TransactWriteItemsInput
+	conflict bool
+}
+
+func (f *fakeDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.input = params
+	if f.conflict {
+		return nil, &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+			{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")},
+		}}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	client := &fakeDynamoDB{}
+	store := NewDynamoDBStore(client, "UrlShortenerAggregates")
+	updates := []Update{
+		{Key: OwnerKey("team-a"), Add: map[string]int{Links: 1, Clicks: 2}},
+		{Key: SearchKey("launch", "aB3xY"), Set: map[string]string{"shortCode": "aB3xY"}},
+		{Key: SearchKey("spring", "aB3xY"), Delete: true},
+	}
+
+	applied, err := store.Apply(context.Background(), "stream#1", updates)
+	if err != nil || !applied {
+		t.Fatalf("Expected the updates to be applied, got %v %v", applied, err)
+	}
+	items := client.input.TransactItems
+	if len(items) != 4 || aws.ToString(items[0].Put.ConditionExpression) != "attribute_not_exists(pk)" {
+		t.Fatalf("Expected a conditional checkpoint and 3 updates, got %+v", items)
+	}
+	if expression := aws.ToString(items[1].Update.UpdateExpression); expression != "ADD #a0 :v0, #a1 :v1" {
+		t.Errorf("Unexpected counter update: %s", expression)
+	}
+	if expression := aws.ToString(items[2].Update.UpdateExpression); !strings.HasPrefix(expression, "SET #a0 = :v0") {
+		t.Errorf("Unexpected attribute update: %s", expression)
+	}
+	if items[3].Delete == nil {
+		t.Errorf("Expected a delete, got %+v", items[3])
+	}
+
+	// Test a checkpoint that exists already cancels the transaction quietly
+	client.conflict = true
+	if applied, err := store.Apply(context.Background(), "stream#1", updates); err != nil || applied {
+		t.Errorf("Expected a record applied before to be skipped, got %v %v", applied, err)
+	}
+}
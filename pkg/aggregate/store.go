@@ -0,0 +1,215 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Most items a single TransactWriteItems request accepts
+const maxTransactItems = 100
+
+// DynamoDBAPI is the part of the DynamoDB client used by DynamoDBStore
+type DynamoDBAPI interface {
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// DynamoDBStore keeps aggregates in a DynamoDB table with a string
+// partition key "pk", a string sort key "sk" and TTL on "expiration"
+type DynamoDBStore struct {
+	client    DynamoDBAPI
+	tableName string
+	now       func() time.Time
+}
+
+// NewDynamoDBStore creates a store for the given table
+func NewDynamoDBStore(client DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName, now: time.Now}
+}
+
+// Apply writes the checkpoint and every update in one transaction. The
+// checkpoint is only written if it does not exist yet, so a record applied
+// before cancels the whole transaction.
+func (s *DynamoDBStore) Apply(ctx context.Context, checkpoint string, updates []Update) (bool, error) {
+	if len(updates)+1 > maxTransactItems {
+		return false, fmt.Errorf("%d updates do not fit in one transaction", len(updates))
+	}
+
+	items := []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName: aws.String(s.tableName),
+			Item: map[string]types.AttributeValue{
+				"pk":         &types.AttributeValueMemberS{Value: checkpointPrefix + checkpoint},
+				"sk":         &types.AttributeValueMemberS{Value: checkpointSK},
+				"expiration": &types.AttributeValueMemberN{Value: strconv.FormatInt(s.now().Add(CheckpointTTL).Unix(), 10)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(pk)"),
+		},
+	}}
+	for _, update := range updates {
+		items = append(items, s.transactItem(update))
+	}
+
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+			aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// transactItem turns an update into a Delete, or an Update that sets
+// attributes and adds to counters
+func (s *DynamoDBStore) transactItem(update Update) types.TransactWriteItem {
+	key := map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: update.Key.PK},
+		"sk": &types.AttributeValueMemberS{Value: update.Key.SK},
+	}
+	if update.Delete {
+		return types.TransactWriteItem{
+			Delete: &types.Delete{TableName: aws.String(s.tableName), Key: key},
+		}
+	}
+
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	var setClauses, addClauses []string
+	for _, name := range sortedKeys(update.Set) {
+		placeholder := strconv.Itoa(len(names))
+		names["#a"+placeholder] = name
+		values[":v"+placeholder] = &types.AttributeValueMemberS{Value: update.Set[name]}
+		setClauses = append(setClauses, "#a"+placeholder+" = :v"+placeholder)
+	}
+	for _, name := range sortedKeys(update.Add) {
+		placeholder := strconv.Itoa(len(names))
+		names["#a"+placeholder] = name
+		values[":v"+placeholder] = &types.AttributeValueMemberN{Value: strconv.Itoa(update.Add[name])}
+		addClauses = append(addClauses, "#a"+placeholder+" :v"+placeholder)
+	}
+
+	var expression []string
+	if len(setClauses) > 0 {
+		expression = append(expression, "SET "+strings.Join(setClauses, ", "))
+	}
+	if len(addClauses) > 0 {
+		expression = append(expression, "ADD "+strings.Join(addClauses, ", "))
+	}
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			TableName:                 aws.String(s.tableName),
+			Key:                       key,
+			UpdateExpression:          aws.String(strings.Join(expression, " ")),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Item is the content of an aggregates table item
+type Item struct {
+	Counters   map[string]int
+	Attributes map[string]string
+}
+
+// MemoryStore keeps aggregates in memory, for tests
+type MemoryStore struct {
+	mutex       sync.Mutex
+	checkpoints map[string]bool
+	items       map[Key]*Item
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		checkpoints: map[string]bool{},
+		items:       map[Key]*Item{},
+	}
+}
+
+// Apply makes the updates unless the checkpoint was applied before
+func (m *MemoryStore) Apply(ctx context.Context, checkpoint string, updates []Update) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.checkpoints[checkpoint] {
+		return false, nil
+	}
+	m.checkpoints[checkpoint] = true
+
+	for _, update := range updates {
+		if update.Delete {
+			delete(m.items, update.Key)
+			continue
+		}
+		item, ok := m.items[update.Key]
+		if !ok {
+			item = &Item{Counters: map[string]int{}, Attributes: map[string]string{}}
+			m.items[update.Key] = item
+		}
+		for name, value := range update.Set {
+			item.Attributes[name] = value
+		}
+		for name, n := range update.Add {
+			item.Counters[name] += n
+		}
+	}
+	return true, nil
+}
+
+// Item returns a copy of the item with the given key
+func (m *MemoryStore) Item(key Key) (Item, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	item, ok := m.items[key]
+	if !ok {
+		return Item{}, false
+	}
+	copied := Item{Counters: map[string]int{}, Attributes: map[string]string{}}
+	for name, n := range item.Counters {
+		copied.Counters[name] = n
+	}
+	for name, value := range item.Attributes {
+		copied.Attributes[name] = value
+	}
+	return copied, true
+}
+
+// Keys returns the sorted sort keys of the items under a partition key
+func (m *MemoryStore) Keys(pk string) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var keys []string
+	for key := range m.items {
+		if key.PK == pk {
+			keys = append(keys, key.SK)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,323 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/quota"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/workspace"
+)
+
+func seedDB(t *testing.T) database.DynamoDBInterface {
+	db := database.NewMockDynamoDB()
+	for _, urlItem := range []*model.URLItem{
+		{ShortCode: "aaaaa", OriginalURL: "https://example.com/a", CreatedAt: "2024-01-01T00:00:00Z", ClickCount: 3},
+		{
+			ShortCode: "bbbbb", OriginalURL: "https://example.com/b?x=1,2", CreatedAt: "2024-01-02T00:00:00Z", Expiration: 1735689600,
+			Campaign: "launch", Owner: "team-a", Disabled: true, Tags: []string{"promo", "q1"},
+			Metadata: map[string]string{"note": `say "hi"`},
+			Rules:    []model.RoutingRule{{Name: "ios", OS: "ios", URL: "https://apps.example.com"}},
+		},
+	} {
+		if err := db.CreateURL(context.Background(), urlItem); err != nil {
+			t.Fatalf("Failed to seed mock database: %v", err)
+		}
+	}
+	return db
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatJSONL, FormatCSV} {
+		source := seedDB(t)
+
+		var buf bytes.Buffer
+		count, err := Export(context.Background(), source, &buf, format, 2)
+		if err != nil {
+			t.Fatalf("Export(%s) returned an error: %v", format, err)
+		}
+		if count != 2 {
+			t.Errorf("Export(%s): expected 2 links, got %d", format, count)
+		}
+
+		target := database.NewMockDynamoDB()
+		result, err := Import(context.Background(), target, &buf, ImportOptions{Format: format})
+		if err != nil {
+			t.Fatalf("Import(%s) returned an error: %v", format, err)
+		}
+		if result.Created != 2 {
+			t.Errorf("Import(%s): expected 2 created, got %+v", format, result)
+		}
+
+		urlItem, err := target.GetURL(context.Background(), "bbbbb")
+		if err != nil {
+			t.Fatalf("Import(%s): expected bbbbb to exist: %v", format, err)
+		}
+		if urlItem.OriginalURL != "https://example.com/b?x=1,2" || urlItem.Expiration != 1735689600 {
+			t.Errorf("Import(%s): round trip mismatch, got %+v", format, urlItem)
+		}
+		if urlItem.Campaign != "launch" || urlItem.Owner != "team-a" || !urlItem.Disabled ||
+			strings.Join(urlItem.Tags, ",") != "promo,q1" || urlItem.Metadata["note"] != `say "hi"` ||
+			len(urlItem.Rules) != 1 || urlItem.Rules[0].URL != "https://apps.example.com" {
+			t.Errorf("Import(%s): expected every field to survive the round trip, got %+v", format, urlItem)
+		}
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	input := `{"shortCode":"aaaaa","originalURL":"https://new.example.com"}
+{"shortCode":"ccccc","originalURL":"https://example.com/c"}
+{"shortCode":"ddddd"}
+`
+
+	// Dry run reports changes without writing
+	db := seedDB(t)
+	result, err := Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL, DryRun: true})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 1 || result.Skipped != 1 || result.Invalid != 1 {
+		t.Errorf("Unexpected dry run result: %+v", result)
+	}
+	if _, err := db.GetURL(context.Background(), "ccccc"); err == nil {
+		t.Errorf("Dry run should not create links")
+	}
+
+	// Skip leaves the existing link untouched
+	result, err = Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 1 || result.Skipped != 1 {
+		t.Errorf("Unexpected skip result: %+v", result)
+	}
+	urlItem, _ := db.GetURL(context.Background(), "aaaaa")
+	if urlItem.OriginalURL != "https://example.com/a" {
+		t.Errorf("Skip should keep the existing link, got %s", urlItem.OriginalURL)
+	}
+
+	// Overwrite replaces it
+	result, err = Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL, OnConflict: ConflictOverwrite})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Overwritten != 2 {
+		t.Errorf("Unexpected overwrite result: %+v", result)
+	}
+	urlItem, _ = db.GetURL(context.Background(), "aaaaa")
+	if urlItem.OriginalURL != "https://new.example.com" {
+		t.Errorf("Overwrite should replace the link, got %s", urlItem.OriginalURL)
+	}
+
+	// Regenerate assigns new codes instead of conflicting
+	result, err = Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL, Codes: CodesRegenerate})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 2 || result.Skipped != 0 {
+		t.Errorf("Unexpected regenerate result: %+v", result)
+	}
+}
+
+func TestImportBitly(t *testing.T) {
+	input := "Created At,Title,Bitlink,Long URL,Tags\n" +
+		"2023-05-01 12:30:00,Launch,bit.ly/3xYzAbC,https://example.com/launch,promo\n" +
+		"not a date,,https://bit.ly/qwert,https://example.com/other,\n"
+
+	db := database.NewMockDynamoDB()
+	result, err := Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatBitly})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 2 {
+		t.Errorf("Expected 2 created, got %+v", result)
+	}
+
+	urlItem, err := db.GetURL(context.Background(), "3xYzAbC")
+	if err != nil {
+		t.Fatalf("Expected bitlink code to be kept: %v", err)
+	}
+	if urlItem.OriginalURL != "https://example.com/launch" {
+		t.Errorf("Unexpected original URL: %s", urlItem.OriginalURL)
+	}
+	if urlItem.CreatedAt != "2023-05-01T12:30:00Z" {
+		t.Errorf("Unexpected created at: %s", urlItem.CreatedAt)
+	}
+
+	urlItem, err = db.GetURL(context.Background(), "qwert")
+	if err != nil {
+		t.Fatalf("Expected bitlink code to be kept: %v", err)
+	}
+	if urlItem.CreatedAt == "" {
+		t.Errorf("Expected unparsable dates to fall back to the import time")
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	input := `{"shortCode":"ok-code_1","originalURL":"https://example.com/ok"}
+{"shortCode":"go.brand.com/abc","originalURL":"https://example.com/slash"}
+{"shortCode":"has space","originalURL":"https://example.com/space"}
+{"shortCode":"` + strings.Repeat("a", 65) + `","originalURL":"https://example.com/long"}
+{"shortCode":"badurl","originalURL":"javascript:alert(1)"}
+`
+
+	db := database.NewMockDynamoDB()
+	result, err := Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 1 || result.Invalid != 4 || len(result.Errors) != 4 {
+		t.Fatalf("Unexpected validation result: %+v", result)
+	}
+	if !strings.HasPrefix(result.Errors[0], "line 2: ") || !strings.HasPrefix(result.Errors[3], "line 5: ") {
+		t.Errorf("Expected errors to name their line, got %q", result.Errors)
+	}
+	if _, err := db.GetURL(context.Background(), "go.brand.com/abc"); err == nil {
+		t.Errorf("Expected a code on an unknown domain to be rejected")
+	}
+
+	// Regenerated codes replace invalid ones, but not unknown domains
+	result, err = Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL, Codes: CodesRegenerate})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 3 || result.Invalid != 2 {
+		t.Errorf("Unexpected regenerate result: %+v", result)
+	}
+}
+
+func TestImportWorkspaceLinks(t *testing.T) {
+	workspaces, err := workspace.Parse(`[{"name": "brand", "domains": ["go.brand.com"]}]`)
+	if err != nil {
+		t.Fatalf("Failed to parse workspaces: %v", err)
+	}
+	cfg := Config{Workspaces: workspaces}
+
+	input := `{"shortCode":"go.brand.com/abc","originalURL":"https://example.com/brand"}
+{"shortCode":"Go.Brand.com/upper","originalURL":"https://example.com/upper"}
+{"shortCode":"go.other.com/abc","originalURL":"https://example.com/other"}
+{"shortCode":"go.brand.com/a/b","originalURL":"https://example.com/nested"}
+`
+
+	db := database.NewMockDynamoDB()
+	result, err := Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL, Config: cfg})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 1 || result.Invalid != 3 {
+		t.Fatalf("Unexpected workspace import result: %+v", result)
+	}
+	if _, err := db.GetURL(context.Background(), "go.brand.com/abc"); err != nil {
+		t.Errorf("Expected the workspace link to keep its key: %v", err)
+	}
+
+	// Exported workspace links import back under the same keys
+	var exported bytes.Buffer
+	if _, err := Export(context.Background(), db, &exported, FormatJSONL, 1); err != nil {
+		t.Fatalf("Export returned an error: %v", err)
+	}
+	restored := database.NewMockDynamoDB()
+	result, err = Import(context.Background(), restored, &exported, ImportOptions{Format: FormatJSONL, Config: cfg})
+	if err != nil || result.Created != 1 {
+		t.Fatalf("Unexpected round-trip result: %+v, %v", result, err)
+	}
+	if _, err := restored.GetURL(context.Background(), "go.brand.com/abc"); err != nil {
+		t.Errorf("Expected the workspace link to survive a round trip: %v", err)
+	}
+
+	// Regenerated codes stay on the link's domain
+	regenerated := database.NewMockDynamoDB()
+	input = `{"shortCode":"go.brand.com/abc","originalURL":"https://example.com/brand"}
+`
+	result, err = Import(context.Background(), regenerated, strings.NewReader(input), ImportOptions{Format: FormatJSONL, Codes: CodesRegenerate, Config: cfg})
+	if err != nil || result.Created != 1 {
+		t.Fatalf("Unexpected regenerate result: %+v, %v", result, err)
+	}
+	var keys []string
+	err = regenerated.ScanURLs(context.Background(), 1, func(urlItem *model.URLItem) error {
+		keys = append(keys, urlItem.ShortCode)
+		return nil
+	})
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Expected one regenerated link, got %v, %v", keys, err)
+	}
+	if domain, code := database.SplitLinkKey(keys[0]); domain != "go.brand.com" || code == "abc" {
+		t.Errorf("Expected a new code on go.brand.com, got %s", keys[0])
+	}
+}
+
+func TestImportQuotas(t *testing.T) {
+	plans, err := quota.ParsePlans("free=0/1", "team-a=free", "")
+	if err != nil {
+		t.Fatalf("Failed to parse plans: %v", err)
+	}
+	input := `{"shortCode":"owned1","originalURL":"https://example.com/1","owner":"team-a"}
+{"shortCode":"owned2","originalURL":"https://example.com/2","owner":"team-a"}
+{"originalURL":"https://example.com/3","owner":"team-a"}
+{"shortCode":"anon","originalURL":"https://example.com/4"}
+`
+
+	db := database.NewMockDynamoDB()
+	result, err := Import(context.Background(), db, strings.NewReader(input), ImportOptions{Format: FormatJSONL, Config: Config{Quotas: plans}})
+	if err != nil {
+		t.Fatalf("Import returned an error: %v", err)
+	}
+	if result.Created != 2 || result.Invalid != 2 {
+		t.Fatalf("Unexpected quota result: %+v", result)
+	}
+	if !strings.HasPrefix(result.Errors[0], "line 2: ") {
+		t.Errorf("Expected the quota error to name its line, got %q", result.Errors)
+	}
+	monthly, active, err := db.GetUsage(context.Background(), "team-a", time.Now())
+	if err != nil || monthly != 1 || active != 1 {
+		t.Errorf("Expected imported links to count towards usage, got %d/%d, %v", monthly, active, err)
+	}
+
+	// Links created one at a time are held to the same quota
+	err = Create(context.Background(), db, &model.URLItem{OriginalURL: "https://example.com/5", Owner: "team-a"}, Config{Quotas: plans})
+	var quotaErr *database.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		t.Errorf("Expected a quota error, got %v", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	db := database.NewMockDynamoDB()
+
+	// Test a link without a code gets a generated one
+	urlItem := &model.URLItem{OriginalURL: "https://example.com", Tags: []string{"promo"}}
+	if err := Create(context.Background(), db, urlItem, Config{}); err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	if len(urlItem.ShortCode) != codeLength || urlItem.CreatedAt == "" {
+		t.Errorf("Expected a generated code and creation time, got %+v", urlItem)
+	}
+
+	// Test a custom code is kept, but never replaces an existing link
+	if err := Create(context.Background(), db, &model.URLItem{ShortCode: "launch", OriginalURL: "https://example.com"}, Config{}); err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	if err := Create(context.Background(), db, &model.URLItem{ShortCode: "launch", OriginalURL: "https://example.org"}, Config{}); err == nil {
+		t.Errorf("Expected an error for an existing code")
+	}
+
+	// Test links are validated like API requests
+	for _, invalid := range []*model.URLItem{
+		{OriginalURL: "example.com"},
+		{ShortCode: "a/b", OriginalURL: "https://example.com"},
+		{OriginalURL: "https://example.com", RedirectType: 303},
+		{OriginalURL: "https://example.com", ForwardQuery: "replace"},
+		{OriginalURL: "https://example.com", FallbackURL: "ftp://example.com"},
+		{OriginalURL: "https://example.com", MaxClicks: -1},
+		{OriginalURL: "https://example.com", ActiveFrom: 1735689600, Expiration: 1735689600},
+	} {
+		if err := Create(context.Background(), db, invalid, Config{}); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+)
+
+// ExportCommand runs the export subcommand shared by urlbulk and urlctl:
+// it parses args as flags and writes the export to a file or stdout
+func ExportCommand(ctx context.Context, db database.DynamoDBInterface, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", string(FormatJSONL), "output format: jsonl or csv")
+	segments := flags.Int("segments", DefaultSegments, "number of parallel scan segments")
+	outPath := flags.String("out", "-", "output file, - for stdout")
+	flags.Parse(args)
+
+	format, err := ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *outPath != "-" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := Export(ctx, db, w, format, *segments)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d links\n", count)
+	return nil
+}
+
+// ImportCommand runs the import subcommand shared by urlbulk and urlctl:
+// it parses args as flags, imports from a file or stdin and prints the
+// summary to stderr
+func ImportCommand(ctx context.Context, db database.DynamoDBInterface, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	formatName := flags.String("format", string(FormatJSONL), "input format: jsonl, csv or bitly")
+	codes := flags.String("codes", string(CodesKeep), "keep or regenerate short codes")
+	onConflict := flags.String("on-conflict", string(ConflictSkip), "skip or overwrite existing short codes")
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing")
+	inPath := flags.String("in", "-", "input file, - for stdin")
+	flags.Parse(args)
+
+	format, err := ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	if *codes != string(CodesKeep) && *codes != string(CodesRegenerate) {
+		return fmt.Errorf("invalid -codes value: %s", *codes)
+	}
+	if *onConflict != string(ConflictSkip) && *onConflict != string(ConflictOverwrite) {
+		return fmt.Errorf("invalid -on-conflict value: %s", *onConflict)
+	}
+
+	var r io.Reader = os.Stdin
+	if *inPath != "-" {
+		file, err := os.Open(*inPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	result, err := Import(ctx, db, r, ImportOptions{
+		Format:     format,
+		Codes:      CodeMode(*codes),
+		OnConflict: ConflictMode(*onConflict),
+		DryRun:     *dryRun,
+		Config:     cfg,
+	})
+
+	summary, _ := json.Marshal(result)
+	fmt.Fprintln(os.Stderr, string(summary))
+	return err
+}
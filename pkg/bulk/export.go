@@ -0,0 +1,196 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// Format is the file layout used for import and export
+type Format string
+
+const (
+	// FormatJSONL writes one JSON encoded URLItem per line
+	FormatJSONL Format = "jsonl"
+	// FormatCSV writes a header row followed by one row per URLItem
+	FormatCSV Format = "csv"
+	// FormatBitly reads the CSV layout produced by Bitly's link export (import only)
+	FormatBitly Format = "bitly"
+)
+
+// DefaultSegments is the number of parallel scan segments used for exports
+const DefaultSegments = 4
+
+// csvColumns is the column layout for CSV import and export: every URLItem
+// field, named after its JSON key and in declaration order, so a CSV export
+// imports back without losing anything. Lists and maps are written as JSON.
+var csvColumns = urlItemColumns()
+
+// csvColumn is a CSV column and the index of the URLItem field it holds
+type csvColumn struct {
+	name  string
+	field int
+}
+
+// urlItemColumns lists the columns of csvColumns
+func urlItemColumns() []csvColumn {
+	itemType := reflect.TypeOf(model.URLItem{})
+	columns := make([]csvColumn, 0, itemType.NumField())
+	for i := 0; i < itemType.NumField(); i++ {
+		name, _, _ := strings.Cut(itemType.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, csvColumn{name: name, field: i})
+	}
+	return columns
+}
+
+// ParseFormat converts a user supplied format name into a Format
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case FormatJSONL, FormatCSV, FormatBitly:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unsupported format: %s", name)
+}
+
+// Export streams every URL in the table to w in the given format and
+// returns the number of URLs written
+func Export(ctx context.Context, db database.DynamoDBInterface, w io.Writer, format Format, segments int) (int, error) {
+	if segments < 1 {
+		segments = DefaultSegments
+	}
+
+	var write func(urlItem *model.URLItem) error
+	var flush func() error
+
+	switch format {
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		write = func(urlItem *model.URLItem) error {
+			return encoder.Encode(urlItem)
+		}
+		flush = func() error { return nil }
+
+	case FormatCSV:
+		csvWriter := csv.NewWriter(w)
+		header := make([]string, len(csvColumns))
+		for i, col := range csvColumns {
+			header[i] = col.name
+		}
+		if err := csvWriter.Write(header); err != nil {
+			return 0, err
+		}
+		write = func(urlItem *model.URLItem) error {
+			record, err := toRecord(urlItem)
+			if err != nil {
+				return err
+			}
+			return csvWriter.Write(record)
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+
+	default:
+		return 0, fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	count := 0
+	err := db.ScanURLs(ctx, segments, func(urlItem *model.URLItem) error {
+		count++
+		return write(urlItem)
+	})
+	if err != nil {
+		logger.Error("Export failed", map[string]interface{}{
+			"error":    err.Error(),
+			"exported": count,
+		})
+		return count, err
+	}
+
+	if err := flush(); err != nil {
+		return count, err
+	}
+
+	logger.Info("Export complete", map[string]interface{}{
+		"format":   format,
+		"exported": count,
+	})
+	return count, nil
+}
+
+// toRecord converts a URL item into a CSV row matching csvColumns
+func toRecord(urlItem *model.URLItem) ([]string, error) {
+	item := reflect.ValueOf(urlItem).Elem()
+	record := make([]string, len(csvColumns))
+	for i, col := range csvColumns {
+		value := item.Field(col.field)
+		switch value.Kind() {
+		case reflect.String:
+			record[i] = value.String()
+		case reflect.Int, reflect.Int64:
+			record[i] = strconv.FormatInt(value.Int(), 10)
+		case reflect.Bool:
+			record[i] = strconv.FormatBool(value.Bool())
+		case reflect.Slice, reflect.Map:
+			if value.Len() == 0 {
+				continue
+			}
+			data, err := json.Marshal(value.Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", urlItem.ShortCode, err)
+			}
+			record[i] = string(data)
+		default:
+			return nil, fmt.Errorf("unsupported CSV column %s", col.name)
+		}
+	}
+	return record, nil
+}
+
+// fromRecord sets the fields of urlItem from a CSV row, matching columns by
+// their normalised header name. Empty cells leave fields unset.
+func fromRecord(urlItem *model.URLItem, record []string, columns map[string]int) error {
+	item := reflect.ValueOf(urlItem).Elem()
+	for _, col := range csvColumns {
+		text := column(record, columns, strings.ToLower(col.name))
+		if text == "" {
+			continue
+		}
+
+		value := item.Field(col.field)
+		switch value.Kind() {
+		case reflect.String:
+			value.SetString(text)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", col.name, text)
+			}
+			value.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(text)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", col.name, text)
+			}
+			value.SetBool(b)
+		case reflect.Slice, reflect.Map:
+			if err := json.Unmarshal([]byte(text), value.Addr().Interface()); err != nil {
+				return fmt.Errorf("invalid %s %q", col.name, text)
+			}
+		}
+	}
+	return nil
+}
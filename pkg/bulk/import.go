@@ -0,0 +1,414 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/quota"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/workspace"
+)
+
+// CodeMode controls whether imported short codes are kept or replaced
+type CodeMode string
+
+const (
+	// CodesKeep stores links under the short code found in the file
+	CodesKeep CodeMode = "keep"
+	// CodesRegenerate assigns a fresh random short code to every link
+	CodesRegenerate CodeMode = "regenerate"
+)
+
+// ConflictMode controls what happens when a short code already exists
+type ConflictMode string
+
+const (
+	// ConflictSkip leaves the existing link untouched
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite replaces the existing link with the imported one
+	ConflictOverwrite ConflictMode = "overwrite"
+)
+
+const (
+	// Length of short codes generated during import
+	codeLength = 5
+	// Number of attempts to find a free code when regenerating
+	maxCodeAttempts = 5
+)
+
+// Config holds the deployment settings links are checked against, as the
+// API function reads them from its environment
+type Config struct {
+	// Workspaces whose domains codes may be keyed under, as <domain>/<code>
+	Workspaces *workspace.Directory
+	// Quotas that new owned links count towards
+	Quotas *quota.Plans
+}
+
+// ConfigFromEnv reads the settings from the same environment variables as
+// the API function
+func ConfigFromEnv() (Config, error) {
+	workspaces, err := workspace.FromEnv()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid WORKSPACES: %v", err)
+	}
+	plans, err := quota.FromEnv()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid quotas: %v", err)
+	}
+	return Config{Workspaces: workspaces, Quotas: plans}, nil
+}
+
+// ImportOptions configures an import run
+type ImportOptions struct {
+	Format     Format
+	Codes      CodeMode
+	OnConflict ConflictMode
+	DryRun     bool
+
+	Config
+}
+
+// ImportResult summarises an import run
+type ImportResult struct {
+	Read        int `json:"read"`
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+	Invalid     int `json:"invalid"`
+
+	// Why each invalid record was rejected, with its line number
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Import reads links from r and stores them in the table according to opts
+func Import(ctx context.Context, db database.DynamoDBInterface, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	if opts.Codes == "" {
+		opts.Codes = CodesKeep
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+
+	result := &ImportResult{}
+	err := readItems(r, opts.Format, func(line int, urlItem *model.URLItem) error {
+		result.Read++
+
+		// Codes from the file are only checked when they are kept; links on
+		// a workspace domain stay on it
+		var domain string
+		if opts.Codes == CodesRegenerate {
+			domain, _ = database.SplitLinkKey(urlItem.ShortCode)
+			urlItem.ShortCode = ""
+		}
+		err := ValidateItem(urlItem, opts.Config)
+		if err == nil {
+			err = validateDomain(domain, opts.Config)
+		}
+		if err != nil {
+			logger.Warn("Skipping invalid import record", map[string]interface{}{
+				"line":  line,
+				"error": err.Error(),
+			})
+			result.Invalid++
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: %v", line, err))
+			return nil
+		}
+		if urlItem.CreatedAt == "" {
+			urlItem.CreatedAt = time.Now().Format(time.RFC3339)
+		}
+
+		if urlItem.ShortCode == "" {
+			err = importWithNewCode(ctx, db, urlItem, domain, opts, result)
+		} else {
+			err = importWithCode(ctx, db, urlItem, opts, result)
+		}
+
+		// An owner over quota only loses this record
+		var quotaErr *database.QuotaExceededError
+		if errors.As(err, &quotaErr) {
+			logger.Warn("Skipping import record over quota", map[string]interface{}{
+				"line":  line,
+				"owner": quotaErr.Owner,
+				"quota": quotaErr.Quota,
+			})
+			result.Invalid++
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: %v", line, err))
+			return nil
+		}
+		return err
+	})
+
+	logger.Info("Import finished", map[string]interface{}{
+		"result": result,
+		"dryRun": opts.DryRun,
+	})
+	return result, err
+}
+
+// ValidateItem checks a link the way the API checks a new one. Its short
+// code, when set, must be valid too, since it ends up in URL paths; a code
+// keyed under a domain must be on one of cfg's workspace domains.
+func ValidateItem(urlItem *model.URLItem, cfg Config) error {
+	if urlItem.OriginalURL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if !utils.IsHTTPURL(urlItem.OriginalURL) {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if urlItem.FallbackURL != "" && !utils.IsHTTPURL(urlItem.FallbackURL) {
+		return fmt.Errorf("fallback url must be an absolute http or https URL")
+	}
+	if urlItem.RedirectType != 0 && !utils.IsValidRedirectType(urlItem.RedirectType) {
+		return fmt.Errorf("redirect type must be one of 301, 302, 307 or 308")
+	}
+	if !utils.IsValidForwardQuery(urlItem.ForwardQuery) {
+		return fmt.Errorf("forward query must be merge or override")
+	}
+	if urlItem.MaxClicks < 0 {
+		return fmt.Errorf("max clicks must not be negative")
+	}
+	if urlItem.ActiveFrom > 0 && urlItem.Expiration > 0 && urlItem.ActiveFrom >= urlItem.Expiration {
+		return fmt.Errorf("active from must be before the expiration")
+	}
+	if urlItem.ShortCode != "" {
+		domain, code := database.SplitLinkKey(urlItem.ShortCode)
+		if err := utils.ValidateShortCode(code); err != nil {
+			return err
+		}
+		return validateDomain(domain, cfg)
+	}
+	return nil
+}
+
+// validateDomain checks that a link's domain, if it has one, is a
+// workspace domain written the way the API stores it
+func validateDomain(domain string, cfg Config) error {
+	if domain == "" {
+		return nil
+	}
+	if domain != strings.ToLower(domain) || cfg.Workspaces.ForDomain(domain) == nil {
+		return fmt.Errorf("domain %s is not a workspace domain", domain)
+	}
+	return nil
+}
+
+// Create validates a single link and stores it under its short code, or
+// under a freshly generated one when it has none. An existing link with
+// the same code is never replaced.
+func Create(ctx context.Context, db database.DynamoDBInterface, urlItem *model.URLItem, cfg Config) error {
+	if err := ValidateItem(urlItem, cfg); err != nil {
+		return err
+	}
+	if urlItem.CreatedAt == "" {
+		urlItem.CreatedAt = time.Now().Format(time.RFC3339)
+	}
+
+	if urlItem.ShortCode != "" {
+		if err := createURL(ctx, db, urlItem, cfg); err != nil {
+			return err
+		}
+		return indexTags(ctx, db, urlItem, nil)
+	}
+	if err := createWithNewCode(ctx, db, urlItem, "", cfg); err != nil {
+		return err
+	}
+	return indexTags(ctx, db, urlItem, nil)
+}
+
+// importWithCode stores a link under its existing short code, applying the conflict mode
+func importWithCode(ctx context.Context, db database.DynamoDBInterface, urlItem *model.URLItem, opts ImportOptions, result *ImportResult) error {
+	if opts.DryRun {
+		_, err := db.GetURL(ctx, urlItem.ShortCode)
+		switch {
+		case err == nil && opts.OnConflict == ConflictOverwrite:
+			result.Overwritten++
+		case err == nil:
+			result.Skipped++
+		case strings.Contains(err.Error(), "URL not found"):
+			result.Created++
+		default:
+			return err
+		}
+		return nil
+	}
+
+	err := createURL(ctx, db, urlItem, opts.Config)
+	if err == nil {
+		result.Created++
+		return indexTags(ctx, db, urlItem, nil)
+	}
+	if !strings.Contains(err.Error(), "URL already exists") {
+		return err
+	}
+
+	if opts.OnConflict != ConflictOverwrite {
+		result.Skipped++
+		return nil
+	}
+	existing, err := db.GetURL(ctx, urlItem.ShortCode)
+	if err != nil {
+		return err
+	}
+	if err := db.ReplaceURL(ctx, urlItem); err != nil {
+		return err
+	}
+	result.Overwritten++
+	return indexTags(ctx, db, urlItem, existing.Tags)
+}
+
+// createURL stores a new link unless its short code is taken. Owned links
+// count towards their owner's quotas, as they do when created through the
+// API, so the active link count stays in step when they are deleted.
+func createURL(ctx context.Context, db database.DynamoDBInterface, urlItem *model.URLItem, cfg Config) error {
+	if urlItem.Owner == "" {
+		return db.CreateURL(ctx, urlItem)
+	}
+	return db.CreateURLWithUsage(ctx, urlItem, cfg.Quotas.For(urlItem.Owner), time.Now())
+}
+
+// indexTags brings the tag index in line with an imported link
+func indexTags(ctx context.Context, db database.DynamoDBInterface, urlItem *model.URLItem, previous []string) error {
+	if len(urlItem.Tags) == 0 && len(previous) == 0 {
+		return nil
+	}
+	return db.SetURLTags(ctx, urlItem.ShortCode, previous, urlItem.Tags)
+}
+
+// importWithNewCode stores a link under a freshly generated short code on
+// domain
+func importWithNewCode(ctx context.Context, db database.DynamoDBInterface, urlItem *model.URLItem, domain string, opts ImportOptions, result *ImportResult) error {
+	if opts.DryRun {
+		result.Created++
+		return nil
+	}
+	if err := createWithNewCode(ctx, db, urlItem, domain, opts.Config); err != nil {
+		return err
+	}
+	result.Created++
+	return indexTags(ctx, db, urlItem, nil)
+}
+
+// createWithNewCode stores a link under the first free random short code
+// on domain, or on the default domain if it is ""
+func createWithNewCode(ctx context.Context, db database.DynamoDBInterface, urlItem *model.URLItem, domain string, cfg Config) error {
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := utils.GenerateShortCode(codeLength)
+		if err != nil {
+			return err
+		}
+		urlItem.ShortCode = database.LinkKey(domain, code)
+
+		err = createURL(ctx, db, urlItem, cfg)
+		if err == nil {
+			return nil
+		}
+		if !strings.Contains(err.Error(), "URL already exists") {
+			return err
+		}
+	}
+	return fmt.Errorf("could not find a free short code after %d attempts", maxCodeAttempts)
+}
+
+// readItems decodes r in the given format and calls fn with each record and
+// its line number
+func readItems(r io.Reader, format Format, fn func(line int, urlItem *model.URLItem) error) error {
+	switch format {
+	case FormatJSONL:
+		return readJSONL(r, fn)
+	case FormatCSV:
+		return readCSV(r, fn)
+	case FormatBitly:
+		return readBitly(r, fn)
+	}
+	return fmt.Errorf("unsupported import format: %s", format)
+}
+
+// readJSONL decodes one URLItem per line, ignoring blank lines
+func readJSONL(r io.Reader, fn func(line int, urlItem *model.URLItem) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var urlItem model.URLItem
+		if err := json.Unmarshal([]byte(text), &urlItem); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := fn(line, &urlItem); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readCSV decodes rows written by Export in CSV format. Columns are matched
+// by header name so their order does not matter.
+func readCSV(r io.Reader, fn func(line int, urlItem *model.URLItem) error) error {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := indexColumns(header)
+
+	line := 1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line++
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		var urlItem model.URLItem
+		if err := fromRecord(&urlItem, record, columns); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if err := fn(line, &urlItem); err != nil {
+			return err
+		}
+	}
+}
+
+// indexColumns maps normalised header names to their column index
+func indexColumns(header []string) map[string]int {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		name = strings.NewReplacer(" ", "", "_", "").Replace(name)
+		columns[name] = i
+	}
+	return columns
+}
+
+// column returns the trimmed value of the first matching column, or "" if none is present
+func column(record []string, columns map[string]int, names ...string) string {
+	for _, name := range names {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+	}
+	return ""
+}
@@ -2,38 +2,144 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/aggregate"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
 )
 
 const (
-	// Table name for DynamoDB
+	// Table name for DynamoDB, used when TABLE_NAME is not set
 	TableName = "UrlShortener"
+	// Global secondary index on the campaign attribute
+	CampaignIndexName = "campaign-index"
+	// Suffix of the tag index table name, used when TAG_TABLE_NAME is not set
+	TagTableSuffix = "Tags"
+	// Most keys a single BatchGetItem request accepts
+	maxBatchGetKeys = 100
+	// Suffixes of the webhook table names, used when WEBHOOK_TABLE_NAME and
+	// WEBHOOK_DELIVERY_TABLE_NAME are not set
+	WebhookTableSuffix         = "Webhooks"
+	WebhookDeliveryTableSuffix = "WebhookDeliveries"
 )
 
 // DynamoDBInterface defines the interface for DynamoDB operations
 type DynamoDBInterface interface {
 	GetClient(ctx context.Context) (*dynamodb.Client, error)
 	CreateURL(ctx context.Context, urlItem *model.URLItem) error
+	CreateURLWithUsage(ctx context.Context, urlItem *model.URLItem, quota model.Quota, now time.Time) error
+	GetUsage(ctx context.Context, owner string, now time.Time) (int, int, error)
 	GetURL(ctx context.Context, code string) (*model.URLItem, error)
-	IncrementClickCount(ctx context.Context, code string) error
+	IncrementClickCount(ctx context.Context, code string, breakdowns ...ClickBreakdown) error
+	ReplaceURL(ctx context.Context, urlItem *model.URLItem) error
+	UpdateURL(ctx context.Context, urlItem *model.URLItem) error
+	DeleteURL(ctx context.Context, code string) error
+	QueryURLsByCampaign(ctx context.Context, campaign string) ([]model.URLItem, error)
+	SetURLTags(ctx context.Context, code string, previous, tags []string) error
+	QueryURLsByTag(ctx context.Context, tag string) ([]model.URLItem, error)
+	GetUnlockFailures(ctx context.Context, code, client string) (int, int64, error)
+	RecordUnlockFailure(ctx context.Context, code, client string, expiration int64) (int, error)
+	ResetUnlockFailures(ctx context.Context, code, client string, lockedUntil int64) error
+	ScanURLs(ctx context.Context, segments int, fn func(urlItem *model.URLItem) error) error
+	MarkExpiryNotified(ctx context.Context, code string) (bool, error)
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	RecordWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, id string, limit int) ([]model.WebhookDelivery, error)
+}
+
+// Per-link click breakdown attributes
+const (
+	// RuleClicksAttribute counts clicks by the routing rule that fired
+	RuleClicksAttribute = "ruleClicks"
+	// CountryClicksAttribute counts clicks by visitor country
+	CountryClicksAttribute = "countryClicks"
+	// VariantClicksAttribute counts clicks by the A/B variant served
+	VariantClicksAttribute = "variantClicks"
+)
+
+// ClickBreakdown names a per-link map of click counters and the key in it
+// to increment alongside the total click count
+type ClickBreakdown struct {
+	Attribute string
+	Key       string
+}
+
+// LinkKey is the shortCode a link is stored under. Codes are unique per
+// domain: links on the default domain use the code itself, and links on a
+// workspace domain "<domain>/<code>".
+func LinkKey(domain, code string) string {
+	if domain == "" {
+		return code
+	}
+	return domain + "/" + code
+}
+
+// SplitLinkKey separates a stored shortCode into its domain, empty for the
+// default domain, and the code
+func SplitLinkKey(key string) (string, string) {
+	if domain, code, ok := strings.Cut(key, "/"); ok {
+		return domain, code
+	}
+	return "", key
 }
 
 // DynamoDB implements the DynamoDBInterface
 type DynamoDB struct {
-	client *dynamodb.Client
+	client                   *dynamodb.Client
+	tableName                string
+	tagTableName             string
+	webhookTableName         string
+	webhookDeliveryTableName string
+	aggregateTableName       string
 }
 
-// NewDynamoDB creates a new DynamoDB instance
+// NewDynamoDB creates a new DynamoDB instance using the table named by the
+// TABLE_NAME environment variable, or TableName if it is unset
 func NewDynamoDB(client *dynamodb.Client) DynamoDBInterface {
-	return &DynamoDB{client: client}
+	tableName := os.Getenv("TABLE_NAME")
+	if tableName == "" {
+		tableName = TableName
+	}
+	return NewDynamoDBWithTable(client, tableName)
+}
+
+// NewDynamoDBWithTable creates a new DynamoDB instance for the given table.
+// Tags are indexed in the table named by TAG_TABLE_NAME, or tableName
+// followed by TagTableSuffix if it is unset; the webhook tables and the
+// aggregates table, which holds quota usage, are found the same way.
+func NewDynamoDBWithTable(client *dynamodb.Client, tableName string) DynamoDBInterface {
+	return &DynamoDB{
+		client:                   client,
+		tableName:                tableName,
+		tagTableName:             tableNameFromEnv("TAG_TABLE_NAME", tableName+TagTableSuffix),
+		webhookTableName:         tableNameFromEnv("WEBHOOK_TABLE_NAME", tableName+WebhookTableSuffix),
+		webhookDeliveryTableName: tableNameFromEnv("WEBHOOK_DELIVERY_TABLE_NAME", tableName+WebhookDeliveryTableSuffix),
+		aggregateTableName:       tableNameFromEnv("AGGREGATE_TABLE_NAME", tableName+aggregate.TableSuffix),
+	}
+}
+
+// tableNameFromEnv returns the table named by an environment variable, or
+// fallback if it is unset
+func tableNameFromEnv(variable, fallback string) string {
+	if name := os.Getenv(variable); name != "" {
+		return name
+	}
+	return fallback
 }
 
 // GetClient returns the DynamoDB client
@@ -55,7 +161,9 @@ func (d *DynamoDB) GetClient(ctx context.Context) (*dynamodb.Client, error) {
 	return d.client, nil
 }
 
-// CreateURL creates a new URL in DynamoDB
+// CreateURL creates a new URL in DynamoDB. A URL that already has the
+// short code is never replaced; an "URL already exists" error is returned
+// instead, so the caller can draw another code.
 func (d *DynamoDB) CreateURL(ctx context.Context, urlItem *model.URLItem) error {
 	logger.Debug("Creating URL in DynamoDB", map[string]interface{}{
 		"shortCode": urlItem.ShortCode,
@@ -67,7 +175,7 @@ func (d *DynamoDB) CreateURL(ctx context.Context, urlItem *model.URLItem) error
 	}
 	
 	// Marshal URL item to DynamoDB attribute values
-	av, err := attributevalue.MarshalMap(urlItem)
+	av, err := marshalURLItem(urlItem)
 	if err != nil {
 		logger.Error("Failed to marshal URL item", map[string]interface{}{
 			"error": err.Error(),
@@ -78,23 +186,56 @@ func (d *DynamoDB) CreateURL(ctx context.Context, urlItem *model.URLItem) error
 
 	// Put item into DynamoDB
 	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
-		Item:      av,
+		TableName:           aws.String(d.tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(shortCode)"),
 	})
 	
 	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("URL already exists for code: %s", urlItem.ShortCode)
+		}
 		logger.Error("Failed to put item in DynamoDB", map[string]interface{}{
 			"error":     err.Error(),
 			"shortCode": urlItem.ShortCode,
-			"tableName": TableName,
+			"tableName": d.tableName,
 		})
 		return err
 	}
 	
 	logger.Debug("Successfully created URL in DynamoDB", map[string]interface{}{
 		"shortCode": urlItem.ShortCode,
-		"tableName": TableName,
+		"tableName": d.tableName,
+	})
+	return nil
+}
+
+// ReplaceURL stores a URL whether or not one with the same short code
+// exists, replacing it entirely, click count included
+func (d *DynamoDB) ReplaceURL(ctx context.Context, urlItem *model.URLItem) error {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	av, err := marshalURLItem(urlItem)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.tableName),
+		Item:      av,
 	})
+	if err != nil {
+		logger.Error("Failed to replace item in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": urlItem.ShortCode,
+			"tableName": d.tableName,
+		})
+		return err
+	}
 	return nil
 }
 
@@ -102,7 +243,7 @@ func (d *DynamoDB) CreateURL(ctx context.Context, urlItem *model.URLItem) error
 func (d *DynamoDB) GetURL(ctx context.Context, code string) (*model.URLItem, error) {
 	logger.Debug("Getting URL from DynamoDB", map[string]interface{}{
 		"shortCode": code,
-		"tableName": TableName,
+		"tableName": d.tableName,
 	})
 	
 	client, err := d.GetClient(ctx)
@@ -124,14 +265,14 @@ func (d *DynamoDB) GetURL(ctx context.Context, code string) (*model.URLItem, err
 
 	// Get item from DynamoDB
 	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(d.tableName),
 		Key:       key,
 	})
 	if err != nil {
 		logger.Error("Failed to get item from DynamoDB", map[string]interface{}{
 			"error":     err.Error(),
 			"shortCode": code,
-			"tableName": TableName,
+			"tableName": d.tableName,
 		})
 		return nil, err
 	}
@@ -139,7 +280,7 @@ func (d *DynamoDB) GetURL(ctx context.Context, code string) (*model.URLItem, err
 	if len(result.Item) == 0 {
 		logger.Warn("URL not found in DynamoDB", map[string]interface{}{
 			"shortCode": code,
-			"tableName": TableName,
+			"tableName": d.tableName,
 		})
 		return nil, fmt.Errorf("URL not found for code: %s", code)
 	}
@@ -157,16 +298,16 @@ func (d *DynamoDB) GetURL(ctx context.Context, code string) (*model.URLItem, err
 
 	logger.Debug("Successfully retrieved URL from DynamoDB", map[string]interface{}{
 		"shortCode": code,
-		"tableName": TableName,
+		"tableName": d.tableName,
 	})
 	return &urlItem, nil
 }
 
 // IncrementClickCount increments the click count for a URL
-func (d *DynamoDB) IncrementClickCount(ctx context.Context, code string) error {
+func (d *DynamoDB) IncrementClickCount(ctx context.Context, code string, breakdowns ...ClickBreakdown) error {
 	logger.Debug("Incrementing click count in DynamoDB", map[string]interface{}{
 		"shortCode": code,
-		"tableName": TableName,
+		"tableName": d.tableName,
 	})
 	
 	client, err := d.GetClient(ctx)
@@ -185,27 +326,562 @@ func (d *DynamoDB) IncrementClickCount(ctx context.Context, code string) error {
 		return err
 	}
 
-	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(TableName),
-		Key:       key,
-		UpdateExpression: aws.String("SET clickCount = clickCount + :inc"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":inc": &types.AttributeValueMemberN{Value: "1"},
-		},
-	})
+	// Breakdown maps are created with the link, so their counters can be
+	// set in place
+	sets := []string{"clickCount = clickCount + :inc"}
+	var names map[string]string
+	values := map[string]types.AttributeValue{
+		":inc": &types.AttributeValueMemberN{Value: "1"},
+	}
+	if len(breakdowns) > 0 {
+		names = make(map[string]string, 2*len(breakdowns))
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+		for i, breakdown := range breakdowns {
+			attr, mapKey := fmt.Sprintf("#b%d", i), fmt.Sprintf("#k%d", i)
+			names[attr], names[mapKey] = breakdown.Attribute, breakdown.Key
+			sets = append(sets, fmt.Sprintf("%s.%s = if_not_exists(%s.%s, :zero) + :inc", attr, mapKey, attr, mapKey))
+		}
+	}
+
+	// The condition makes max_clicks atomic: concurrent redirects cannot
+	// push the count past the limit
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.tableName),
+		Key:                       key,
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(shortCode) AND (attribute_not_exists(maxClicks) OR clickCount < maxClicks)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	_, err = client.UpdateItem(ctx, input)
+
+	// Links created before breakdown maps were added with the link lack
+	// them, which DynamoDB reports as an invalid document path. Only then
+	// are the missing maps created and the update tried once more.
+	if err != nil && len(breakdowns) > 0 && isMissingDocumentPath(err) {
+		if initErr := d.initClickBreakdowns(ctx, client, code, key, breakdowns); initErr != nil {
+			return initErr
+		}
+		_, err = client.UpdateItem(ctx, input)
+	}
 	
 	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			logger.Warn("Click limit reached or URL missing", map[string]interface{}{
+				"shortCode": code,
+				"tableName": d.tableName,
+			})
+			return fmt.Errorf("click limit reached for code: %s", code)
+		}
+
 		logger.Error("Failed to update click count in DynamoDB", map[string]interface{}{
 			"error":     err.Error(),
 			"shortCode": code,
-			"tableName": TableName,
+			"tableName": d.tableName,
 		})
 		return err
 	}
 	
 	logger.Debug("Successfully incremented click count in DynamoDB", map[string]interface{}{
 		"shortCode": code,
-		"tableName": TableName,
+		"tableName": d.tableName,
+	})
+	return nil
+}
+
+// isMissingDocumentPath reports whether an update failed because a map it
+// sets a key in does not exist
+func isMissingDocumentPath(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException" &&
+		strings.Contains(apiErr.ErrorMessage(), "document path provided in the update expression is invalid")
+}
+
+// marshalURLItem converts a link into a DynamoDB item, with an empty map
+// for every click breakdown it does not count yet so that
+// IncrementClickCount can set counters inside them
+func marshalURLItem(urlItem *model.URLItem) (map[string]types.AttributeValue, error) {
+	av, err := attributevalue.MarshalMap(urlItem)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range []string{RuleClicksAttribute, CountryClicksAttribute, VariantClicksAttribute} {
+		if _, ok := av[attr]; !ok {
+			av[attr] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+		}
+	}
+	return av, nil
+}
+
+// initClickBreakdowns creates any missing click breakdown maps on a link
+// without touching maps that already exist
+func (d *DynamoDB) initClickBreakdowns(ctx context.Context, client *dynamodb.Client, code string, key map[string]types.AttributeValue, breakdowns []ClickBreakdown) error {
+	var sets []string
+	names := make(map[string]string, len(breakdowns))
+	for i, breakdown := range breakdowns {
+		attr := fmt.Sprintf("#b%d", i)
+		names[attr] = breakdown.Attribute
+		sets = append(sets, fmt.Sprintf("%s = if_not_exists(%s, :empty)", attr, attr))
+	}
+
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.tableName),
+		Key:                      key,
+		UpdateExpression:         aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:      aws.String("attribute_exists(shortCode)"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("URL not found for code: %s", code)
+		}
+		logger.Error("Failed to create click breakdowns", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": code,
+			"tableName": d.tableName,
+		})
+	}
+	return err
+}
+
+// ScanURLs walks every URL in the table using a parallel scan with the given
+// number of segments. fn is never called concurrently.
+func (d *DynamoDB) ScanURLs(ctx context.Context, segments int, fn func(urlItem *model.URLItem) error) error {
+	if segments < 1 {
+		segments = 1
+	}
+
+	logger.Debug("Scanning URLs in DynamoDB", map[string]interface{}{
+		"segments":  segments,
+		"tableName": d.tableName,
+	})
+
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		mutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mutex.Unlock()
+		cancel()
+	}
+
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+
+			paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+				TableName:     aws.String(d.tableName),
+				Segment:       aws.Int32(int32(segment)),
+				TotalSegments: aws.Int32(int32(segments)),
+			})
+
+			for paginator.HasMorePages() {
+				page, err := paginator.NextPage(ctx)
+				if err != nil {
+					logger.Error("Failed to scan DynamoDB segment", map[string]interface{}{
+						"error":     err.Error(),
+						"segment":   segment,
+						"tableName": d.tableName,
+					})
+					fail(err)
+					return
+				}
+
+				var urlItems []model.URLItem
+				if err := attributevalue.UnmarshalListOfMaps(page.Items, &urlItems); err != nil {
+					logger.Error("Failed to unmarshal scanned items", map[string]interface{}{
+						"error":   err.Error(),
+						"segment": segment,
+					})
+					fail(err)
+					return
+				}
+
+				mutex.Lock()
+				if firstErr != nil {
+					mutex.Unlock()
+					return
+				}
+				for i := range urlItems {
+					if err := fn(&urlItems[i]); err != nil {
+						firstErr = err
+						mutex.Unlock()
+						cancel()
+						return
+					}
+				}
+				mutex.Unlock()
+			}
+		}(segment)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// UpdateURL updates the editable attributes of an existing URL. The click
+// count is left alone so concurrent redirects are not lost.
+func (d *DynamoDB) UpdateURL(ctx context.Context, urlItem *model.URLItem) error {
+	logger.Debug("Updating URL in DynamoDB", map[string]interface{}{
+		"shortCode": urlItem.ShortCode,
+		"tableName": d.tableName,
+	})
+
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := attributevalue.MarshalMap(map[string]string{
+		"shortCode": urlItem.ShortCode,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Optional attributes are set when they have a value and removed
+	// otherwise. Names go through placeholders since several, like
+	// "description", are DynamoDB reserved words.
+	attributes := []struct {
+		name    string
+		value   interface{}
+		present bool
+	}{
+		{"originalURL", urlItem.OriginalURL, true},
+		{"disabled", urlItem.Disabled, true},
+		{"forwardPath", urlItem.ForwardPath, true},
+		{"interstitial", urlItem.Interstitial, true},
+		{"expiration", urlItem.Expiration, urlItem.Expiration > 0},
+		{"activeFrom", urlItem.ActiveFrom, urlItem.ActiveFrom > 0},
+		{"maxClicks", urlItem.MaxClicks, urlItem.MaxClicks > 0},
+		{"passwordHash", urlItem.PasswordHash, urlItem.PasswordHash != ""},
+		{"statsTokenHash", urlItem.StatsTokenHash, urlItem.StatsTokenHash != ""},
+		{"fallbackURL", urlItem.FallbackURL, urlItem.FallbackURL != ""},
+		{"redirectType", urlItem.RedirectType, urlItem.RedirectType != 0},
+		{"forwardQuery", urlItem.ForwardQuery, urlItem.ForwardQuery != ""},
+		{"campaign", urlItem.Campaign, urlItem.Campaign != ""},
+		{"title", urlItem.Title, urlItem.Title != ""},
+		{"description", urlItem.Description, urlItem.Description != ""},
+		{"ogTitle", urlItem.OGTitle, urlItem.OGTitle != ""},
+		{"ogDescription", urlItem.OGDescription, urlItem.OGDescription != ""},
+		{"ogImage", urlItem.OGImage, urlItem.OGImage != ""},
+		{"rules", urlItem.Rules, len(urlItem.Rules) > 0},
+		{"geo", urlItem.Geo, len(urlItem.Geo) > 0},
+		{"variants", urlItem.Variants, len(urlItem.Variants) > 0},
+		{"sticky", urlItem.Sticky, urlItem.Sticky},
+		{"schedule", urlItem.Schedule, len(urlItem.Schedule) > 0},
+		{"scheduleTimezone", urlItem.ScheduleTimezone, urlItem.ScheduleTimezone != ""},
+		{"tags", urlItem.Tags, len(urlItem.Tags) > 0},
+		{"metadata", urlItem.Metadata, len(urlItem.Metadata) > 0},
+	}
+
+	var set, removed []string
+	names := make(map[string]string, len(attributes))
+	values := make(map[string]types.AttributeValue, len(attributes))
+	for _, attr := range attributes {
+		names["#"+attr.name] = attr.name
+		if !attr.present {
+			removed = append(removed, "#"+attr.name)
+			continue
+		}
+		value, err := attributevalue.Marshal(attr.value)
+		if err != nil {
+			return err
+		}
+		set = append(set, fmt.Sprintf("#%s = :%s", attr.name, attr.name))
+		values[":"+attr.name] = value
+	}
+	updateExpression := "SET " + strings.Join(set, ", ")
+	if len(removed) > 0 {
+		updateExpression += " REMOVE " + strings.Join(removed, ", ")
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.tableName),
+		Key:                       key,
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String("attribute_exists(shortCode)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("URL not found for code: %s", urlItem.ShortCode)
+		}
+
+		logger.Error("Failed to update URL in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": urlItem.ShortCode,
+			"tableName": d.tableName,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// DeleteURL deletes a URL by its short code
+func (d *DynamoDB) DeleteURL(ctx context.Context, code string) error {
+	logger.Debug("Deleting URL from DynamoDB", map[string]interface{}{
+		"shortCode": code,
+		"tableName": d.tableName,
+	})
+
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := attributevalue.MarshalMap(map[string]string{
+		"shortCode": code,
+	})
+	if err != nil {
+		return err
+	}
+
+	result, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.tableName),
+		Key:                 key,
+		ConditionExpression: aws.String("attribute_exists(shortCode)"),
+		ReturnValues:        types.ReturnValueAllOld,
 	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("URL not found for code: %s", code)
+		}
+
+		logger.Error("Failed to delete URL from DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": code,
+			"tableName": d.tableName,
+		})
+		return err
+	}
+
+	// Drop the link from the tag index; stale entries are skipped by
+	// QueryURLsByTag, so a failure here is only logged
+	var deleted model.URLItem
+	if err := attributevalue.UnmarshalMap(result.Attributes, &deleted); err == nil && len(deleted.Tags) > 0 {
+		if err := d.SetURLTags(ctx, code, deleted.Tags, nil); err != nil {
+			logger.Warn("Failed to remove deleted URL from tag index", map[string]interface{}{
+				"error":     err.Error(),
+				"shortCode": code,
+			})
+		}
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+// QueryURLsByCampaign returns every URL tagged with the given campaign using
+// the campaign index
+func (d *DynamoDB) QueryURLsByCampaign(ctx context.Context, campaign string) ([]model.URLItem, error) {
+	logger.Debug("Querying URLs by campaign", map[string]interface{}{
+		"campaign":  campaign,
+		"tableName": d.tableName,
+	})
+
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+		TableName:              aws.String(d.tableName),
+		IndexName:              aws.String(CampaignIndexName),
+		KeyConditionExpression: aws.String("campaign = :campaign"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":campaign": &types.AttributeValueMemberS{Value: campaign},
+		},
+	})
+
+	var urlItems []model.URLItem
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.Error("Failed to query campaign index", map[string]interface{}{
+				"error":     err.Error(),
+				"campaign":  campaign,
+				"tableName": d.tableName,
+			})
+			return nil, err
+		}
+
+		var pageItems []model.URLItem
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageItems); err != nil {
+			return nil, err
+		}
+		urlItems = append(urlItems, pageItems...)
+	}
+
+	return urlItems, nil
+}
+
+// SetURLTags updates the tag index for a link from its previous tags to its
+// current ones. Every current tag is rewritten, so calling it again repairs
+// missing entries. Index entries are written in a single transaction.
+func (d *DynamoDB) SetURLTags(ctx context.Context, code string, previous, tags []string) error {
+	current := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		current[tag] = true
+	}
+	old := make(map[string]bool, len(previous))
+	for _, tag := range previous {
+		old[tag] = true
+	}
+
+	var items []types.TransactWriteItem
+	for tag := range current {
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(d.tagTableName),
+				Item:      tagKey(tag, code),
+			},
+		})
+	}
+	for tag := range old {
+		if current[tag] {
+			continue
+		}
+		items = append(items, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(d.tagTableName),
+				Key:       tagKey(tag, code),
+			},
+		})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	logger.Debug("Updating tag index", map[string]interface{}{
+		"shortCode":    code,
+		"changes":      len(items),
+		"tagTableName": d.tagTableName,
+	})
+
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		logger.Error("Failed to update tag index", map[string]interface{}{
+			"error":        err.Error(),
+			"shortCode":    code,
+			"tagTableName": d.tagTableName,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// QueryURLsByTag returns every link carrying tag, ordered by short code
+func (d *DynamoDB) QueryURLsByTag(ctx context.Context, tag string) ([]model.URLItem, error) {
+	logger.Debug("Querying URLs by tag", map[string]interface{}{
+		"tag":          tag,
+		"tagTableName": d.tagTableName,
+	})
+
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+		TableName:              aws.String(d.tagTableName),
+		KeyConditionExpression: aws.String("#tag = :tag"),
+		ExpressionAttributeNames: map[string]string{
+			"#tag": "tag",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tag": &types.AttributeValueMemberS{Value: tag},
+		},
+	})
+
+	var keys []map[string]types.AttributeValue
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.Error("Failed to query tag index", map[string]interface{}{
+				"error":        err.Error(),
+				"tag":          tag,
+				"tagTableName": d.tagTableName,
+			})
+			return nil, err
+		}
+		for _, item := range page.Items {
+			keys = append(keys, map[string]types.AttributeValue{
+				"shortCode": item["shortCode"],
+			})
+		}
+	}
+
+	// Load the links themselves, at most maxBatchGetKeys per request
+	var urlItems []model.URLItem
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := min(start+maxBatchGetKeys, len(keys))
+		requests := map[string]types.KeysAndAttributes{
+			d.tableName: {Keys: keys[start:end]},
+		}
+		for len(requests) > 0 {
+			result, err := client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requests,
+			})
+			if err != nil {
+				logger.Error("Failed to load tagged URLs", map[string]interface{}{
+					"error":     err.Error(),
+					"tag":       tag,
+					"tableName": d.tableName,
+				})
+				return nil, err
+			}
+
+			var batch []model.URLItem
+			if err := attributevalue.UnmarshalListOfMaps(result.Responses[d.tableName], &batch); err != nil {
+				return nil, err
+			}
+			urlItems = append(urlItems, batch...)
+			requests = result.UnprocessedKeys
+		}
+	}
+
+	sort.Slice(urlItems, func(i, j int) bool {
+		return urlItems[i].ShortCode < urlItems[j].ShortCode
+	})
+	return urlItems, nil
+}
+
+// tagKey is the key of a tag index entry: the tag plus the tagged short code
+func tagKey(tag, code string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"tag":       &types.AttributeValueMemberS{Value: tag},
+		"shortCode": &types.AttributeValueMemberS{Value: code},
+	}
+}
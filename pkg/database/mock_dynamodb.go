@@ -3,23 +3,43 @@ package database
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/aggregate"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
 )
 
 // MockDynamoDB is a mock implementation of DynamoDB for testing
 type MockDynamoDB struct {
-	urls     map[string]*model.URLItem
-	mutex    sync.RWMutex
-	failNext bool
+	urls       map[string]*model.URLItem
+	tags       map[string]map[string]bool
+	webhooks   map[string]model.Webhook
+	deliveries map[string][]model.WebhookDelivery
+	usage      map[aggregate.Key]int
+	unlocks    map[aggregate.Key]mockUnlockState
+	mutex      sync.RWMutex
+	failNext   bool
+	takenNext  int
+}
+
+// mockUnlockState is a client's failed unlock attempts on a link
+type mockUnlockState struct {
+	failures    int
+	lockedUntil int64
 }
 
 // NewMockDynamoDB creates a new mock DynamoDB client
 func NewMockDynamoDB() DynamoDBInterface {
 	return &MockDynamoDB{
-		urls: make(map[string]*model.URLItem),
+		urls:       make(map[string]*model.URLItem),
+		tags:       make(map[string]map[string]bool),
+		webhooks:   make(map[string]model.Webhook),
+		deliveries: make(map[string][]model.WebhookDelivery),
+		usage:      make(map[aggregate.Key]int),
+		unlocks:    make(map[aggregate.Key]mockUnlockState),
 	}
 }
 
@@ -28,6 +48,22 @@ func (m *MockDynamoDB) SetFailNext(fail bool) {
 	m.failNext = fail
 }
 
+// SetTakenNext makes the next n link creations find their short code taken
+func (m *MockDynamoDB) SetTakenNext(n int) {
+	m.takenNext = n
+}
+
+// codeTaken reports whether a new link's short code is in use, or is
+// made to look taken by SetTakenNext
+func (m *MockDynamoDB) codeTaken(code string) bool {
+	if m.takenNext > 0 {
+		m.takenNext--
+		return true
+	}
+	_, exists := m.urls[code]
+	return exists
+}
+
 // GetClient returns a mock DynamoDB client
 func (m *MockDynamoDB) GetClient(ctx context.Context) (*dynamodb.Client, error) {
 	if m.failNext {
@@ -49,18 +85,32 @@ func (m *MockDynamoDB) CreateURL(ctx context.Context, urlItem *model.URLItem) er
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	
-	// Store a copy of the URL item
-	m.urls[urlItem.ShortCode] = &model.URLItem{
-		ShortCode:   urlItem.ShortCode,
-		OriginalURL: urlItem.OriginalURL,
-		CreatedAt:   urlItem.CreatedAt,
-		Expiration:  urlItem.Expiration,
-		ClickCount:  urlItem.ClickCount,
+	if m.codeTaken(urlItem.ShortCode) {
+		return fmt.Errorf("URL already exists for code: %s", urlItem.ShortCode)
 	}
+
+	// Store a copy of the URL item
+	copied := *urlItem
+	m.urls[urlItem.ShortCode] = &copied
 	
 	return nil
 }
 
+// ReplaceURL mocks an unconditional put
+func (m *MockDynamoDB) ReplaceURL(ctx context.Context, urlItem *model.URLItem) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to replace URL")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	copied := *urlItem
+	m.urls[urlItem.ShortCode] = &copied
+	return nil
+}
+
 // GetURL mocks retrieving a URL from DynamoDB
 func (m *MockDynamoDB) GetURL(ctx context.Context, code string) (*model.URLItem, error) {
 	if m.failNext {
@@ -77,17 +127,12 @@ func (m *MockDynamoDB) GetURL(ctx context.Context, code string) (*model.URLItem,
 	}
 	
 	// Return a copy of the URL item
-	return &model.URLItem{
-		ShortCode:   urlItem.ShortCode,
-		OriginalURL: urlItem.OriginalURL,
-		CreatedAt:   urlItem.CreatedAt,
-		Expiration:  urlItem.Expiration,
-		ClickCount:  urlItem.ClickCount,
-	}, nil
+	copied := *urlItem
+	return &copied, nil
 }
 
 // IncrementClickCount mocks incrementing the click count
-func (m *MockDynamoDB) IncrementClickCount(ctx context.Context, code string) error {
+func (m *MockDynamoDB) IncrementClickCount(ctx context.Context, code string, breakdowns ...ClickBreakdown) error {
 	if m.failNext {
 		m.failNext = false
 		return fmt.Errorf("mock error: failed to increment click count")
@@ -101,6 +146,387 @@ func (m *MockDynamoDB) IncrementClickCount(ctx context.Context, code string) err
 		return fmt.Errorf("URL not found for code: %s", code)
 	}
 	
+	if urlItem.MaxClicks > 0 && urlItem.ClickCount >= urlItem.MaxClicks {
+		return fmt.Errorf("click limit reached for code: %s", code)
+	}
+
 	urlItem.ClickCount++
+	for _, breakdown := range breakdowns {
+		counters := breakdownCounters(urlItem, breakdown.Attribute)
+		if counters == nil {
+			return fmt.Errorf("mock error: unknown click breakdown %s", breakdown.Attribute)
+		}
+		if *counters == nil {
+			*counters = make(map[string]int)
+		}
+		(*counters)[breakdown.Key]++
+	}
+	return nil
+}
+
+// breakdownCounters returns the URLItem field stored under a click
+// breakdown attribute
+func breakdownCounters(urlItem *model.URLItem, attribute string) *map[string]int {
+	switch attribute {
+	case RuleClicksAttribute:
+		return &urlItem.RuleClicks
+	case CountryClicksAttribute:
+		return &urlItem.CountryClicks
+	case VariantClicksAttribute:
+		return &urlItem.VariantClicks
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// ScanURLs mocks a table scan, visiting URLs in short code order
+func (m *MockDynamoDB) ScanURLs(ctx context.Context, segments int, fn func(urlItem *model.URLItem) error) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to scan URLs")
+	}
+
+	m.mutex.RLock()
+	urlItems := make([]model.URLItem, 0, len(m.urls))
+	for _, urlItem := range m.urls {
+		urlItems = append(urlItems, *urlItem)
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(urlItems, func(i, j int) bool {
+		return urlItems[i].ShortCode < urlItems[j].ShortCode
+	})
+
+	for i := range urlItems {
+		if err := fn(&urlItems[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateURL mocks updating the editable attributes of a URL
+func (m *MockDynamoDB) UpdateURL(ctx context.Context, urlItem *model.URLItem) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to update URL")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.urls[urlItem.ShortCode]
+	if !exists {
+		return fmt.Errorf("URL not found for code: %s", urlItem.ShortCode)
+	}
+
+	existing.OriginalURL = urlItem.OriginalURL
+	existing.Expiration = urlItem.Expiration
+	existing.ActiveFrom = urlItem.ActiveFrom
+	existing.MaxClicks = urlItem.MaxClicks
+	existing.PasswordHash = urlItem.PasswordHash
+	existing.StatsTokenHash = urlItem.StatsTokenHash
+	existing.Disabled = urlItem.Disabled
+	existing.FallbackURL = urlItem.FallbackURL
+	existing.RedirectType = urlItem.RedirectType
+	existing.ForwardQuery = urlItem.ForwardQuery
+	existing.ForwardPath = urlItem.ForwardPath
+	existing.Campaign = urlItem.Campaign
+	existing.Interstitial = urlItem.Interstitial
+	existing.Title = urlItem.Title
+	existing.Description = urlItem.Description
+	existing.Tags = urlItem.Tags
+	existing.Metadata = urlItem.Metadata
+	existing.OGTitle = urlItem.OGTitle
+	existing.OGDescription = urlItem.OGDescription
+	existing.OGImage = urlItem.OGImage
+	existing.Rules = urlItem.Rules
+	existing.Geo = urlItem.Geo
+	existing.Variants = urlItem.Variants
+	existing.Sticky = urlItem.Sticky
+	existing.Schedule = urlItem.Schedule
+	existing.ScheduleTimezone = urlItem.ScheduleTimezone
+	return nil
+}
+
+// DeleteURL mocks deleting a URL
+func (m *MockDynamoDB) DeleteURL(ctx context.Context, code string) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to delete URL")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existing, exists := m.urls[code]
+	if !exists {
+		return fmt.Errorf("URL not found for code: %s", code)
+	}
+	for _, tag := range existing.Tags {
+		delete(m.tags[tag], code)
+	}
+	delete(m.urls, code)
+
+	// Release the link from its owner's usage, as the stream processor would
+	if existing.Owner != "" {
+		m.usage[aggregate.ActiveUsageKey(existing.Owner)]--
+	}
+	return nil
+}
+
+// CreateURLWithUsage mocks creating a URL and counting it towards its
+// owner's quotas
+func (m *MockDynamoDB) CreateURLWithUsage(ctx context.Context, urlItem *model.URLItem, quota model.Quota, now time.Time) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to create URL")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.codeTaken(urlItem.ShortCode) {
+		return fmt.Errorf("URL already exists for code: %s", urlItem.ShortCode)
+	}
+	monthly := aggregate.MonthlyUsageKey(urlItem.Owner, now)
+	active := aggregate.ActiveUsageKey(urlItem.Owner)
+	if quota.MonthlyLinks > 0 && m.usage[monthly] >= quota.MonthlyLinks {
+		return &QuotaExceededError{Owner: urlItem.Owner, Quota: QuotaMonthlyLinks, Limit: quota.MonthlyLinks}
+	}
+	if quota.ActiveLinks > 0 && m.usage[active] >= quota.ActiveLinks {
+		return &QuotaExceededError{Owner: urlItem.Owner, Quota: QuotaActiveLinks, Limit: quota.ActiveLinks}
+	}
+
+	copied := *urlItem
+	m.urls[urlItem.ShortCode] = &copied
+	m.usage[monthly]++
+	m.usage[active]++
+	return nil
+}
+
+// GetUsage mocks reading an owner's usage
+func (m *MockDynamoDB) GetUsage(ctx context.Context, owner string, now time.Time) (int, int, error) {
+	if m.failNext {
+		m.failNext = false
+		return 0, 0, fmt.Errorf("mock error: failed to get usage")
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.usage[aggregate.MonthlyUsageKey(owner, now)], m.usage[aggregate.ActiveUsageKey(owner)], nil
+}
+
+// GetUnlockFailures mocks reading a client's failed unlock attempts
+func (m *MockDynamoDB) GetUnlockFailures(ctx context.Context, code, client string) (int, int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	state := m.unlocks[aggregate.UnlockKey(code, client)]
+	return state.failures, state.lockedUntil, nil
+}
+
+// RecordUnlockFailure mocks counting a wrong password from a client
+func (m *MockDynamoDB) RecordUnlockFailure(ctx context.Context, code, client string, expiration int64) (int, error) {
+	if m.failNext {
+		m.failNext = false
+		return 0, fmt.Errorf("mock error: failed to record unlock failure")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := aggregate.UnlockKey(code, client)
+	state := m.unlocks[key]
+	state.failures++
+	m.unlocks[key] = state
+	return state.failures, nil
+}
+
+// ResetUnlockFailures mocks clearing a client's failed unlock attempts
+func (m *MockDynamoDB) ResetUnlockFailures(ctx context.Context, code, client string, lockedUntil int64) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to reset unlock failures")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := aggregate.UnlockKey(code, client)
+	if lockedUntil == 0 {
+		delete(m.unlocks, key)
+		return nil
+	}
+	m.unlocks[key] = mockUnlockState{lockedUntil: lockedUntil}
+	return nil
+}
+
+// QueryURLsByCampaign mocks a campaign index query, in short code order
+func (m *MockDynamoDB) QueryURLsByCampaign(ctx context.Context, campaign string) ([]model.URLItem, error) {
+	if m.failNext {
+		m.failNext = false
+		return nil, fmt.Errorf("mock error: failed to query campaign")
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var urlItems []model.URLItem
+	for _, urlItem := range m.urls {
+		if urlItem.Campaign == campaign {
+			urlItems = append(urlItems, *urlItem)
+		}
+	}
+	sort.Slice(urlItems, func(i, j int) bool {
+		return urlItems[i].ShortCode < urlItems[j].ShortCode
+	})
+	return urlItems, nil
+}
+
+// SetURLTags mocks updating the tag index for a link
+func (m *MockDynamoDB) SetURLTags(ctx context.Context, code string, previous, tags []string) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to update tag index")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, tag := range previous {
+		delete(m.tags[tag], code)
+	}
+	for _, tag := range tags {
+		if m.tags[tag] == nil {
+			m.tags[tag] = make(map[string]bool)
+		}
+		m.tags[tag][code] = true
+	}
+	return nil
+}
+
+// QueryURLsByTag mocks a tag index lookup, in short code order
+func (m *MockDynamoDB) QueryURLsByTag(ctx context.Context, tag string) ([]model.URLItem, error) {
+	if m.failNext {
+		m.failNext = false
+		return nil, fmt.Errorf("mock error: failed to query tag")
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var urlItems []model.URLItem
+	for code := range m.tags[tag] {
+		if urlItem, exists := m.urls[code]; exists {
+			urlItems = append(urlItems, *urlItem)
+		}
+	}
+	sort.Slice(urlItems, func(i, j int) bool {
+		return urlItems[i].ShortCode < urlItems[j].ShortCode
+	})
+	return urlItems, nil
+}
+
+// MarkExpiryNotified mocks recording that a URL was found expired
+func (m *MockDynamoDB) MarkExpiryNotified(ctx context.Context, code string) (bool, error) {
+	if m.failNext {
+		m.failNext = false
+		return false, fmt.Errorf("mock error: failed to mark expiry notified")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	urlItem, exists := m.urls[code]
+	if !exists || urlItem.ExpiryNotified {
+		return false, nil
+	}
+	urlItem.ExpiryNotified = true
+	return true, nil
+}
+
+// CreateWebhook mocks storing a webhook subscription
+func (m *MockDynamoDB) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to create webhook")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.webhooks[webhook.ID]; exists {
+		return fmt.Errorf("webhook already exists: %s", webhook.ID)
+	}
+	m.webhooks[webhook.ID] = *webhook
+	return nil
+}
+
+// ListWebhooks mocks scanning the webhook table, in ID order
+func (m *MockDynamoDB) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	if m.failNext {
+		m.failNext = false
+		return nil, fmt.Errorf("mock error: failed to list webhooks")
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	webhooks := make([]model.Webhook, 0, len(m.webhooks))
+	for _, webhook := range m.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+// DeleteWebhook mocks removing a webhook subscription
+func (m *MockDynamoDB) DeleteWebhook(ctx context.Context, id string) error {
+	if m.failNext {
+		m.failNext = false
+		return fmt.Errorf("mock error: failed to delete webhook")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.webhooks[id]; !exists {
+		return fmt.Errorf("webhook not found")
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+// RecordWebhookDelivery mocks appending to a webhook's delivery log. It
+// ignores SetFailNext, as deliveries run in the background alongside the
+// operation under test.
+func (m *MockDynamoDB) RecordWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deliveries[delivery.WebhookID] = append(m.deliveries[delivery.WebhookID], *delivery)
+	return nil
+}
+
+// ListWebhookDeliveries mocks reading a webhook's delivery log, newest first
+func (m *MockDynamoDB) ListWebhookDeliveries(ctx context.Context, id string, limit int) ([]model.WebhookDelivery, error) {
+	if m.failNext {
+		m.failNext = false
+		return nil, fmt.Errorf("mock error: failed to list webhook deliveries")
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	deliveries := append([]model.WebhookDelivery(nil), m.deliveries[id]...)
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].DeliveryID > deliveries[j].DeliveryID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/aggregate"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+)
+
+// Wrong unlock passwords are counted per link and client in the aggregates
+// table, so one client cannot lock a link for everyone. Items expire with
+// TTL once their lockout or counting window is over.
+
+// GetUnlockFailures returns how many wrong passwords a client has sent for
+// a link and until when its unlocking is refused (0 for no lockout)
+func (d *DynamoDB) GetUnlockFailures(ctx context.Context, code, client string) (int, int64, error) {
+	dbClient, err := d.GetClient(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	key := aggregate.UnlockKey(code, client)
+	result, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.aggregateTableName),
+		Key:            usageKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		logger.Error("Failed to get unlock failures from DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": code,
+			"tableName": d.aggregateTableName,
+		})
+		return 0, 0, err
+	}
+
+	failures, _ := numberAttribute(result.Item, "failures")
+	lockedUntil, _ := numberAttribute(result.Item, "lockedUntil")
+	return int(failures), lockedUntil, nil
+}
+
+// RecordUnlockFailure counts a wrong password from a client and returns its
+// failures so far. The count is forgotten at expiration unless another
+// failure extends it.
+func (d *DynamoDB) RecordUnlockFailure(ctx context.Context, code, client string, expiration int64) (int, error) {
+	dbClient, err := d.GetClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := dbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(d.aggregateTableName),
+		Key:              usageKey(aggregate.UnlockKey(code, client)),
+		UpdateExpression: aws.String("ADD failures :one SET expiration = :expiration"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":        &types.AttributeValueMemberN{Value: "1"},
+			":expiration": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiration, 10)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		logger.Error("Failed to record unlock failure in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": code,
+			"tableName": d.aggregateTableName,
+		})
+		return 0, err
+	}
+
+	failures, _ := numberAttribute(result.Attributes, "failures")
+	return int(failures), nil
+}
+
+// ResetUnlockFailures clears a client's failures on a link and refuses its
+// unlocking until lockedUntil, or forgets the client when lockedUntil is 0
+func (d *DynamoDB) ResetUnlockFailures(ctx context.Context, code, client string, lockedUntil int64) error {
+	dbClient, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	key := usageKey(aggregate.UnlockKey(code, client))
+	if lockedUntil == 0 {
+		_, err = dbClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(d.aggregateTableName),
+			Key:       key,
+		})
+	} else {
+		item := map[string]types.AttributeValue{
+			"lockedUntil": &types.AttributeValueMemberN{Value: strconv.FormatInt(lockedUntil, 10)},
+			"expiration":  &types.AttributeValueMemberN{Value: strconv.FormatInt(lockedUntil, 10)},
+		}
+		for name, value := range key {
+			item[name] = value
+		}
+		_, err = dbClient.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(d.aggregateTableName),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		logger.Error("Failed to reset unlock failures in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": code,
+			"tableName": d.aggregateTableName,
+		})
+		return err
+	}
+	return nil
+}
+
+// numberAttribute reads a number attribute, reporting false if it is
+// missing or not a number
+func numberAttribute(item map[string]types.AttributeValue, name string) (int64, bool) {
+	value, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value.Value, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/aggregate"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// Quotas a new link can exceed
+const (
+	QuotaMonthlyLinks = "monthly_links"
+	QuotaActiveLinks  = "active_links"
+)
+
+// QuotaExceededError is returned when creating a link would take its owner
+// past one of its quotas
+type QuotaExceededError struct {
+	Owner string
+	Quota string
+	Limit int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d exceeded for %s", e.Quota, e.Limit, e.Owner)
+}
+
+// CreateURLWithUsage creates a link and counts it towards its owner's usage
+// for the month of now and its active links, all in one transaction. The
+// counters live in the aggregates table, where the stream processor
+// releases active links once they are deleted or expire. If a counter is
+// already at its quota, nothing is written and a *QuotaExceededError is
+// returned. The link is only put if its short code is free; otherwise an
+// "URL already exists" error is returned, and no usage moves between
+// owners.
+func (d *DynamoDB) CreateURLWithUsage(ctx context.Context, urlItem *model.URLItem, quota model.Quota, now time.Time) error {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	av, err := marshalURLItem(urlItem)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           aws.String(d.tableName),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(shortCode)"),
+			}},
+			d.usageIncrement(aggregate.MonthlyUsageKey(urlItem.Owner, now), aggregate.Created, quota.MonthlyLinks),
+			d.usageIncrement(aggregate.ActiveUsageKey(urlItem.Owner), aggregate.Links, quota.ActiveLinks),
+		},
+	})
+	if err != nil {
+		// Cancellation reasons follow the order of the items above
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			for i, reason := range canceled.CancellationReasons {
+				if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+					continue
+				}
+				switch i {
+				case 0:
+					return fmt.Errorf("URL already exists for code: %s", urlItem.ShortCode)
+				case 1:
+					return &QuotaExceededError{Owner: urlItem.Owner, Quota: QuotaMonthlyLinks, Limit: quota.MonthlyLinks}
+				case 2:
+					return &QuotaExceededError{Owner: urlItem.Owner, Quota: QuotaActiveLinks, Limit: quota.ActiveLinks}
+				}
+			}
+		}
+
+		logger.Error("Failed to create URL with usage in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": urlItem.ShortCode,
+			"owner":     urlItem.Owner,
+			"tableName": d.tableName,
+		})
+		return err
+	}
+	return nil
+}
+
+// usageIncrement adds one to a usage counter, on condition that it is below
+// limit when there is one
+func (d *DynamoDB) usageIncrement(key aggregate.Key, counter string, limit int) types.TransactWriteItem {
+	update := &types.Update{
+		TableName:                aws.String(d.aggregateTableName),
+		Key:                      usageKey(key),
+		UpdateExpression:         aws.String("ADD #count :one"),
+		ExpressionAttributeNames: map[string]string{"#count": counter},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+	}
+	if limit > 0 {
+		update.ConditionExpression = aws.String("attribute_not_exists(#count) OR #count < :limit")
+		update.ExpressionAttributeValues[":limit"] = &types.AttributeValueMemberN{Value: strconv.Itoa(limit)}
+	}
+	return types.TransactWriteItem{Update: update}
+}
+
+// GetUsage returns how many links an owner created in the month of now and
+// how many it holds
+func (d *DynamoDB) GetUsage(ctx context.Context, owner string, now time.Time) (int, int, error) {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	monthly, err := d.usageCounter(ctx, client, aggregate.MonthlyUsageKey(owner, now), aggregate.Created)
+	if err != nil {
+		return 0, 0, err
+	}
+	active, err := d.usageCounter(ctx, client, aggregate.ActiveUsageKey(owner), aggregate.Links)
+	if err != nil {
+		return 0, 0, err
+	}
+	return monthly, active, nil
+}
+
+// usageCounter reads one usage counter; a missing item counts as 0
+func (d *DynamoDB) usageCounter(ctx context.Context, client *dynamodb.Client, key aggregate.Key, counter string) (int, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.aggregateTableName),
+		Key:            usageKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		logger.Error("Failed to get usage from DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"key":       key.PK + " " + key.SK,
+			"tableName": d.aggregateTableName,
+		})
+		return 0, err
+	}
+
+	value, ok := result.Item[counter].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(value.Value)
+}
+
+// usageKey is the key of a usage item in the aggregates table
+func usageKey(key aggregate.Key) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: key.PK},
+		"sk": &types.AttributeValueMemberS{Value: key.SK},
+	}
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// MarkExpiryNotified records that a URL was found expired, which has the
+// stream processor send the link.expired event. It returns false if it
+// had already been recorded, so the event is sent only once however often
+// the expired link is visited.
+func (d *DynamoDB) MarkExpiryNotified(ctx context.Context, code string) (bool, error) {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := attributevalue.MarshalMap(map[string]string{
+		"shortCode": code,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.tableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET expiryNotified = :true"),
+		ConditionExpression: aws.String("attribute_exists(shortCode) AND attribute_not_exists(expiryNotified)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return false, nil
+		}
+		logger.Error("Failed to mark expiry notified in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"shortCode": code,
+			"tableName": d.tableName,
+		})
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateWebhook stores a new webhook subscription
+func (d *DynamoDB) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	av, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.webhookTableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		logger.Error("Failed to create webhook in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"webhookId": webhook.ID,
+			"tableName": d.webhookTableName,
+		})
+		return err
+	}
+	return nil
+}
+
+// ListWebhooks returns every webhook subscription. There are few of them,
+// so a scan is cheap.
+func (d *DynamoDB) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []model.Webhook
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName: aws.String(d.webhookTableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.Error("Failed to scan webhooks in DynamoDB", map[string]interface{}{
+				"error":     err.Error(),
+				"tableName": d.webhookTableName,
+			})
+			return nil, err
+		}
+
+		var items []model.Webhook
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, items...)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook subscription. Its delivery log is left to
+// expire.
+func (d *DynamoDB) DeleteWebhook(ctx context.Context, id string) error {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := attributevalue.MarshalMap(map[string]string{
+		"id": id,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.webhookTableName),
+		Key:                 key,
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return fmt.Errorf("webhook not found")
+		}
+		logger.Error("Failed to delete webhook in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"webhookId": id,
+			"tableName": d.webhookTableName,
+		})
+		return err
+	}
+	return nil
+}
+
+// RecordWebhookDelivery appends an attempt to a webhook's delivery log
+func (d *DynamoDB) RecordWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	av, err := attributevalue.MarshalMap(delivery)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.webhookDeliveryTableName),
+		Item:      av,
+	})
+	if err != nil {
+		logger.Error("Failed to record webhook delivery in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"webhookId": delivery.WebhookID,
+			"tableName": d.webhookDeliveryTableName,
+		})
+		return err
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns up to limit of a webhook's most recent
+// delivery attempts, newest first
+func (d *DynamoDB) ListWebhookDeliveries(ctx context.Context, id string, limit int) ([]model.WebhookDelivery, error) {
+	client, err := d.GetClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(d.webhookDeliveryTableName),
+		KeyConditionExpression: aws.String("webhookId = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: id},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		logger.Error("Failed to query webhook deliveries in DynamoDB", map[string]interface{}{
+			"error":     err.Error(),
+			"webhookId": id,
+			"tableName": d.webhookDeliveryTableName,
+		})
+		return nil, err
+	}
+
+	var deliveries []model.WebhookDelivery
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,252 @@
+// Package eventbus publishes click events to a stream, queue, event bus or
+// JSON Lines file for analytics. Events are buffered and sent in batches.
+package eventbus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+)
+
+// SchemaVersion is the version of the ClickEvent layout. It changes when
+// fields are renamed or removed, not when fields are added.
+const SchemaVersion = 1
+
+// EventTypeClick is the type of every ClickEvent
+const EventTypeClick = "link.clicked"
+
+// ClickEvent describes one counted visit to a short link
+type ClickEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	Timestamp     string `json:"timestamp"`
+	RequestID     string `json:"request_id,omitempty"`
+
+	ShortCode   string `json:"short_code"`
+	Campaign    string `json:"campaign,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Destination string `json:"destination"`
+
+	// How the destination was chosen
+	Rule    string `json:"rule,omitempty"`
+	Variant string `json:"variant,omitempty"`
+
+	// The visitor
+	Country   string `json:"country,omitempty"`
+	OS        string `json:"os,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Browser   string `json:"browser,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// NewClickEvent returns a click event with the schema version, a random ID
+// and the timestamp filled in
+func NewClickEvent(now time.Time) ClickEvent {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return ClickEvent{
+		SchemaVersion: SchemaVersion,
+		ID:            "clk_" + hex.EncodeToString(b),
+		Type:          EventTypeClick,
+		Timestamp:     now.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// EventPublisher accepts click events for delivery
+type EventPublisher interface {
+	// Publish queues an event. It does not wait for the event to be sent.
+	Publish(ctx context.Context, event ClickEvent) error
+	// Flush sends every queued event and waits for sends in progress
+	Flush(ctx context.Context) error
+}
+
+// Sink sends a batch of events to a destination in as few requests as
+// its API allows
+type Sink interface {
+	Send(ctx context.Context, batch []ClickEvent) error
+	// Largest batch a single request can carry
+	MaxBatchSize() int
+}
+
+// Timeout of a single batch send made in the background
+const sendTimeout = 5 * time.Second
+
+// BatchPublisher buffers events and sends them to a Sink in the background
+// once a full batch has built up or the oldest event has waited for the
+// flush interval. On Lambda, call Flush before returning from each
+// invocation: timers and goroutines do not run while it is frozen.
+type BatchPublisher struct {
+	sink     Sink
+	interval time.Duration
+
+	mutex  sync.Mutex
+	buffer []ClickEvent
+	timer  *time.Timer
+
+	sending sync.WaitGroup
+}
+
+// NewBatchPublisher creates a publisher that sends to sink at least every
+// interval while events are queued
+func NewBatchPublisher(sink Sink, interval time.Duration) *BatchPublisher {
+	return &BatchPublisher{sink: sink, interval: interval}
+}
+
+// Publish queues an event, starting a background send when a batch is full
+func (p *BatchPublisher) Publish(ctx context.Context, event ClickEvent) error {
+	p.mutex.Lock()
+	p.buffer = append(p.buffer, event)
+	if len(p.buffer) < p.sink.MaxBatchSize() {
+		if p.timer == nil {
+			p.timer = time.AfterFunc(p.interval, p.flushInBackground)
+		}
+		p.mutex.Unlock()
+		return nil
+	}
+	batch := p.takeLocked()
+	p.mutex.Unlock()
+
+	p.sendInBackground(batch)
+	return nil
+}
+
+// Flush sends every queued event and waits for background sends to finish
+func (p *BatchPublisher) Flush(ctx context.Context) error {
+	p.mutex.Lock()
+	batch := p.takeLocked()
+	p.mutex.Unlock()
+
+	err := p.send(ctx, batch)
+
+	done := make(chan struct{})
+	go func() {
+		p.sending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.Join(err, ctx.Err())
+	}
+	return err
+}
+
+// flushInBackground sends whatever is queued when the interval elapses
+func (p *BatchPublisher) flushInBackground() {
+	p.mutex.Lock()
+	batch := p.takeLocked()
+	p.mutex.Unlock()
+	p.sendInBackground(batch)
+}
+
+// takeLocked empties the buffer and stops the flush timer. The caller must
+// hold the mutex.
+func (p *BatchPublisher) takeLocked() []ClickEvent {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	batch := p.buffer
+	p.buffer = nil
+	return batch
+}
+
+// sendInBackground sends a batch on its own goroutine
+func (p *BatchPublisher) sendInBackground(batch []ClickEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	p.sending.Add(1)
+	go func() {
+		defer p.sending.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		p.send(ctx, batch)
+	}()
+}
+
+// send delivers events in chunks no larger than the sink allows. Failed
+// chunks are logged and dropped.
+func (p *BatchPublisher) send(ctx context.Context, events []ClickEvent) error {
+	var errs []error
+	size := p.sink.MaxBatchSize()
+	for start := 0; start < len(events); start += size {
+		chunk := events[start:min(start+size, len(events))]
+		if err := p.sink.Send(ctx, chunk); err != nil {
+			logger.Error("Failed to publish click events", map[string]interface{}{
+				"events": len(chunk),
+				"error":  err.Error(),
+			})
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MemoryPublisher keeps events in memory. It is both an EventPublisher,
+// recording each published event, and a Sink, recording each batch, so
+// tests can check exactly what would have been sent.
+type MemoryPublisher struct {
+	// MaxBatch is the batch size reported as a Sink; 0 means 10
+	MaxBatch int
+
+	mutex   sync.Mutex
+	events  []ClickEvent
+	batches [][]ClickEvent
+}
+
+// NewMemoryPublisher creates an empty in-memory publisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish records an event
+func (m *MemoryPublisher) Publish(ctx context.Context, event ClickEvent) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Flush does nothing; events are recorded as they are published
+func (m *MemoryPublisher) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Send records a batch and its events
+func (m *MemoryPublisher) Send(ctx context.Context, batch []ClickEvent) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.batches = append(m.batches, append([]ClickEvent(nil), batch...))
+	m.events = append(m.events, batch...)
+	return nil
+}
+
+// MaxBatchSize returns MaxBatch, or 10 if it is unset
+func (m *MemoryPublisher) MaxBatchSize() int {
+	if m.MaxBatch > 0 {
+		return m.MaxBatch
+	}
+	return 10
+}
+
+// Events returns every event recorded so far
+func (m *MemoryPublisher) Events() []ClickEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]ClickEvent(nil), m.events...)
+}
+
+// Batches returns every batch received as a Sink
+func (m *MemoryPublisher) Batches() [][]ClickEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([][]ClickEvent(nil), m.batches...)
+}
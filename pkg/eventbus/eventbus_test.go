@@ -0,0 +1,176 @@
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+func testEvents(n int) []ClickEvent {
+	events := make([]ClickEvent, n)
+	for i := range events {
+		events[i] = NewClickEvent(time.Unix(1700000000, 0))
+		events[i].ShortCode = string(rune('a' + i))
+		events[i].Destination = "https://example.com"
+	}
+	return events
+}
+
+func TestBatchPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	// Full batches are sent straight away and Flush sends the rest
+	sink := &MemoryPublisher{MaxBatch: 3}
+	publisher := NewBatchPublisher(sink, time.Hour)
+	for _, event := range testEvents(7) {
+		publisher.Publish(ctx, event)
+	}
+	if err := publisher.Flush(ctx); err != nil {
+		t.Fatalf("Flush returned an error: %v", err)
+	}
+	batches := sink.Batches()
+	sizes := []int{}
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	// Full batches go out in the background, so they may arrive in any order
+	sort.Ints(sizes)
+	if len(sink.Events()) != 7 || fmt.Sprint(sizes) != "[1 3 3]" {
+		t.Errorf("Expected batches of 3, 3 and 1, got %v", sizes)
+	}
+
+	// A partial batch is sent once the interval passes
+	sink = &MemoryPublisher{MaxBatch: 10}
+	publisher = NewBatchPublisher(sink, 10*time.Millisecond)
+	for _, event := range testEvents(2) {
+		publisher.Publish(ctx, event)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(sink.Batches()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if batches := sink.Batches(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Errorf("Expected one batch of 2 after the interval, got %v", batches)
+	}
+}
+
+func TestClickEventSchema(t *testing.T) {
+	event := NewClickEvent(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	event.ShortCode = "aB3xY"
+	event.Destination = "https://example.com"
+
+	data, _ := json.Marshal(event)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if fields["schema_version"] != float64(SchemaVersion) || fields["type"] != "link.clicked" ||
+		fields["timestamp"] != "2026-01-02T03:04:05Z" || !strings.HasPrefix(event.ID, "clk_") {
+		t.Errorf("Unexpected event JSON: %s", data)
+	}
+	if _, ok := fields["country"]; ok {
+		t.Errorf("Expected empty fields to be left out: %s", data)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	if err := sink.Send(context.Background(), testEvents(3)); err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", buf.String())
+	}
+	var event ClickEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil || event.ShortCode != "b" || event.SchemaVersion != SchemaVersion {
+		t.Errorf("Unexpected line %q: %v", lines[1], err)
+	}
+}
+
+type fakeKinesis struct {
+	calls [][]kinesistypes.PutRecordsRequestEntry
+}
+
+// PutRecords rejects the first record of the first call
+func (f *fakeKinesis) PutRecords(ctx context.Context, params *kinesis.PutRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error) {
+	f.calls = append(f.calls, params.Records)
+	output := &kinesis.PutRecordsOutput{Records: make([]kinesistypes.PutRecordsResultEntry, len(params.Records))}
+	if len(f.calls) == 1 {
+		output.Records[0].ErrorCode = aws.String("ProvisionedThroughputExceededException")
+		output.FailedRecordCount = aws.Int32(1)
+	}
+	return output, nil
+}
+
+func TestKinesisSink(t *testing.T) {
+	client := &fakeKinesis{}
+	sink := NewKinesisSink(client, "clicks")
+	if err := sink.Send(context.Background(), testEvents(3)); err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	// The rejected record is sent again on its own
+	if len(client.calls) != 2 || len(client.calls[0]) != 3 || len(client.calls[1]) != 1 {
+		t.Fatalf("Unexpected PutRecords calls: %v", client.calls)
+	}
+	if key := aws.ToString(client.calls[1][0].PartitionKey); key != "a" {
+		t.Errorf("Expected the retried record to be partitioned by short code, got %s", key)
+	}
+}
+
+type fakeSQS struct {
+	input *sqs.SendMessageBatchInput
+}
+
+func (f *fakeSQS) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.input = params
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+type fakeEventBridge struct {
+	input *eventbridge.PutEventsInput
+}
+
+func (f *fakeEventBridge) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.input = params
+	return &eventbridge.PutEventsOutput{Entries: make([]eventbridgetypes.PutEventsResultEntry, len(params.Entries))}, nil
+}
+
+func TestSQSAndEventBridgeSinks(t *testing.T) {
+	ctx := context.Background()
+	events := testEvents(2)
+
+	// FIFO queues group messages by short code
+	queue := &fakeSQS{}
+	if err := NewSQSSink(queue, "https://sqs.us-east-1.amazonaws.com/123/clicks.fifo").Send(ctx, events); err != nil {
+		t.Fatalf("SQS Send returned an error: %v", err)
+	}
+	entry := queue.input.Entries[1]
+	if len(queue.input.Entries) != 2 || aws.ToString(entry.MessageGroupId) != "b" || aws.ToString(entry.MessageDeduplicationId) != events[1].ID ||
+		!strings.Contains(aws.ToString(entry.MessageBody), `"short_code":"b"`) {
+		t.Errorf("Unexpected SQS entries: %+v", queue.input.Entries)
+	}
+
+	bus := &fakeEventBridge{}
+	if err := NewEventBridgeSink(bus, "analytics").Send(ctx, events); err != nil {
+		t.Fatalf("EventBridge Send returned an error: %v", err)
+	}
+	first := bus.input.Entries[0]
+	if aws.ToString(first.EventBusName) != "analytics" || aws.ToString(first.Source) != EventBridgeSource ||
+		aws.ToString(first.DetailType) != EventBridgeDetailType || !strings.Contains(aws.ToString(first.Detail), `"schema_version":1`) {
+		t.Errorf("Unexpected EventBridge entry: %+v", first)
+	}
+}
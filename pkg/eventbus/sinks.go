@@ -0,0 +1,339 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// Batch limits of the AWS APIs
+	kinesisMaxBatch     = 500
+	sqsMaxBatch         = 10
+	eventBridgeMaxBatch = 10
+	// Events written to a JSON Lines sink per batch
+	writerMaxBatch = 500
+
+	// Attempts at sending the records a service rejected, with a growing
+	// pause between them
+	maxSendAttempts = 3
+	retryDelay      = 100 * time.Millisecond
+
+	// Source and detail type of EventBridge events
+	EventBridgeSource     = "url-shortener"
+	EventBridgeDetailType = "Link Clicked"
+
+	// Default for EVENT_FLUSH_INTERVAL
+	DefaultFlushInterval = time.Second
+)
+
+// FromEnv builds a batching publisher from EVENT_SINK ("kinesis", "sqs",
+// "eventbridge", "file" or "stdout") and EVENT_TARGET (the stream name or
+// ARN, queue URL, event bus name or file path). EVENT_FLUSH_INTERVAL sets
+// how long events may wait before a partial batch is sent. It returns nil
+// when EVENT_SINK is unset.
+func FromEnv(ctx context.Context) (EventPublisher, error) {
+	kind := strings.ToLower(os.Getenv("EVENT_SINK"))
+	if kind == "" {
+		return nil, nil
+	}
+	target := os.Getenv("EVENT_TARGET")
+	if target == "" && kind != "stdout" {
+		return nil, fmt.Errorf("EVENT_TARGET is required for the %s event sink", kind)
+	}
+
+	interval := DefaultFlushInterval
+	if value := os.Getenv("EVENT_FLUSH_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("EVENT_FLUSH_INTERVAL must be a positive duration such as 500ms")
+		}
+		interval = parsed
+	}
+
+	var sink Sink
+	switch kind {
+	case "stdout":
+		sink = NewWriterSink(os.Stdout)
+	case "file":
+		fileSink, err := OpenFileSink(target)
+		if err != nil {
+			return nil, err
+		}
+		sink = fileSink
+	case "kinesis", "sqs", "eventbridge":
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "kinesis":
+			sink = NewKinesisSink(kinesis.NewFromConfig(cfg), target)
+		case "sqs":
+			sink = NewSQSSink(sqs.NewFromConfig(cfg), target)
+		default:
+			sink = NewEventBridgeSink(eventbridge.NewFromConfig(cfg), target)
+		}
+	default:
+		return nil, fmt.Errorf("unknown EVENT_SINK %q", kind)
+	}
+	return NewBatchPublisher(sink, interval), nil
+}
+
+// KinesisAPI is the part of the Kinesis client used by KinesisSink
+type KinesisAPI interface {
+	PutRecords(ctx context.Context, params *kinesis.PutRecordsInput, optFns ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error)
+}
+
+// KinesisSink puts events on a Kinesis data stream, partitioned by short
+// code so each link's clicks stay in order
+type KinesisSink struct {
+	client KinesisAPI
+	stream string
+}
+
+// NewKinesisSink creates a sink for a stream given by name or ARN
+func NewKinesisSink(client KinesisAPI, stream string) *KinesisSink {
+	return &KinesisSink{client: client, stream: stream}
+}
+
+// MaxBatchSize returns the PutRecords limit
+func (s *KinesisSink) MaxBatchSize() int {
+	return kinesisMaxBatch
+}
+
+// Send puts a batch of records, retrying any the stream rejected
+func (s *KinesisSink) Send(ctx context.Context, batch []ClickEvent) error {
+	return sendWithRetry(ctx, batch, func(events []ClickEvent) ([]ClickEvent, error) {
+		input := &kinesis.PutRecordsInput{}
+		if strings.HasPrefix(s.stream, "arn:") {
+			input.StreamARN = aws.String(s.stream)
+		} else {
+			input.StreamName = aws.String(s.stream)
+		}
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			input.Records = append(input.Records, kinesistypes.PutRecordsRequestEntry{
+				Data:         data,
+				PartitionKey: aws.String(event.ShortCode),
+			})
+		}
+
+		output, err := s.client.PutRecords(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		var failed []ClickEvent
+		for i, record := range output.Records {
+			if record.ErrorCode != nil && i < len(events) {
+				failed = append(failed, events[i])
+			}
+		}
+		return failed, nil
+	})
+}
+
+// SQSAPI is the part of the SQS client used by SQSSink
+type SQSAPI interface {
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// SQSSink sends each event as a message to an SQS queue. FIFO queues get
+// the short code as message group and the event ID for deduplication.
+type SQSSink struct {
+	client   SQSAPI
+	queueURL string
+}
+
+// NewSQSSink creates a sink for the queue at queueURL
+func NewSQSSink(client SQSAPI, queueURL string) *SQSSink {
+	return &SQSSink{client: client, queueURL: queueURL}
+}
+
+// MaxBatchSize returns the SendMessageBatch limit
+func (s *SQSSink) MaxBatchSize() int {
+	return sqsMaxBatch
+}
+
+// Send sends a batch of messages, retrying any the queue rejected
+func (s *SQSSink) Send(ctx context.Context, batch []ClickEvent) error {
+	fifo := strings.HasSuffix(s.queueURL, ".fifo")
+	return sendWithRetry(ctx, batch, func(events []ClickEvent) ([]ClickEvent, error) {
+		input := &sqs.SendMessageBatchInput{QueueUrl: aws.String(s.queueURL)}
+		for i, event := range events {
+			body, err := json.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			entry := sqstypes.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(body)),
+			}
+			if fifo {
+				entry.MessageGroupId = aws.String(event.ShortCode)
+				entry.MessageDeduplicationId = aws.String(event.ID)
+			}
+			input.Entries = append(input.Entries, entry)
+		}
+
+		output, err := s.client.SendMessageBatch(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		var failed []ClickEvent
+		for _, entry := range output.Failed {
+			i, err := strconv.Atoi(aws.ToString(entry.Id))
+			if err != nil || i < 0 || i >= len(events) {
+				continue
+			}
+			if entry.SenderFault {
+				return nil, fmt.Errorf("message rejected: %s", aws.ToString(entry.Message))
+			}
+			failed = append(failed, events[i])
+		}
+		return failed, nil
+	})
+}
+
+// EventBridgeAPI is the part of the EventBridge client used by
+// EventBridgeSink
+type EventBridgeAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// EventBridgeSink puts events on an EventBridge bus with source
+// EventBridgeSource and detail type EventBridgeDetailType
+type EventBridgeSink struct {
+	client EventBridgeAPI
+	bus    string
+}
+
+// NewEventBridgeSink creates a sink for the bus given by name or ARN
+func NewEventBridgeSink(client EventBridgeAPI, bus string) *EventBridgeSink {
+	return &EventBridgeSink{client: client, bus: bus}
+}
+
+// MaxBatchSize returns the PutEvents limit
+func (s *EventBridgeSink) MaxBatchSize() int {
+	return eventBridgeMaxBatch
+}
+
+// Send puts a batch of events, retrying any the bus rejected
+func (s *EventBridgeSink) Send(ctx context.Context, batch []ClickEvent) error {
+	return sendWithRetry(ctx, batch, func(events []ClickEvent) ([]ClickEvent, error) {
+		input := &eventbridge.PutEventsInput{}
+		for _, event := range events {
+			detail, err := json.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			entry := eventbridgetypes.PutEventsRequestEntry{
+				EventBusName: aws.String(s.bus),
+				Source:       aws.String(EventBridgeSource),
+				DetailType:   aws.String(EventBridgeDetailType),
+				Detail:       aws.String(string(detail)),
+			}
+			if t, err := time.Parse(time.RFC3339Nano, event.Timestamp); err == nil {
+				entry.Time = aws.Time(t)
+			}
+			input.Entries = append(input.Entries, entry)
+		}
+
+		output, err := s.client.PutEvents(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		var failed []ClickEvent
+		for i, entry := range output.Entries {
+			if entry.ErrorCode != nil && i < len(events) {
+				failed = append(failed, events[i])
+			}
+		}
+		return failed, nil
+	})
+}
+
+// WriterSink writes events as JSON Lines, one event per line
+type WriterSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewWriterSink creates a sink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{writer: w}
+}
+
+// OpenFileSink creates a sink appending to the file at path
+func OpenFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink(file), nil
+}
+
+// MaxBatchSize returns how many lines are written at once
+func (s *WriterSink) MaxBatchSize() int {
+	return writerMaxBatch
+}
+
+// Send writes a batch in a single write so lines from concurrent batches
+// never interleave
+func (s *WriterSink) Send(ctx context.Context, batch []ClickEvent) error {
+	var lines []byte
+	for _, event := range batch {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err := s.writer.Write(lines)
+	return err
+}
+
+// sendWithRetry calls send until it accepts every event, resending only
+// the events it returns as failed, up to maxSendAttempts times
+func sendWithRetry(ctx context.Context, events []ClickEvent, send func([]ClickEvent) ([]ClickEvent, error)) error {
+	for attempt := 1; ; attempt++ {
+		failed, err := send(events)
+		if err != nil {
+			return err
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		if attempt == maxSendAttempts {
+			return fmt.Errorf("%d events still rejected after %d attempts", len(failed), attempt)
+		}
+		events = failed
+
+		select {
+		case <-time.After(time.Duration(attempt) * retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package geoip looks up the country of an IP address in an offline
+// database loaded from a CSV file of address ranges
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+)
+
+// Database maps IP address ranges to ISO 3166-1 alpha-2 country codes
+type Database struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+var (
+	defaultDB   *Database
+	defaultOnce sync.Once
+)
+
+// Default returns the database at GEOIP_DB_PATH, loaded on first use and
+// kept for the life of the Lambda instance. It returns nil if the variable
+// is unset or the file cannot be loaded.
+func Default() *Database {
+	defaultOnce.Do(func() {
+		path := os.Getenv("GEOIP_DB_PATH")
+		if path == "" {
+			return
+		}
+
+		db, err := Load(path)
+		if err != nil {
+			logger.Error("Failed to load GeoIP database", map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+			return
+		}
+		logger.Info("Loaded GeoIP database", map[string]interface{}{
+			"path":   path,
+			"ranges": len(db.ranges),
+		})
+		defaultDB = db
+	})
+	return defaultDB
+}
+
+// Load reads a database file; see Parse for the format
+func Load(path string) (*Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse reads "start,end,country" lines, the layout of the free DB-IP and
+// IP2Location country CSVs. Addresses may be written as IPs or, for IPv4,
+// as decimal numbers; fields may be quoted. Blank lines, lines starting
+// with "#" and a header line are skipped.
+func Parse(r io.Reader) (*Database, error) {
+	db := &Database{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected start,end,country", lineNumber)
+		}
+		start, startErr := parseAddr(fields[0])
+		end, endErr := parseAddr(fields[1])
+		if startErr != nil || endErr != nil {
+			if lineNumber == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: invalid address range", lineNumber)
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid address range", lineNumber)
+		}
+
+		country := strings.ToUpper(strings.Trim(strings.TrimSpace(fields[2]), `"`))
+		if len(country) != 2 || country == "ZZ" || country == "--" {
+			continue // unassigned ranges
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, country: country})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+// Country returns the country code for ip, or "" if it is not in any range
+func (d *Database) Country(ip netip.Addr) string {
+	if d == nil || !ip.IsValid() {
+		return ""
+	}
+	ip = ip.Unmap()
+
+	// Find the last range starting at or before ip
+	i := sort.Search(len(d.ranges), func(i int) bool {
+		return ip.Less(d.ranges[i].start)
+	}) - 1
+	if i < 0 || d.ranges[i].end.Less(ip) || d.ranges[i].start.Is4() != ip.Is4() {
+		return ""
+	}
+	return d.ranges[i].country
+}
+
+// parseAddr reads an IP address or a decimal IPv4 number
+func parseAddr(field string) (netip.Addr, error) {
+	field = strings.Trim(strings.TrimSpace(field), `"`)
+	if addr, err := netip.ParseAddr(field); err == nil {
+		return addr.Unmap(), nil
+	}
+
+	n, ok := new(big.Int).SetString(field, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 32 {
+		return netip.Addr{}, fmt.Errorf("invalid address %q", field)
+	}
+	v := n.Uint64()
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}), nil
+}
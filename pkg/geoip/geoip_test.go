@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseAndCountry(t *testing.T) {
+	data := `start,end,country
+# DB-IP style rows
+1.0.0.0,1.0.0.255,AU
+"2.16.0.0","2.16.255.255","fr"
+2001:db8::,2001:db8::ffff,DE
+# IP2Location style row: 8.8.8.0 - 8.8.8.255
+134744064,134744319,US
+10.0.0.0,10.255.255.255,ZZ
+`
+	db, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+
+	tests := map[string]string{
+		"1.0.0.1":         "AU",
+		"1.0.0.255":       "AU",
+		"1.0.1.0":         "",
+		"2.16.40.1":       "FR",
+		"8.8.8.8":         "US",
+		"::ffff:8.8.8.8":  "US",
+		"2001:db8::1":     "DE",
+		"2001:db8::1:0":   "",
+		"10.1.2.3":        "",
+		"0.0.0.1":         "",
+		"255.255.255.255": "",
+	}
+	for ip, want := range tests {
+		if got := db.Country(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Country(%s) = %q, want %q", ip, got, want)
+		}
+	}
+
+	// A nil database knows no countries
+	var empty *Database
+	if got := empty.Country(netip.MustParseAddr("1.0.0.1")); got != "" {
+		t.Errorf("Expected no country from a nil database, got %q", got)
+	}
+
+	// Invalid rows are rejected
+	if _, err := Parse(strings.NewReader("1.0.0.0,1.0.0.255,AU\n1.0.1.0,nope,AU\n")); err == nil {
+		t.Errorf("Expected an error for an invalid row")
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+)
+
+const (
+	// Default and allowed lengths of the code of a private link
+	defaultPrivateCodeLength = 16
+	minPrivateCodeLength     = 8
+	maxPrivateCodeLength     = 64
+	// Length of the random part of a stats token
+	statsTokenLength = 32
+)
+
+// ownerKeys reads OWNER_API_KEYS, written as "owner=key" pairs separated by
+// commas, into a map from key to owner
+func ownerKeys() map[string]string {
+	keys := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv("OWNER_API_KEYS"), ",") {
+		owner, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		owner, key = strings.TrimSpace(owner), strings.TrimSpace(key)
+		if ok && owner != "" && key != "" {
+			keys[key] = owner
+		}
+	}
+	return keys
+}
+
+// requestOwner returns the owner whose API key the request carries, or ""
+func requestOwner(req events.LambdaFunctionURLRequest) string {
+	key := headerValue(req, "x-api-key")
+	if key == "" {
+		return ""
+	}
+	for ownerKey, owner := range ownerKeys() {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(ownerKey)) == 1 {
+			return owner
+		}
+	}
+	return ""
+}
+
+// isAdmin reports whether the request carries the admin API key
+func isAdmin(req events.LambdaFunctionURLRequest) bool {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	return adminKey != "" && subtle.ConstantTimeCompare([]byte(headerValue(req, "x-api-key")), []byte(adminKey)) == 1
+}
+
+// canViewStats reports whether the request may read a link's stats: the
+// admin, the link's owner and holders of its stats token may
+func canViewStats(req events.LambdaFunctionURLRequest, urlItem *model.URLItem) bool {
+	if isAdmin(req) {
+		return true
+	}
+	if urlItem.Owner != "" && requestOwner(req) == urlItem.Owner {
+		return true
+	}
+	token := headerValue(req, "x-stats-token")
+	if token == "" || urlItem.StatsTokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashStatsToken(token)), []byte(urlItem.StatsTokenHash)) == 1
+}
+
+// newStatsToken returns a random stats token and the hash to store
+func newStatsToken() (string, string, error) {
+	random, err := utils.GenerateShortCode(statsTokenLength)
+	if err != nil {
+		return "", "", err
+	}
+	token := "st_" + random
+	return token, hashStatsToken(token), nil
+}
+
+// hashStatsToken hashes a stats token. Tokens are long and random, so a
+// plain SHA-256 is enough.
+func hashStatsToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// privateCodeLength reads PRIVATE_CODE_LENGTH, kept within the allowed range
+func privateCodeLength() int {
+	length, err := strconv.Atoi(os.Getenv("PRIVATE_CODE_LENGTH"))
+	if err != nil {
+		return defaultPrivateCodeLength
+	}
+	if length < minPrivateCodeLength {
+		return minPrivateCodeLength
+	}
+	if length > maxPrivateCodeLength {
+		return maxPrivateCodeLength
+	}
+	return length
+}
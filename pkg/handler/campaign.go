@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+)
+
+// GetCampaignStats adds up clicks across every link in a campaign
+func (h *Handler) GetCampaignStats(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	startTime := time.Now()
+
+	// Extract campaign name from /campaigns/{name}/stats
+	path := req.RawPath
+	escaped := strings.TrimSuffix(strings.TrimPrefix(path, "/campaigns/"), "/stats")
+	campaign, err := url.PathUnescape(escaped)
+	if err != nil || campaign == "" || escaped == path || strings.Contains(escaped, "/") {
+		logger.Warn("Campaign stats request with invalid path", map[string]interface{}{
+			"path": path,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Campaign name is required"}`,
+		}, nil
+	}
+
+	// Initialize monitoring client
+	metricClient, err := monitoring.NewClient(ctx)
+	if err != nil {
+		logger.Warn("Failed to initialize monitoring client", err)
+		// Continue without monitoring
+	}
+
+	logger.Info("Processing campaign stats request", map[string]interface{}{
+		"campaign":  campaign,
+		"requestId": req.RequestContext.RequestID,
+	})
+
+	urlItems, err := h.db.QueryURLsByCampaign(ctx, campaign)
+	if err != nil {
+		logger.Error("Failed to query campaign", map[string]interface{}{
+			"campaign": campaign,
+			"error":    err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "QueryURLsByCampaign")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to retrieve campaign: " + err.Error()),
+		}, nil
+	}
+
+	// Only count links the caller may see the stats of, so a campaign does
+	// not give away private links
+	visible := urlItems[:0]
+	for i := range urlItems {
+		if canViewStats(req, &urlItems[i]) {
+			visible = append(visible, urlItems[i])
+		}
+	}
+	urlItems = visible
+
+	if len(urlItems) == 0 {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       `{"error": "Campaign not found"}`,
+		}, nil
+	}
+
+	stats := model.CampaignStatsResponse{
+		Campaign:  campaign,
+		LinkCount: len(urlItems),
+		Links:     make([]model.CampaignLinkStats, 0, len(urlItems)),
+	}
+	for _, urlItem := range urlItems {
+		stats.ClickCount += urlItem.ClickCount
+		stats.Links = append(stats.Links, model.CampaignLinkStats{
+			ShortCode:   urlItem.ShortCode,
+			OriginalURL: urlItem.OriginalURL,
+			ClickCount:  urlItem.ClickCount,
+		})
+	}
+
+	logger.Info("Retrieved stats for campaign", map[string]interface{}{
+		"campaign":   campaign,
+		"linkCount":  stats.LinkCount,
+		"clickCount": stats.ClickCount,
+	})
+
+	// Record metrics
+	if metricClient != nil {
+		latencyMs := float64(time.Since(startTime).Milliseconds())
+		metricClient.RecordAPILatency(ctx, "/campaigns/{name}/stats", latencyMs)
+	}
+
+	responseJSON, _ := json.Marshal(stats)
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(responseJSON),
+	}, nil
+}
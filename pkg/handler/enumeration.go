@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/ratelimit"
+)
+
+// checkLookups refuses clients whose not-found budget is used up, before
+// looking up the code they asked for. Clients are blocked until their
+// bucket refills enough for another miss.
+func (h *Handler) checkLookups(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, bool) {
+	if h.limiter == nil {
+		return events.LambdaFunctionURLResponse{}, true
+	}
+
+	decision, err := h.limiter.Check(ctx, ratelimit.ClassNotFound, rateLimitClient(req))
+	if err != nil {
+		logger.Warn("Failed to check unknown code lookups", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{}, true
+	}
+	if decision == nil || decision.Allowed {
+		return events.LambdaFunctionURLResponse{}, true
+	}
+	return tooManyNotFound(decision), false
+}
+
+// tooManyNotFound answers a client whose not-found budget is used up
+func tooManyNotFound(decision *ratelimit.Decision) events.LambdaFunctionURLResponse {
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusTooManyRequests,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Retry-After":  wholeSeconds(decision.RetryAfter),
+		},
+		Body: `{"error": "Too many requests for unknown links", "code": "too_many_not_found"}`,
+	}
+}
+
+// notFound answers a lookup of a code that does not exist. Each miss takes
+// a token from the client's not-found bucket. Once it is empty the client
+// is refused straight away, here and by checkLookups, rather than slowed
+// down, so blocked clients cost no Lambda time.
+func (h *Handler) notFound(ctx context.Context, req events.LambdaFunctionURLRequest, metricClient *monitoring.Client) events.LambdaFunctionURLResponse {
+	if metricClient != nil {
+		metricClient.RecordURLNotFound(ctx)
+	}
+	resp := events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusNotFound,
+		Body:       `{"error": "URL not found"}`,
+	}
+	if h.limiter == nil {
+		return resp
+	}
+
+	client := rateLimitClient(req)
+	decision, err := h.limiter.Allow(ctx, ratelimit.ClassNotFound, client)
+	if err != nil {
+		logger.Warn("Failed to count unknown code lookup", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return resp
+	}
+	if decision == nil {
+		return resp
+	}
+
+	if !decision.Allowed {
+		return tooManyNotFound(decision)
+	}
+	if decision.Remaining == 0 {
+		logger.Warn("Blocking client after too many unknown codes", map[string]interface{}{
+			"client":     client,
+			"path":       req.RawPath,
+			"retryAfter": wholeSeconds(decision.Reset),
+		})
+		if metricClient != nil {
+			metricClient.RecordLookupsBlocked(ctx)
+		}
+	}
+	return resp
+}
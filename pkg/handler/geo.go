@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Most countries a link can route separately
+const maxGeoRules = 250
+
+// validateGeoRules upper-cases the country codes of geo rules and checks
+// that each one is a two-letter code with a valid destination
+func validateGeoRules(geo map[string]string) (map[string]string, error) {
+	if len(geo) == 0 {
+		return nil, nil
+	}
+	if len(geo) > maxGeoRules {
+		return nil, fmt.Errorf("a link can have at most %d geo rules", maxGeoRules)
+	}
+
+	normalized := make(map[string]string, len(geo))
+	for country, destination := range geo {
+		code := strings.ToUpper(country)
+		if !isCountryCode(code) {
+			return nil, fmt.Errorf("geo rule keys must be two-letter country codes, got %s", country)
+		}
+		if _, exists := normalized[code]; exists {
+			return nil, fmt.Errorf("duplicate geo rule for %s", code)
+		}
+		if !isAppOrHTTPURL(destination) {
+			return nil, fmt.Errorf("geo rule for %s needs an absolute url", code)
+		}
+		normalized[code] = destination
+	}
+	return normalized, nil
+}
+
+// requestCountry returns the visitor's country code. CloudFront's viewer
+// country header wins; otherwise the source IP is looked up in the offline
+// GeoIP database. It returns "" when the country is unknown.
+func (h *Handler) requestCountry(req events.LambdaFunctionURLRequest) string {
+	if country := strings.ToUpper(headerValue(req, "cloudfront-viewer-country")); isCountryCode(country) {
+		return country
+	}
+
+	ip, err := netip.ParseAddr(req.RequestContext.HTTP.SourceIP)
+	if err != nil {
+		return ""
+	}
+	return h.geo.Country(ip)
+}
+
+// isCountryCode reports whether s looks like an ISO 3166-1 alpha-2 code
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
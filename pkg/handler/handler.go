@@ -3,16 +3,25 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/eventbus"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/geoip"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/quota"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/ratelimit"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/useragent"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/workspace"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
 )
@@ -20,16 +29,73 @@ import (
 const (
 	// Length of the generated short code
 	codeLength = 5
+	// Codes drawn for a new link before giving up on finding a free one
+	maxCodeAttempts = 5
+	// Longest campaign name accepted
+	maxCampaignLength = 100
 )
 
 // Handler holds dependencies for URL shortener handlers
 type Handler struct {
-	db database.DynamoDBInterface
+	db  database.DynamoDBInterface
+	now func() time.Time
+	geo *geoip.Database
+
+	webhooks  *webhook.Dispatcher
+	publisher eventbus.EventPublisher
+	limiter   *ratelimit.Limiter
+	quotas    *quota.Plans
+
+	// Workspaces owning short domains; links on them are keyed per domain
+	workspaces *workspace.Directory
 }
 
 // NewHandler creates a new handler with the given database
 func NewHandler(db database.DynamoDBInterface) *Handler {
-	return &Handler{db: db}
+	return &Handler{db: db, now: time.Now, geo: geoip.Default()}
+}
+
+// SetClock replaces the function used to read the current time when
+// checking expiration and activation (for testing)
+func (h *Handler) SetClock(now func() time.Time) {
+	h.now = now
+}
+
+// SetGeoDatabase replaces the database used to find a visitor's country
+// from their IP address (for testing)
+func (h *Handler) SetGeoDatabase(db *geoip.Database) {
+	h.geo = db
+}
+
+// SetWebhooks sets the dispatcher that delivers link events. Without one,
+// no events are sent.
+func (h *Handler) SetWebhooks(dispatcher *webhook.Dispatcher) {
+	h.webhooks = dispatcher
+}
+
+// SetEventPublisher sets where click events are streamed. Without one, no
+// click events are published.
+func (h *Handler) SetEventPublisher(publisher eventbus.EventPublisher) {
+	h.publisher = publisher
+}
+
+// SetRateLimiter sets the limiter CheckRateLimit uses. Lookups of unknown
+// codes are also counted with it. Without one, requests are not rate limited.
+func (h *Handler) SetRateLimiter(limiter *ratelimit.Limiter) {
+	h.limiter = limiter
+}
+
+// SetQuotas sets the quotas owners' links count towards. Without them,
+// owners may create any number of links, though their usage is still
+// counted.
+func (h *Handler) SetQuotas(plans *quota.Plans) {
+	h.quotas = plans
+}
+
+// SetWorkspaces sets the workspaces whose domains links may use. Without
+// them, every link is on the default domain.
+func (h *Handler) SetWorkspaces(directory *workspace.Directory) {
+	h.workspaces = directory
 }
 
 // ShortenURL handles the creation of a new short URL
@@ -46,6 +112,18 @@ func (h *Handler) ShortenURL(ctx context.Context, req events.LambdaFunctionURLRe
 		// Continue without monitoring
 	}
 
+	// Links created with an owner's API key belong to that owner
+	owner := requestOwner(req)
+	if owner == "" && headerValue(req, "x-api-key") != "" && !isAdmin(req) {
+		logger.Warn("Rejected shorten request with unknown API key", map[string]interface{}{
+			"source": req.RequestContext.HTTP.SourceIP,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusUnauthorized,
+			Body:       `{"error": "Invalid API key"}`,
+		}, nil
+	}
+
 	// Parse request body
 	var shortenReq model.ShortenRequest
 	err = json.Unmarshal([]byte(req.Body), &shortenReq)
@@ -68,33 +146,272 @@ func (h *Handler) ShortenURL(ctx context.Context, req events.LambdaFunctionURLRe
 		}, nil
 	}
 
-	// Generate a random code for the short URL
-	code, err := utils.GenerateShortCode(codeLength)
+	if !utils.IsHTTPURL(shortenReq.URL) {
+		logger.Warn("Invalid URL", map[string]interface{}{
+			"url": shortenReq.URL,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "url must be an absolute http or https URL"}`,
+		}, nil
+	}
+
+	if shortenReq.MaxClicks < 0 {
+		logger.Warn("Invalid max clicks", map[string]interface{}{
+			"maxClicks": shortenReq.MaxClicks,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "max_clicks must not be negative"}`,
+		}, nil
+	}
+
+	if shortenReq.RedirectType != 0 && !utils.IsValidRedirectType(shortenReq.RedirectType) {
+		logger.Warn("Invalid redirect type", map[string]interface{}{
+			"redirectType": shortenReq.RedirectType,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "redirect_type must be one of 301, 302, 307 or 308"}`,
+		}, nil
+	}
+
+	if !utils.IsValidForwardQuery(shortenReq.ForwardQuery) {
+		logger.Warn("Invalid forward query mode", map[string]interface{}{
+			"forwardQuery": shortenReq.ForwardQuery,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "forward_query must be merge or override"}`,
+		}, nil
+	}
+
+	if shortenReq.FallbackURL != "" && !utils.IsHTTPURL(shortenReq.FallbackURL) {
+		logger.Warn("Invalid fallback URL", map[string]interface{}{
+			"fallbackURL": shortenReq.FallbackURL,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "fallback_url must be an absolute http or https URL"}`,
+		}, nil
+	}
+
+	// Merge UTM parameters into the destination and default the campaign to utm_campaign
+	destination := shortenReq.URL
+	campaign := shortenReq.Campaign
+	if shortenReq.UTM != nil {
+		destination, err = utils.SetQueryParams(shortenReq.URL, map[string]string{
+			"utm_source":   shortenReq.UTM.Source,
+			"utm_medium":   shortenReq.UTM.Medium,
+			"utm_campaign": shortenReq.UTM.Campaign,
+			"utm_term":     shortenReq.UTM.Term,
+			"utm_content":  shortenReq.UTM.Content,
+		})
+		if err != nil {
+			logger.Warn("Failed to add UTM parameters", map[string]interface{}{
+				"url":   shortenReq.URL,
+				"error": err.Error(),
+			})
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       `{"error": "Invalid URL"}`,
+			}, nil
+		}
+		if campaign == "" {
+			campaign = shortenReq.UTM.Campaign
+		}
+	}
+	if len(campaign) > maxCampaignLength {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(fmt.Sprintf("campaign must be at most %d characters", maxCampaignLength)),
+		}, nil
+	}
+
+	tags, err := normalizeTags(shortenReq.Tags)
+	if err == nil {
+		err = validateDetails(shortenReq.Title, shortenReq.Description, shortenReq.Metadata)
+	}
+	if err == nil {
+		err = validateOpenGraph(shortenReq.OGTitle, shortenReq.OGDescription, shortenReq.OGImage)
+	}
+	var rules []model.RoutingRule
+	if err == nil {
+		rules, err = validateRules(shortenReq.Rules)
+	}
+	var geo map[string]string
+	if err == nil {
+		geo, err = validateGeoRules(shortenReq.Geo)
+	}
+	var variants []model.Variant
+	if err == nil {
+		variants, err = validateVariants(shortenReq.Variants)
+	}
+	var schedule []model.ScheduleWindow
+	var scheduleTimezone string
+	if err == nil {
+		schedule, scheduleTimezone, err = validateSchedule(shortenReq.Schedule)
+	}
+	if err != nil {
+		logger.Warn("Invalid link details", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(err.Error()),
+		}, nil
+	}
+
+	// Links on a workspace domain are keyed by domain and code
+	domain, resp, ok := h.shortenDomain(req, owner, shortenReq.Domain)
+	if !ok {
+		return resp, nil
+	}
+
+	// Generate a random code for the short URL; private links get a code
+	// too long to guess
+	length := codeLength
+	if shortenReq.Private {
+		length = privateCodeLength()
+	}
+	code, err := utils.GenerateShortCode(length)
 	if err != nil {
 		logger.Error("Failed to generate short code", err)
 		return events.LambdaFunctionURLResponse{
 			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf(`{"error": "Failed to generate short code: %v"}`, err),
+			Body:       errorBody("Failed to generate short code: " + err.Error()),
+		}, nil
+	}
+
+	statsToken, statsTokenHash, err := newStatsToken()
+	if err != nil {
+		logger.Error("Failed to generate stats token", err)
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to generate stats token: " + err.Error()),
+		}, nil
+	}
+
+	// Calculate expiration and activation times if provided
+	now := h.now()
+	expiration, err := utils.ResolveExpirationTime(shortenReq.ExpiresAt, shortenReq.ExpireIn, shortenReq.ExpireInDays, now)
+	if err != nil {
+		logger.Warn("Invalid expiration", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(err.Error()),
+		}, nil
+	}
+
+	activeFrom, err := utils.ParseActivationTime(shortenReq.ActiveFrom)
+	if err != nil || (activeFrom > 0 && expiration > 0 && activeFrom >= expiration) {
+		if err == nil {
+			err = fmt.Errorf("active_from must be before the expiration")
+		}
+		logger.Warn("Invalid activation time", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(err.Error()),
 		}, nil
 	}
 
-	// Calculate expiration time if provided
-	expiration := utils.CalculateExpirationTime(shortenReq.ExpireInDays)
+	// Hash the password for protected links
+	var passwordHash string
+	if shortenReq.Password != "" {
+		if unlockSecret() == nil {
+			logger.Error("Refused a password protected link without UNLOCK_COOKIE_SECRET")
+			return passwordLinksDisabled(), nil
+		}
+		passwordHash, err = utils.HashPassword(shortenReq.Password)
+		if err != nil {
+			logger.Warn("Invalid password", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       `{"error": "Invalid password"}`,
+			}, nil
+		}
+	}
 
 	// Create URL item
 	urlItem := &model.URLItem{
-		ShortCode:   code,
-		OriginalURL: shortenReq.URL,
-		CreatedAt:   time.Now().Format(time.RFC3339),
+		ShortCode:   database.LinkKey(domain, code),
+		OriginalURL: destination,
+		CreatedAt:   now.Format(time.RFC3339),
 		Expiration:  expiration,
 		ClickCount:  0,
+		ActiveFrom:  activeFrom,
+		FallbackURL: shortenReq.FallbackURL,
+		MaxClicks:   shortenReq.MaxClicks,
+
+		RedirectType: shortenReq.RedirectType,
+		ForwardQuery: shortenReq.ForwardQuery,
+		ForwardPath:  shortenReq.ForwardPath,
+		Campaign:     campaign,
+		PasswordHash: passwordHash,
+		Interstitial: shortenReq.Interstitial,
+
+		Owner:          owner,
+		Private:        shortenReq.Private,
+		StatsTokenHash: statsTokenHash,
+
+		Title:       shortenReq.Title,
+		Description: shortenReq.Description,
+		Tags:        tags,
+		Metadata:    shortenReq.Metadata,
+
+		OGTitle:       shortenReq.OGTitle,
+		OGDescription: shortenReq.OGDescription,
+		OGImage:       shortenReq.OGImage,
+
+		Rules: rules,
+		Geo:   geo,
+
+		Variants: variants,
+		Sticky:   shortenReq.Sticky,
+
+		Schedule:         schedule,
+		ScheduleTimezone: scheduleTimezone,
 	}
 
-	// Save to DynamoDB
-	err = h.db.CreateURL(ctx, urlItem)
+	// Save to DynamoDB. Owned links are counted towards the owner's quotas
+	// in the same transaction. A code that is already taken is never
+	// overwritten; a new one is drawn instead.
+	for attempt := 1; ; attempt++ {
+		if owner != "" {
+			err = h.db.CreateURLWithUsage(ctx, urlItem, h.quotas.For(owner), now)
+		} else {
+			err = h.db.CreateURL(ctx, urlItem)
+		}
+		if err == nil || attempt == maxCodeAttempts || !strings.Contains(err.Error(), "URL already exists") {
+			break
+		}
+		logger.Warn("Generated short code is taken, drawing another", map[string]interface{}{
+			"shortCode": urlItem.ShortCode,
+			"attempt":   attempt,
+		})
+		if code, err = utils.GenerateShortCode(length); err != nil {
+			break
+		}
+		urlItem.ShortCode = database.LinkKey(domain, code)
+	}
+	var quotaErr *database.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		logger.Warn("Link quota exceeded", map[string]interface{}{
+			"owner": owner,
+			"quota": quotaErr.Quota,
+			"limit": quotaErr.Limit,
+		})
+		return quotaExceededResponse(quotaErr), nil
+	}
 	if err != nil {
 		logger.Error("Failed to create URL in DynamoDB", map[string]interface{}{
-			"shortCode": code,
+			"shortCode": urlItem.ShortCode,
 			"url":       shortenReq.URL,
 			"error":     err.Error(),
 		})
@@ -103,18 +420,27 @@ func (h *Handler) ShortenURL(ctx context.Context, req events.LambdaFunctionURLRe
 		}
 		return events.LambdaFunctionURLResponse{
 			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf(`{"error": "Failed to create short URL: %v"}`, err),
+			Body:       errorBody("Failed to create short URL: " + err.Error()),
 		}, nil
 	}
 
-	// Get base URL from environment variable or use a default
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		// Extract base URL from the request
-		baseURL = fmt.Sprintf("https://%s", req.RequestContext.DomainName)
+	// Index the tags. The link itself is already usable, so a failure is only
+	// logged; editing the tags later rewrites the index entries.
+	if len(tags) > 0 {
+		if err := h.db.SetURLTags(ctx, urlItem.ShortCode, nil, tags); err != nil {
+			logger.Error("Failed to index tags", map[string]interface{}{
+				"shortCode": urlItem.ShortCode,
+				"error":     err.Error(),
+			})
+			if metricClient != nil {
+				metricClient.RecordDynamoDBError(ctx, "SetURLTags")
+			}
+		}
 	}
 
-	shortURL := fmt.Sprintf("%s/%s", baseURL, urlItem.ShortCode)
+	h.notify(webhook.EventLinkCreated, urlItem, nil)
+
+	shortURL := linkShortURL(req, urlItem.ShortCode)
 	logger.Info("Successfully created short URL", map[string]interface{}{
 		"shortCode":   urlItem.ShortCode,
 		"originalURL": urlItem.OriginalURL,
@@ -130,7 +456,9 @@ func (h *Handler) ShortenURL(ctx context.Context, req events.LambdaFunctionURLRe
 	}
 
 	response := model.ShortenResponse{
-		ShortURL: shortURL,
+		ShortURL:   shortURL,
+		QRURL:      shortURL + "/qr",
+		StatsToken: statsToken,
 	}
 
 	responseJSON, _ := json.Marshal(response)
@@ -164,27 +492,32 @@ func (h *Handler) RedirectURL(ctx context.Context, req events.LambdaFunctionURLR
 		// Continue without monitoring
 	}
 
-	// Remove leading slash
-	code := strings.TrimPrefix(path, "/")
+	// The first path segment is the code; anything after it may be passed
+	// through to the destination
+	code, suffix := splitShortPath(path)
+	code, rawQuery, preview := previewRequest(code, req.RawQueryString)
 	logger.Info("Processing redirect request", map[string]interface{}{
 		"shortCode": code,
+		"host":      requestHost(req),
+		"preview":   preview,
 		"requestId": req.RequestContext.RequestID,
 	})
 
+	// Codes are unique per domain, so the host decides which link a code is
+	key := h.linkKey(req, code)
+
+	if resp, ok := h.checkLookups(ctx, req); !ok {
+		return resp, nil
+	}
+
 	// Get URL from DynamoDB
-	urlItem, err := h.db.GetURL(ctx, code)
+	urlItem, err := h.db.GetURL(ctx, key)
 	if err != nil {
 		if strings.Contains(err.Error(), "URL not found") {
 			logger.Warn("URL not found for code", map[string]interface{}{
 				"shortCode": code,
 			})
-			if metricClient != nil {
-				metricClient.RecordURLNotFound(ctx)
-			}
-			return events.LambdaFunctionURLResponse{
-				StatusCode: http.StatusNotFound,
-				Body:       `{"error": "URL not found"}`,
-			}, nil
+			return h.notFound(ctx, req, metricClient), nil
 		}
 		
 		logger.Error("Failed to retrieve URL from DynamoDB", map[string]interface{}{
@@ -196,28 +529,184 @@ func (h *Handler) RedirectURL(ctx context.Context, req events.LambdaFunctionURLR
 		}
 		return events.LambdaFunctionURLResponse{
 			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf(`{"error": "Failed to retrieve URL: %v"}`, err),
+			Body:       errorBody("Failed to retrieve URL: " + err.Error()),
 		}, nil
 	}
 
-	// Increment click count (don't wait for the result)
-	go func() {
-		err := h.db.IncrementClickCount(context.Background(), code)
-		if err != nil {
-			logger.Error("Failed to increment click count", map[string]interface{}{
-				"shortCode": code,
-				"error":     err.Error(),
-			})
-			if metricClient != nil {
-				metricClient.RecordDynamoDBError(ctx, "IncrementClickCount")
-			}
+	if suffix != "" && !urlItem.ForwardPath {
+		logger.Warn("Path suffix on URL without path forwarding", map[string]interface{}{
+			"shortCode": code,
+			"suffix":    suffix,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       `{"error": "URL not found"}`,
+		}, nil
+	}
+
+	// Routing rules pick the destination by device; clicks are counted
+	// against the rule that fired. Geo rules apply when no device rule
+	// matched, then the scheduled window for the current time, and A/B
+	// variants when none of these did.
+	now := h.now()
+	target := urlItem
+	var ruleName string
+	country := h.requestCountry(req)
+	if len(urlItem.Rules) > 0 {
+		ruleName = defaultRuleName
+		if rule := matchRule(urlItem.Rules, useragent.Parse(requestUserAgent(req))); rule != nil {
+			routed := *urlItem
+			routed.OriginalURL = rule.URL
+			target = &routed
+			ruleName = rule.Name
+		}
+	}
+	if destination, ok := urlItem.Geo[country]; ok && target == urlItem {
+		routed := *urlItem
+		routed.OriginalURL = destination
+		target = &routed
+	}
+	if window := activeWindow(urlItem.Schedule, now); window != nil && target == urlItem {
+		routed := *urlItem
+		routed.OriginalURL = window.URL
+		target = &routed
+	}
+	var variantName string
+	if len(urlItem.Variants) > 0 && target == urlItem {
+		if variant := pickVariant(urlItem, req); variant != nil {
+			routed := *urlItem
+			routed.OriginalURL = variant.URL
+			target = &routed
+			variantName = variant.Name
+		}
+	}
+
+	destination, err := buildDestination(target, suffix, rawQuery)
+	if err != nil {
+		logger.Warn("Failed to build destination URL", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Invalid path or query string"}`,
+		}, nil
+	}
+
+	if utils.IsExpired(urlItem.Expiration, now) {
+		logger.Info("Redirect requested for expired URL", map[string]interface{}{
+			"shortCode":  code,
+			"expiration": urlItem.Expiration,
+		})
+		if metricClient != nil {
+			metricClient.RecordURLExpired(ctx)
+		}
+		h.notifyExpired(ctx, urlItem)
+		return h.fallbackOr(ctx, metricClient, urlItem, "expired", expiredResponse()), nil
+	}
+
+	if urlItem.ActiveFrom > now.Unix() {
+		logger.Info("Redirect requested before link is active", map[string]interface{}{
+			"shortCode":  code,
+			"activeFrom": urlItem.ActiveFrom,
+		})
+		return notYetActiveResponse(urlItem, now), nil
+	}
+
+	if urlItem.Disabled {
+		logger.Warn("Redirect requested for disabled URL", map[string]interface{}{
+			"shortCode": code,
+		})
+		return h.fallbackOr(ctx, metricClient, urlItem, "disabled", events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusGone,
+			Body:       `{"error": "URL disabled"}`,
+		}), nil
+	}
+
+	if urlItem.PasswordHash != "" && !hasValidUnlockCookie(req, key, now) {
+		logger.Info("Password required for URL", map[string]interface{}{
+			"shortCode": code,
+		})
+		return passwordFormResponse(code, "", http.StatusOK), nil
+	}
+
+	// Count the click before redirecting so click limits hold under
+	// concurrent redirects
+	if urlItem.MaxClicks > 0 && urlItem.ClickCount >= urlItem.MaxClicks {
+		return h.clickLimitReached(ctx, metricClient, urlItem), nil
+	}
+
+	// Unfurlers get the link's Open Graph metadata instead of the redirect,
+	// which many destinations block for bots. This is not counted as a click.
+	if agent := unfurlerAgent(req); agent != "" {
+		logger.Info("Serving unfurl metadata", map[string]interface{}{
+			"shortCode": code,
+			"agent":     agent,
+		})
+		if metricClient != nil {
+			metricClient.RecordUnfurlServed(ctx, agent)
+		}
+		return unfurlResponse(req, urlItem, destination), nil
+	}
+
+	// A preview is not a visit, so it is answered before counting
+	if preview {
+		logger.Info("Showing link preview", map[string]interface{}{
+			"shortCode":   code,
+			"destination": destination,
+		})
+		return previewResponse(urlItem, destination, suffix, rawQuery), nil
+	}
+	var breakdowns []database.ClickBreakdown
+	if ruleName != "" {
+		breakdowns = append(breakdowns, database.ClickBreakdown{Attribute: database.RuleClicksAttribute, Key: ruleName})
+	}
+	if country != "" {
+		breakdowns = append(breakdowns, database.ClickBreakdown{Attribute: database.CountryClicksAttribute, Key: country})
+	}
+	if variantName != "" {
+		breakdowns = append(breakdowns, database.ClickBreakdown{Attribute: database.VariantClicksAttribute, Key: variantName})
+	}
+	err = h.db.IncrementClickCount(ctx, key, breakdowns...)
+	if err != nil {
+		if strings.Contains(err.Error(), "click limit reached") {
+			return h.clickLimitReached(ctx, metricClient, urlItem), nil
+		}
+
+		// Counting is best effort for links without a limit; still redirect
+		logger.Error("Failed to increment click count", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "IncrementClickCount")
 		}
-	}()
+		if urlItem.MaxClicks > 0 {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       errorBody("Failed to record click: " + err.Error()),
+			}, nil
+		}
+	}
+
+	clicked := *urlItem
+	if err == nil {
+		clicked.ClickCount++
+	}
+	h.notify(webhook.EventLinkClicked, &clicked, &webhook.Click{
+		Destination: destination,
+		Country:     country,
+		Rule:        ruleName,
+		Variant:     variantName,
+	})
+	h.publishClick(ctx, req, urlItem, destination, country, ruleName, variantName)
 
 	logger.Info("Redirecting to original URL", map[string]interface{}{
 		"shortCode":   code,
 		"originalURL": urlItem.OriginalURL,
-		"clickCount":  urlItem.ClickCount + 1, // +1 because we're incrementing
+		"destination": destination,
+		"rule":        ruleName,
+		"clickCount":  urlItem.ClickCount + 1, // +1 because we incremented it
 	})
 
 	// Record metrics
@@ -227,14 +716,12 @@ func (h *Handler) RedirectURL(ctx context.Context, req events.LambdaFunctionURLR
 		metricClient.RecordAPILatency(ctx, "/{shortCode}", latencyMs)
 	}
 
+	if urlItem.Interstitial {
+		return interstitialResponse(req, urlItem, destination), nil
+	}
+
 	// Redirect to the original URL
-	return events.LambdaFunctionURLResponse{
-		StatusCode: http.StatusFound,
-		Headers: map[string]string{
-			"Location": urlItem.OriginalURL,
-		},
-		Body: "",
-	}, nil
+	return redirectResponse(destination, redirectStatus(urlItem)), nil
 }
 
 // GetURLStats retrieves analytics for a short URL
@@ -266,20 +753,19 @@ func (h *Handler) GetURLStats(ctx context.Context, req events.LambdaFunctionURLR
 		"requestId": req.RequestContext.RequestID,
 	})
 
-	// Get URL from DynamoDB
-	urlItem, err := h.db.GetURL(ctx, code)
+	if resp, ok := h.checkLookups(ctx, req); !ok {
+		return resp, nil
+	}
+
+	// Get URL from DynamoDB; on a workspace domain the code is looked up
+	// there, and elsewhere a full "<domain>/<code>" key works too
+	urlItem, err := h.db.GetURL(ctx, h.linkKey(req, code))
 	if err != nil {
 		if strings.Contains(err.Error(), "URL not found") {
 			logger.Warn("URL not found for stats", map[string]interface{}{
 				"shortCode": code,
 			})
-			if metricClient != nil {
-				metricClient.RecordURLNotFound(ctx)
-			}
-			return events.LambdaFunctionURLResponse{
-				StatusCode: http.StatusNotFound,
-				Body:       `{"error": "URL not found"}`,
-			}, nil
+			return h.notFound(ctx, req, metricClient), nil
 		}
 		
 		logger.Error("Failed to retrieve URL for stats", map[string]interface{}{
@@ -291,18 +777,22 @@ func (h *Handler) GetURLStats(ctx context.Context, req events.LambdaFunctionURLR
 		}
 		return events.LambdaFunctionURLResponse{
 			StatusCode: http.StatusInternalServerError,
-			Body:       fmt.Sprintf(`{"error": "Failed to retrieve URL: %v"}`, err),
+			Body:       errorBody("Failed to retrieve URL: " + err.Error()),
 		}, nil
 	}
 
-	// Create stats response
-	stats := model.StatsResponse{
-		OriginalURL: urlItem.OriginalURL,
-		CreatedAt:   urlItem.CreatedAt,
-		Expiration:  urlItem.Expiration,
-		ClickCount:  urlItem.ClickCount,
+	// Stats belong to the link's owner; knowing the code is not enough.
+	// Others get the same answer as for a code that does not exist.
+	if !canViewStats(req, urlItem) {
+		logger.Warn("Stats request without access to the link", map[string]interface{}{
+			"shortCode": code,
+		})
+		return h.notFound(ctx, req, metricClient), nil
 	}
 
+	// Create stats response
+	stats := StatsResponse(urlItem, h.now())
+
 	logger.Info("Retrieved stats for URL", map[string]interface{}{
 		"shortCode":   code,
 		"originalURL": urlItem.OriginalURL,
@@ -326,4 +816,169 @@ func (h *Handler) GetURLStats(ctx context.Context, req events.LambdaFunctionURLR
 		},
 		Body: string(responseJSON),
 	}, nil
-}
\ No newline at end of file
+}
+
+// StatsResponse reports a link's settings and analytics as of now, as
+// GET /stats/{shortCode} returns them
+func StatsResponse(urlItem *model.URLItem, now time.Time) model.StatsResponse {
+	return model.StatsResponse{
+		OriginalURL: urlItem.OriginalURL,
+		CreatedAt:   urlItem.CreatedAt,
+		Expiration:  urlItem.Expiration,
+		ClickCount:  urlItem.ClickCount,
+		Disabled:    urlItem.Disabled,
+		ExpiresAt:   utils.FormatTimestamp(urlItem.Expiration),
+		ActiveFrom:  utils.FormatTimestamp(urlItem.ActiveFrom),
+		Expired:     utils.IsExpired(urlItem.Expiration, now),
+		FallbackURL: urlItem.FallbackURL,
+		MaxClicks:   urlItem.MaxClicks,
+
+		RedirectType:      redirectStatus(urlItem),
+		ForwardQuery:      urlItem.ForwardQuery,
+		ForwardPath:       urlItem.ForwardPath,
+		PasswordProtected: urlItem.PasswordHash != "",
+		Interstitial:      urlItem.Interstitial,
+		Campaign:          urlItem.Campaign,
+		Private:           urlItem.Private,
+		Domain:            linkDomain(urlItem),
+		Owner:             urlItem.Owner,
+		Title:             urlItem.Title,
+		Description:       urlItem.Description,
+		Tags:              urlItem.Tags,
+		Metadata:          urlItem.Metadata,
+		OGTitle:           urlItem.OGTitle,
+		OGDescription:     urlItem.OGDescription,
+		OGImage:           urlItem.OGImage,
+		Rules:             urlItem.Rules,
+		RuleClicks:        urlItem.RuleClicks,
+		Geo:               urlItem.Geo,
+		CountryClicks:     urlItem.CountryClicks,
+		Sticky:            urlItem.Sticky,
+		Variants:          variantStats(urlItem),
+		Schedule:          scheduleResponse(urlItem),
+	}
+}
+
+// shortURLFor builds the public short URL for code, using BASE_URL when it
+// is set and the request's domain otherwise
+func shortURLFor(req events.LambdaFunctionURLRequest, code string) string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		// Extract base URL from the request
+		baseURL = fmt.Sprintf("https://%s", req.RequestContext.DomainName)
+	}
+	return fmt.Sprintf("%s/%s", baseURL, code)
+}
+
+// notYetActiveResponse answers a redirect for a link whose active_from time
+// has not arrived. By default it looks like any unknown code; setting
+// NOT_YET_ACTIVE_RESPONSE=explain tells the caller when to come back.
+func notYetActiveResponse(urlItem *model.URLItem, now time.Time) events.LambdaFunctionURLResponse {
+	if os.Getenv("NOT_YET_ACTIVE_RESPONSE") != "explain" {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       `{"error": "URL not found"}`,
+		}
+	}
+
+	retryAfter := urlItem.ActiveFrom - now.Unix()
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusForbidden,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Retry-After":  strconv.FormatInt(retryAfter, 10),
+		},
+		Body: fmt.Sprintf(`{"error": "URL not yet active", "active_from": "%s"}`, utils.FormatTimestamp(urlItem.ActiveFrom)),
+	}
+}
+
+// expiredResponse answers a redirect for a link whose expiration has passed
+// but which DynamoDB TTL has not deleted yet
+func expiredResponse() events.LambdaFunctionURLResponse {
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusGone,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: `{"error": "URL expired", "code": "expired"}`,
+	}
+}
+
+// clickLimitReached answers a redirect for a link that has used up its
+// max_clicks allowance
+func (h *Handler) clickLimitReached(ctx context.Context, metricClient *monitoring.Client, urlItem *model.URLItem) events.LambdaFunctionURLResponse {
+	logger.Info("Click limit reached for URL", map[string]interface{}{
+		"shortCode": urlItem.ShortCode,
+		"maxClicks": urlItem.MaxClicks,
+	})
+	if metricClient != nil {
+		metricClient.RecordClickLimitReached(ctx)
+	}
+
+	return h.fallbackOr(ctx, metricClient, urlItem, "click_limit", events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusGone,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: `{"error": "Click limit reached", "code": "click_limit_reached"}`,
+	})
+}
+
+// fallbackOr redirects to the link's fallback URL, or the global
+// DEFAULT_FALLBACK_URL, when a link can no longer be followed. If neither is
+// configured the given response is returned unchanged.
+func (h *Handler) fallbackOr(ctx context.Context, metricClient *monitoring.Client, urlItem *model.URLItem, reason string, otherwise events.LambdaFunctionURLResponse) events.LambdaFunctionURLResponse {
+	fallbackURL := urlItem.FallbackURL
+	if fallbackURL == "" {
+		fallbackURL = os.Getenv("DEFAULT_FALLBACK_URL")
+	}
+	if fallbackURL == "" {
+		return otherwise
+	}
+
+	logger.Info("Redirecting to fallback URL", map[string]interface{}{
+		"shortCode":   urlItem.ShortCode,
+		"reason":      reason,
+		"fallbackURL": fallbackURL,
+	})
+	if metricClient != nil {
+		metricClient.RecordFallbackRedirect(ctx, reason)
+	}
+
+	return redirectResponse(fallbackURL, http.StatusFound)
+}
+
+// publishClick streams a click event, if a publisher is set. Publishing
+// only queues the event, so it does not slow the redirect down.
+func (h *Handler) publishClick(ctx context.Context, req events.LambdaFunctionURLRequest, urlItem *model.URLItem, destination, country, ruleName, variantName string) {
+	if h.publisher == nil {
+		return
+	}
+
+	userAgent := requestUserAgent(req)
+	visitor := useragent.Parse(userAgent)
+	event := eventbus.NewClickEvent(h.now())
+	event.RequestID = req.RequestContext.RequestID
+	event.ShortCode = urlItem.ShortCode
+	event.Campaign = urlItem.Campaign
+	event.Owner = urlItem.Owner
+	event.Destination = destination
+	event.Rule = ruleName
+	event.Variant = variantName
+	event.Country = country
+	event.OS = visitor.OS
+	event.Device = visitor.Device
+	event.Browser = visitor.Browser
+	event.Referrer = headerValue(req, "referer")
+	event.UserAgent = userAgent
+
+	if err := h.publisher.Publish(ctx, event); err != nil {
+		logger.Warn("Failed to publish click event", map[string]interface{}{
+			"shortCode": urlItem.ShortCode,
+			"error":     err.Error(),
+		})
+	}
+}
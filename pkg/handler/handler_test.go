@@ -3,13 +3,22 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/eventbus"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/geoip"
 	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/quota"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/ratelimit"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook/webhooktest"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/workspace"
 )
 
 func TestShortenURL(t *testing.T) {
@@ -55,6 +64,11 @@ func TestShortenURL(t *testing.T) {
 	if !strings.Contains(shortenResp.ShortURL, "test.lambda-url.us-east-1.amazonaws.com") {
 		t.Errorf("Expected short URL to contain domain name, got %s", shortenResp.ShortURL)
 	}
+
+	// Check that the QR code URL points at the short URL
+	if shortenResp.QRURL != shortenResp.ShortURL+"/qr" {
+		t.Errorf("Expected QR URL %s/qr, got %s", shortenResp.ShortURL, shortenResp.QRURL)
+	}
 	
 	// Test error case - database failure
 	mockDB.SetFailNext(true)
@@ -95,6 +109,70 @@ func TestShortenURL(t *testing.T) {
 	if resp.StatusCode != 400 {
 		t.Errorf("Expected status code 400 on missing URL, got %d", resp.StatusCode)
 	}
+
+	// Test error messages quoting user input are still valid JSON
+	resp, _ = handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "geo": {"a\\\"b": "https://example.com/x"}}`,
+	})
+	var errorResp map[string]string
+	if err := json.Unmarshal([]byte(resp.Body), &errorResp); resp.StatusCode != 400 || err != nil || !strings.Contains(errorResp["error"], `a\"b`) {
+		t.Errorf("Expected a JSON error quoting the geo key, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test URLs other than absolute http or https URLs are rejected
+	for _, rawURL := range []string{"example.com", "javascript:alert(1)"} {
+		resp, _ = handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body: fmt.Sprintf(`{"url": %q}`, rawURL),
+		})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, got %d", rawURL, resp.StatusCode)
+		}
+	}
+
+	// Test conflicting expiration options
+	conflictingReq := events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "expire_in_days": 7, "expire_in": "36h"}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, err = handler.ShortenURL(context.Background(), conflictingReq)
+	if err != nil {
+		t.Fatalf("ShortenURL should handle errors internally: %v", err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 on conflicting expiration, got %d", resp.StatusCode)
+	}
+
+	// Test activation after expiration
+	activationReq := events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "expire_in": "1h", "active_from": "2999-01-01T00:00:00Z"}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, err = handler.ShortenURL(context.Background(), activationReq)
+	if err != nil {
+		t.Fatalf("ShortenURL should handle errors internally: %v", err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 when active_from is after expiration, got %d", resp.StatusCode)
+	}
+
+	// Test unsupported redirect type
+	redirectTypeReq := events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "redirect_type": 303}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, err = handler.ShortenURL(context.Background(), redirectTypeReq)
+	if err != nil {
+		t.Fatalf("ShortenURL should handle errors internally: %v", err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 on unsupported redirect type, got %d", resp.StatusCode)
+	}
 }
 
 func TestRedirectURL(t *testing.T) {
@@ -177,6 +255,183 @@ func TestRedirectURL(t *testing.T) {
 		t.Errorf("Expected status code 404 for non-existent code, got %d", resp.StatusCode)
 	}
 	
+	// Test disabled link
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:   "disabled",
+		OriginalURL: "https://example.com",
+		Disabled:    true,
+	})
+	disabledReq := events.LambdaFunctionURLRequest{
+		RawPath: "/disabled",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, err = handler.RedirectURL(context.Background(), disabledReq)
+	if err != nil {
+		t.Fatalf("RedirectURL should handle errors internally: %v", err)
+	}
+	if resp.StatusCode != 410 {
+		t.Errorf("Expected status code 410 for disabled link, got %d", resp.StatusCode)
+	}
+
+	// Test expired link that TTL has not deleted yet
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:   "expired",
+		OriginalURL: "https://example.com",
+		Expiration:  1700000000,
+	})
+	expiredReq := events.LambdaFunctionURLRequest{
+		RawPath: "/expired",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	handler.SetClock(func() time.Time { return time.Unix(1700000000, 0) })
+	resp, err = handler.RedirectURL(context.Background(), expiredReq)
+	if err != nil {
+		t.Fatalf("RedirectURL should handle errors internally: %v", err)
+	}
+	if resp.StatusCode != 410 || !strings.Contains(resp.Body, `"expired"`) {
+		t.Errorf("Expected status code 410 with expired code at expiration time, got %d %s", resp.StatusCode, resp.Body)
+	}
+	handler.SetClock(func() time.Time { return time.Unix(1699999999, 0) })
+	resp, _ = handler.RedirectURL(context.Background(), expiredReq)
+	if resp.StatusCode != 302 {
+		t.Errorf("Expected status code 302 before expiration, got %d", resp.StatusCode)
+	}
+
+	// Test fallback destinations for expired and disabled links
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:   "campaign",
+		OriginalURL: "https://example.com/campaign",
+		Expiration:  1700000000,
+		FallbackURL: "https://example.com/campaign-over",
+	})
+	campaignReq := events.LambdaFunctionURLRequest{
+		RawPath: "/campaign",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	handler.SetClock(func() time.Time { return time.Unix(1700000001, 0) })
+	resp, _ = handler.RedirectURL(context.Background(), campaignReq)
+	if resp.StatusCode != 302 || resp.Headers["Location"] != "https://example.com/campaign-over" {
+		t.Errorf("Expected redirect to per-link fallback, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	t.Setenv("DEFAULT_FALLBACK_URL", "https://example.com/home")
+	resp, _ = handler.RedirectURL(context.Background(), disabledReq)
+	if resp.StatusCode != 302 || resp.Headers["Location"] != "https://example.com/home" {
+		t.Errorf("Expected redirect to default fallback, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	t.Setenv("DEFAULT_FALLBACK_URL", "")
+	handler.SetClock(time.Now)
+
+	// Test per-link and default redirect types
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:    "permanent",
+		OriginalURL:  "https://example.com/seo",
+		RedirectType: 308,
+	})
+	permanentReq := events.LambdaFunctionURLRequest{
+		RawPath: "/permanent",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, _ = handler.RedirectURL(context.Background(), permanentReq)
+	if resp.StatusCode != 308 || !strings.Contains(resp.Headers["Cache-Control"], "max-age=") {
+		t.Errorf("Expected cacheable 308 redirect, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "307")
+	resp, _ = handler.RedirectURL(context.Background(), req)
+	if resp.StatusCode != 307 || resp.Headers["Cache-Control"] != "private, no-store" {
+		t.Errorf("Expected uncached 307 redirect from default, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "")
+
+	// Test path suffixes only resolve for links with path forwarding
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:    "docs",
+		OriginalURL:  "https://example.com/docs",
+		ForwardPath:  true,
+		ForwardQuery: "merge",
+	})
+	docsReq := events.LambdaFunctionURLRequest{
+		RawPath:        "/docs/guide/intro",
+		RawQueryString: "utm_source=newsletter",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, _ = handler.RedirectURL(context.Background(), docsReq)
+	if resp.Headers["Location"] != "https://example.com/docs/guide/intro?utm_source=newsletter" {
+		t.Errorf("Expected path and query to be forwarded, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	suffixReq := req
+	suffixReq.RawPath = "/" + testCode + "/extra"
+	resp, _ = handler.RedirectURL(context.Background(), suffixReq)
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected status code 404 for path suffix without forwarding, got %d", resp.StatusCode)
+	}
+
+	// Test one-time link under concurrent redirects
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:   "once",
+		OriginalURL: "https://example.com/download",
+		MaxClicks:   1,
+	})
+	onceReq := events.LambdaFunctionURLRequest{
+		RawPath: "/once",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	statuses := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			resp, _ := handler.RedirectURL(context.Background(), onceReq)
+			statuses <- resp.StatusCode
+		}()
+	}
+	redirects, gone := 0, 0
+	for i := 0; i < 5; i++ {
+		switch <-statuses {
+		case 302:
+			redirects++
+		case 410:
+			gone++
+		}
+	}
+	if redirects != 1 || gone != 4 {
+		t.Errorf("Expected exactly one redirect and four 410s for a one-time link, got %d and %d", redirects, gone)
+	}
+
+	// Test link that is not active yet
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:   "launch",
+		OriginalURL: "https://example.com",
+		ActiveFrom:  time.Now().Add(time.Hour).Unix(),
+	})
+	launchReq := events.LambdaFunctionURLRequest{
+		RawPath: "/launch",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	resp, err = handler.RedirectURL(context.Background(), launchReq)
+	if err != nil {
+		t.Fatalf("RedirectURL should handle errors internally: %v", err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected status code 404 before activation, got %d", resp.StatusCode)
+	}
+	t.Setenv("NOT_YET_ACTIVE_RESPONSE", "explain")
+	resp, _ = handler.RedirectURL(context.Background(), launchReq)
+	if resp.StatusCode != 403 || resp.Headers["Retry-After"] == "" {
+		t.Errorf("Expected 403 with Retry-After before activation, got %d %v", resp.StatusCode, resp.Headers)
+	}
+
 	// Test database error
 	mockDB.SetFailNext(true)
 	resp, err = handler.RedirectURL(context.Background(), req)
@@ -189,6 +444,8 @@ func TestRedirectURL(t *testing.T) {
 }
 
 func TestGetURLStats(t *testing.T) {
+	t.Setenv("OWNER_API_KEYS", "team-a=team-a-key, team-b=team-b-key")
+
 	// Setup mock database
 	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
 	handler := NewHandler(mockDB)
@@ -201,11 +458,13 @@ func TestGetURLStats(t *testing.T) {
 		CreatedAt:   "1234567890",
 		Expiration:  9876543210,
 		ClickCount:  42,
+		Owner:       "team-a",
 	})
 	
 	// Create a mock request
 	req := events.LambdaFunctionURLRequest{
 		RawPath: "/stats/" + testCode,
+		Headers: map[string]string{"x-api-key": "team-a-key"},
 		RequestContext: events.LambdaFunctionURLRequestContext{
 			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
 		},
@@ -238,7 +497,29 @@ func TestGetURLStats(t *testing.T) {
 	if statsResp.ClickCount != 42 {
 		t.Errorf("Expected click count 42, got %d", statsResp.ClickCount)
 	}
+	if statsResp.Owner != "team-a" {
+		t.Errorf("Expected owner 'team-a', got '%s'", statsResp.Owner)
+	}
 	
+	// Test stats remain readable after expiration
+	handler.SetClock(func() time.Time { return time.Unix(9876543210, 0) })
+	resp, _ = handler.GetURLStats(context.Background(), req)
+	statsResp = model.StatsResponse{}
+	json.Unmarshal([]byte(resp.Body), &statsResp)
+	if resp.StatusCode != 200 || !statsResp.Expired {
+		t.Errorf("Expected expired stats to be readable and flagged, got %d %s", resp.StatusCode, resp.Body)
+	}
+	handler.SetClock(time.Now)
+
+	// Test knowing the code is not enough: other owners and anonymous
+	// callers get the same 404 as for an unknown code
+	for _, headers := range []map[string]string{nil, {"x-api-key": "team-b-key"}} {
+		resp, _ = handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{RawPath: "/stats/" + testCode, Headers: headers})
+		if resp.StatusCode != 404 || resp.Body != `{"error": "URL not found"}` {
+			t.Errorf("Expected 404 for stats without access (%v), got %d %s", headers, resp.StatusCode, resp.Body)
+		}
+	}
+
 	// Test non-existent code
 	nonExistentReq := events.LambdaFunctionURLRequest{
 		RawPath: "/stats/nonexistent",
@@ -278,4 +559,1570 @@ func TestGetURLStats(t *testing.T) {
 	if resp.StatusCode != 400 {
 		t.Errorf("Expected status code 400 on invalid path, got %d", resp.StatusCode)
 	}
-}
\ No newline at end of file
+}
+func TestUnlockURL(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	t.Setenv("UNLOCK_COOKIE_SECRET", "test-secret")
+
+	// Create a password protected URL
+	hash, err := utils.HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("HashPassword returned an error: %v", err)
+	}
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:    "secret",
+		OriginalURL:  "https://example.com/internal",
+		PasswordHash: hash,
+	})
+
+	redirectReq := events.LambdaFunctionURLRequest{
+		RawPath: "/secret",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+	unlockReq := func(password string) events.LambdaFunctionURLRequest {
+		return events.LambdaFunctionURLRequest{
+			RawPath: "/secret/unlock",
+			Body:    "password=" + password,
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+			},
+		}
+	}
+
+	// Without a cookie the password form is served instead of a redirect
+	resp, err := handler.RedirectURL(context.Background(), redirectReq)
+	if err != nil {
+		t.Fatalf("RedirectURL returned an error: %v", err)
+	}
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, `action="/secret/unlock"`) {
+		t.Errorf("Expected password form, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Wrong password
+	resp, err = handler.UnlockURL(context.Background(), unlockReq("wrong"))
+	if err != nil {
+		t.Fatalf("UnlockURL returned an error: %v", err)
+	}
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected status code 401 for wrong password, got %d", resp.StatusCode)
+	}
+
+	// Correct password sets a cookie that allows the redirect
+	resp, err = handler.UnlockURL(context.Background(), unlockReq("hunter2"))
+	if err != nil {
+		t.Fatalf("UnlockURL returned an error: %v", err)
+	}
+	if resp.StatusCode != 303 || len(resp.Cookies) != 1 {
+		t.Fatalf("Expected 303 with a cookie, got %d %v", resp.StatusCode, resp.Cookies)
+	}
+	cookie := strings.SplitN(resp.Cookies[0], ";", 2)[0]
+	redirectReq.Cookies = []string{cookie}
+	resp, _ = handler.RedirectURL(context.Background(), redirectReq)
+	if resp.StatusCode != 302 || resp.Headers["Location"] != "https://example.com/internal" {
+		t.Errorf("Expected redirect with unlock cookie, got %d %v", resp.StatusCode, resp.Headers)
+	}
+
+	// Tampered or expired cookies are rejected
+	redirectReq.Cookies = []string{cookie + "x"}
+	resp, _ = handler.RedirectURL(context.Background(), redirectReq)
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected password form for tampered cookie, got %d", resp.StatusCode)
+	}
+	redirectReq.Cookies = []string{cookie}
+	handler.SetClock(func() time.Time { return time.Now().Add(time.Hour) })
+	resp, _ = handler.RedirectURL(context.Background(), redirectReq)
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected password form for expired cookie, got %d", resp.StatusCode)
+	}
+	handler.SetClock(time.Now)
+
+	// Repeated failures lock out unlocking, even with the right password
+	for i := 0; i < maxUnlockAttempts; i++ {
+		resp, _ = handler.UnlockURL(context.Background(), unlockReq("wrong"))
+	}
+	if resp.StatusCode != 429 || resp.Headers["Retry-After"] == "" {
+		t.Errorf("Expected 429 with Retry-After after repeated failures, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	resp, _ = handler.UnlockURL(context.Background(), unlockReq("hunter2"))
+	if resp.StatusCode != 429 {
+		t.Errorf("Expected status code 429 during lockout, got %d", resp.StatusCode)
+	}
+
+	// The lockout only applies to the client that sent the wrong passwords
+	otherReq := unlockReq("hunter2")
+	otherReq.RequestContext.HTTP.SourceIP = "198.51.100.7"
+	if resp, _ = handler.UnlockURL(context.Background(), otherReq); resp.StatusCode != 303 {
+		t.Errorf("Expected another client to unlock the link, got %d", resp.StatusCode)
+	}
+
+	// Without a signing secret, password protected links are refused
+	t.Setenv("UNLOCK_COOKIE_SECRET", "")
+	if resp, _ = handler.UnlockURL(context.Background(), otherReq); resp.StatusCode != 503 {
+		t.Errorf("Expected status code 503 without a secret, got %d", resp.StatusCode)
+	}
+	resp, _ = handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "password": "hunter2"}`,
+	})
+	if resp.StatusCode != 503 || !strings.Contains(resp.Body, "unlock_secret_missing") {
+		t.Errorf("Expected password links to be refused without a secret, got %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestBuildDestination(t *testing.T) {
+	tests := []struct {
+		name        string
+		urlItem     model.URLItem
+		suffix      string
+		rawQuery    string
+		destination string
+	}{
+		{
+			name:        "no passthrough configured",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/docs?ref=a"},
+			suffix:      "/page",
+			rawQuery:    "utm_source=x",
+			destination: "https://example.com/docs?ref=a",
+		},
+		{
+			name:        "merge keeps destination values",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/docs?ref=a", ForwardQuery: "merge"},
+			rawQuery:    "ref=b&utm_source=x",
+			destination: "https://example.com/docs?ref=a&utm_source=x",
+		},
+		{
+			name:        "override replaces destination values",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/docs?ref=a", ForwardQuery: "override"},
+			rawQuery:    "ref=b&utm_source=x",
+			destination: "https://example.com/docs?ref=b&utm_source=x",
+		},
+		{
+			name:        "query values are re-escaped",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/", ForwardQuery: "merge"},
+			rawQuery:    "q=a%20b%26c",
+			destination: "https://example.com/?q=a+b%26c",
+		},
+		{
+			name:        "path suffix is appended",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/base/", ForwardPath: true},
+			suffix:      "/docs/my%20page",
+			destination: "https://example.com/base/docs/my%20page",
+		},
+		{
+			name:        "dot segments are dropped",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/base", ForwardPath: true},
+			suffix:      "/../../admin",
+			destination: "https://example.com/base/admin",
+		},
+		{
+			name:        "path and query together",
+			urlItem:     model.URLItem{OriginalURL: "https://example.com/base?x=1", ForwardPath: true, ForwardQuery: "merge"},
+			suffix:      "/a/",
+			rawQuery:    "y=2",
+			destination: "https://example.com/base/a/?x=1&y=2",
+		},
+	}
+
+	for _, tc := range tests {
+		destination, err := buildDestination(&tc.urlItem, tc.suffix, tc.rawQuery)
+		if err != nil {
+			t.Errorf("%s: buildDestination returned an error: %v", tc.name, err)
+			continue
+		}
+		if destination != tc.destination {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.destination, destination)
+		}
+	}
+}
+
+func TestGetCampaignStats(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+	t.Setenv("OWNER_API_KEYS", "team-a=team-a-key,team-b=team-b-key")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	// Create links through the API with UTM parameters
+	for _, body := range []string{
+		`{"url": "https://example.com/a?ref=x", "utm": {"source": "newsletter", "medium": "email", "campaign": "spring sale"}}`,
+		`{"url": "https://example.com/b", "utm": {"source": "twitter"}, "campaign": "spring sale"}`,
+	} {
+		resp, err := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body:    body,
+			Headers: map[string]string{"x-api-key": "team-a-key"},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+			},
+		})
+		if err != nil || resp.StatusCode != 201 {
+			t.Fatalf("ShortenURL failed: %d %v", resp.StatusCode, err)
+		}
+	}
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "other", OriginalURL: "https://example.com", Campaign: "winter", ClickCount: 9})
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "privateCode12345", OriginalURL: "https://example.com/private", Campaign: "spring sale", Owner: "team-b", Private: true, ClickCount: 5})
+
+	req := events.LambdaFunctionURLRequest{
+		RawPath: "/campaigns/spring%20sale/stats",
+		Headers: map[string]string{"x-api-key": "team-a-key"},
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+
+	// Click each campaign link twice
+	var campaignResp model.CampaignStatsResponse
+	resp, _ := handler.GetCampaignStats(context.Background(), req)
+	json.Unmarshal([]byte(resp.Body), &campaignResp)
+	for _, link := range campaignResp.Links {
+		mockDB.IncrementClickCount(context.Background(), link.ShortCode)
+		mockDB.IncrementClickCount(context.Background(), link.ShortCode)
+	}
+
+	resp, err := handler.GetCampaignStats(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetCampaignStats returned an error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200, got %d", resp.StatusCode)
+	}
+	campaignResp = model.CampaignStatsResponse{}
+	json.Unmarshal([]byte(resp.Body), &campaignResp)
+	if campaignResp.LinkCount != 2 || campaignResp.ClickCount != 4 {
+		t.Errorf("Expected 2 links and 4 clicks, got %+v", campaignResp)
+	}
+
+	// Test another owner's private link in the campaign is not exposed
+	if strings.Contains(resp.Body, "privateCode12345") {
+		t.Errorf("Expected another owner's private link to be hidden, got %s", resp.Body)
+	}
+	adminReq := req
+	adminReq.Headers = map[string]string{"x-api-key": "secret"}
+	resp, _ = handler.GetCampaignStats(context.Background(), adminReq)
+	var adminResp model.CampaignStatsResponse
+	json.Unmarshal([]byte(resp.Body), &adminResp)
+	if adminResp.LinkCount != 3 || adminResp.ClickCount != 9 {
+		t.Errorf("Expected the admin to see all 3 links and 9 clicks, got %+v", adminResp)
+	}
+	anonReq := req
+	anonReq.Headers = nil
+	if resp, _ = handler.GetCampaignStats(context.Background(), anonReq); resp.StatusCode != 404 || strings.Contains(resp.Body, "privateCode12345") {
+		t.Errorf("Expected 404 for a caller who may see no links, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// UTM parameters are merged into the destination
+	destinations := map[string]bool{}
+	for _, link := range campaignResp.Links {
+		destinations[link.OriginalURL] = true
+	}
+	if !destinations["https://example.com/a?ref=x&utm_campaign=spring+sale&utm_medium=email&utm_source=newsletter"] ||
+		!destinations["https://example.com/b?utm_source=twitter"] {
+		t.Errorf("Unexpected destinations: %v", destinations)
+	}
+
+	// Unknown campaign
+	req.RawPath = "/campaigns/unknown/stats"
+	resp, _ = handler.GetCampaignStats(context.Background(), req)
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected status code 404 for unknown campaign, got %d", resp.StatusCode)
+	}
+
+	// Invalid path
+	req.RawPath = "/campaigns//stats"
+	resp, _ = handler.GetCampaignStats(context.Background(), req)
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 for missing campaign, got %d", resp.StatusCode)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	// Create a link with details
+	resp, err := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "title": "Example", "tags": ["Docs", "launch", "docs"], "metadata": {"team": "web"}}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	})
+	if err != nil || resp.StatusCode != 201 {
+		t.Fatalf("ShortenURL failed: %d %v", resp.StatusCode, err)
+	}
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := shortenResp.ShortURL[strings.LastIndex(shortenResp.ShortURL, "/")+1:]
+
+	// Tags are normalized when stored
+	urlItem, _ := mockDB.GetURL(context.Background(), code)
+	if strings.Join(urlItem.Tags, ",") != "docs,launch" || urlItem.Metadata["team"] != "web" {
+		t.Errorf("Unexpected stored details: %+v", urlItem)
+	}
+
+	// Test search by tag
+	listReq := events.LambdaFunctionURLRequest{
+		RawPath:               "/links",
+		QueryStringParameters: map[string]string{"tag": "DOCS"},
+		Headers:               map[string]string{"x-api-key": "secret"},
+	}
+	resp, _ = handler.ListLinks(context.Background(), listReq)
+	var listResp model.LinkListResponse
+	json.Unmarshal([]byte(resp.Body), &listResp)
+	if resp.StatusCode != 200 || len(listResp.Links) != 1 || listResp.Links[0].Title != "Example" {
+		t.Errorf("Expected one link tagged docs, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test update of tags and description
+	patchReq := events.LambdaFunctionURLRequest{
+		RawPath: "/links/" + code,
+		Body:    `{"description": "Landing page", "tags": ["launch", "promo"]}`,
+		Headers: map[string]string{"x-api-key": "secret"},
+	}
+	resp, _ = handler.UpdateLink(context.Background(), patchReq)
+	var linkResp model.LinkResponse
+	json.Unmarshal([]byte(resp.Body), &linkResp)
+	if resp.StatusCode != 200 || linkResp.Description != "Landing page" || linkResp.Title != "Example" {
+		t.Errorf("Unexpected update response: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// The tag index follows the update
+	resp, _ = handler.ListLinks(context.Background(), listReq)
+	listResp = model.LinkListResponse{}
+	json.Unmarshal([]byte(resp.Body), &listResp)
+	if len(listResp.Links) != 0 {
+		t.Errorf("Expected no links tagged docs after update, got %s", resp.Body)
+	}
+	listReq.QueryStringParameters["tag"] = "promo"
+	resp, _ = handler.ListLinks(context.Background(), listReq)
+	listResp = model.LinkListResponse{}
+	json.Unmarshal([]byte(resp.Body), &listResp)
+	if len(listResp.Links) != 1 || listResp.Links[0].ShortCode != code {
+		t.Errorf("Expected the link tagged promo, got %s", resp.Body)
+	}
+
+	// Test get link
+	resp, _ = handler.GetLink(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/links/" + code,
+		Headers: map[string]string{"x-api-key": "secret"},
+	})
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, `"promo"`) {
+		t.Errorf("Unexpected get link response: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test size limits
+	patchReq.Body = `{"title": "` + strings.Repeat("x", maxTitleLength+1) + `"}`
+	resp, _ = handler.UpdateLink(context.Background(), patchReq)
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 for a long title, got %d", resp.StatusCode)
+	}
+	patchReq.Body = `{"tags": ["has space"]}`
+	resp, _ = handler.UpdateLink(context.Background(), patchReq)
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 for an invalid tag, got %d", resp.StatusCode)
+	}
+
+	// Test unknown link
+	patchReq.RawPath = "/links/missing"
+	patchReq.Body = `{"title": "x"}`
+	resp, _ = handler.UpdateLink(context.Background(), patchReq)
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected status code 404 for unknown link, got %d", resp.StatusCode)
+	}
+
+	// Test wrong API key
+	listReq.Headers["x-api-key"] = "wrong"
+	resp, _ = handler.ListLinks(context.Background(), listReq)
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected status code 401 for a wrong API key, got %d", resp.StatusCode)
+	}
+
+	// Test management API disabled
+	t.Setenv("ADMIN_API_KEY", "")
+	resp, _ = handler.ListLinks(context.Background(), listReq)
+	if resp.StatusCode != 403 {
+		t.Errorf("Expected status code 403 without ADMIN_API_KEY, got %d", resp.StatusCode)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "abc12", OriginalURL: "https://example.com"})
+
+	req := events.LambdaFunctionURLRequest{
+		RawPath: "/abc12/qr",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+		},
+	}
+
+	// Test default PNG
+	resp, err := handler.QRCode(context.Background(), req)
+	if err != nil {
+		t.Fatalf("QRCode returned an error: %v", err)
+	}
+	if resp.StatusCode != 200 || resp.Headers["Content-Type"] != "image/png" || !resp.IsBase64Encoded {
+		t.Fatalf("Expected a base64 PNG, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	if !strings.HasPrefix(resp.Headers["Cache-Control"], "public") || resp.Headers["ETag"] == "" {
+		t.Errorf("Expected a cacheable response, got %v", resp.Headers)
+	}
+
+	// Test conditional request
+	req.Headers = map[string]string{"if-none-match": resp.Headers["ETag"]}
+	resp, _ = handler.QRCode(context.Background(), req)
+	if resp.StatusCode != 304 {
+		t.Errorf("Expected status code 304 for a matching ETag, got %d", resp.StatusCode)
+	}
+	req.Headers = nil
+
+	// Test SVG
+	req.QueryStringParameters = map[string]string{"format": "svg", "size": "128", "ecc": "H"}
+	resp, _ = handler.QRCode(context.Background(), req)
+	if resp.StatusCode != 200 || resp.Headers["Content-Type"] != "image/svg+xml" || !strings.HasPrefix(resp.Body, "<svg") {
+		t.Errorf("Expected an SVG, got %d %v", resp.StatusCode, resp.Headers)
+	}
+
+	// Test invalid options
+	req.QueryStringParameters = map[string]string{"size": "5000"}
+	resp, _ = handler.QRCode(context.Background(), req)
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 for an invalid size, got %d", resp.StatusCode)
+	}
+
+	// Test unknown code
+	req.QueryStringParameters = nil
+	req.RawPath = "/nope1/qr"
+	resp, _ = handler.QRCode(context.Background(), req)
+	if resp.StatusCode != 404 {
+		t.Errorf("Expected status code 404 for unknown code, got %d", resp.StatusCode)
+	}
+}
+
+func TestLinkPreview(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	mockDB.CreateURL(context.Background(), &model.URLItem{
+		ShortCode:    "prev1",
+		OriginalURL:  "https://example.com/page",
+		CreatedAt:    "2024-03-05T10:00:00Z",
+		Title:        "Spring <launch>",
+		ForwardQuery: ForwardQueryMerge,
+	})
+
+	req := events.LambdaFunctionURLRequest{
+		RawPath: "/prev1+",
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	}
+
+	// Test preview with a trailing +
+	resp, err := handler.RedirectURL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("RedirectURL returned an error: %v", err)
+	}
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Headers["Content-Type"], "text/html") {
+		t.Fatalf("Expected an HTML preview, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	for _, want := range []string{"https://example.com/page", "Spring &lt;launch&gt;", "March 5, 2024", `href="/prev1"`} {
+		if !strings.Contains(resp.Body, want) {
+			t.Errorf("Expected preview to contain %q, got %s", want, resp.Body)
+		}
+	}
+	urlItem, _ := mockDB.GetURL(context.Background(), "prev1")
+	if urlItem.ClickCount != 0 {
+		t.Errorf("Expected a preview not to count a click, got %d", urlItem.ClickCount)
+	}
+
+	// Test preview with a query parameter; other parameters are kept
+	req.RawPath = "/prev1"
+	req.RawQueryString = "preview=1&ref=mail"
+	resp, _ = handler.RedirectURL(context.Background(), req)
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, "https://example.com/page?ref=mail") ||
+		!strings.Contains(resp.Body, `href="/prev1?ref=mail"`) {
+		t.Errorf("Expected a preview with forwarded query, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test interstitial mode
+	urlItem.Interstitial = true
+	mockDB.UpdateURL(context.Background(), urlItem)
+	t.Setenv("BRAND_NAME", "Acme Links")
+	req.RawQueryString = ""
+	resp, _ = handler.RedirectURL(context.Background(), req)
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, "You are leaving Acme Links") ||
+		!strings.Contains(resp.Body, `href="https://example.com/page"`) {
+		t.Errorf("Expected an interstitial page, got %d %s", resp.StatusCode, resp.Body)
+	}
+	urlItem, _ = mockDB.GetURL(context.Background(), "prev1")
+	if urlItem.ClickCount != 1 {
+		t.Errorf("Expected the interstitial to count a click, got %d", urlItem.ClickCount)
+	}
+
+	// Test preview of a password protected link still asks for the password
+	urlItem.PasswordHash, _ = utils.HashPassword("secret")
+	mockDB.ReplaceURL(context.Background(), urlItem)
+	req.RawPath = "/prev1+"
+	resp, _ = handler.RedirectURL(context.Background(), req)
+	if !strings.Contains(resp.Body, "password protected") || strings.Contains(resp.Body, "example.com") {
+		t.Errorf("Expected the password form, got %s", resp.Body)
+	}
+}
+
+func TestUnfurl(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com/post", "title": "Internal title", "og_title": "Big \"news\"", "og_image": "https://cdn.example.com/card.png"}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	})
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+
+	req := events.LambdaFunctionURLRequest{
+		RawPath: "/" + code,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+			HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{
+				UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+			},
+		},
+	}
+
+	// Test unfurler gets Open Graph metadata
+	resp, err := handler.RedirectURL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("RedirectURL returned an error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status code 200 for an unfurler, got %d", resp.StatusCode)
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="Big &#34;news&#34;">`,
+		`<meta property="og:image" content="https://cdn.example.com/card.png">`,
+		`<meta property="og:url" content="https://sho.rt/` + code + `">`,
+	} {
+		if !strings.Contains(resp.Body, want) {
+			t.Errorf("Expected unfurl document to contain %s, got %s", want, resp.Body)
+		}
+	}
+	urlItem, _ := mockDB.GetURL(context.Background(), code)
+	if urlItem.ClickCount != 0 {
+		t.Errorf("Expected an unfurl not to count a click, got %d", urlItem.ClickCount)
+	}
+
+	// Test human visitors are still redirected
+	req.RequestContext.HTTP.UserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15"
+	resp, _ = handler.RedirectURL(context.Background(), req)
+	if resp.StatusCode != 302 || resp.Headers["Location"] != "https://example.com/post" {
+		t.Errorf("Expected a redirect for a browser, got %d %v", resp.StatusCode, resp.Headers)
+	}
+
+	// Test invalid og_image
+	resp, _ = handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "og_image": "javascript:alert(1)"}`,
+	})
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected status code 400 for an invalid og_image, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeviceRouting(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com/app", "rules": [
+			{"name": "app-store", "os": "iOS", "url": "https://apps.apple.com/app/id123"},
+			{"os": "android", "device": "mobile", "url": "https://play.google.com/store/apps/details?id=com.example"}
+		]}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("ShortenURL failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+
+	visits := []struct {
+		userAgent string
+		location  string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 Version/17.4 Mobile/15E148 Safari/604.1", "https://apps.apple.com/app/id123"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/123.0.0.0 Mobile Safari/537.36", "https://play.google.com/store/apps/details?id=com.example"},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 Chrome/117.0.0.0 Safari/537.36", "https://example.com/app"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/123.0.0.0 Safari/537.36", "https://example.com/app"},
+	}
+	for _, visit := range visits {
+		resp, _ := handler.RedirectURL(context.Background(), events.LambdaFunctionURLRequest{
+			RawPath: "/" + code,
+			Headers: map[string]string{"user-agent": visit.userAgent},
+		})
+		if resp.StatusCode != 302 || resp.Headers["Location"] != visit.location {
+			t.Errorf("Expected redirect to %s for %q, got %d %s", visit.location, visit.userAgent, resp.StatusCode, resp.Headers["Location"])
+		}
+	}
+
+	// Test stats count clicks per rule
+	resp, _ = handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/stats/" + code,
+		Headers: map[string]string{"x-api-key": "secret"},
+	})
+	var stats model.StatsResponse
+	json.Unmarshal([]byte(resp.Body), &stats)
+	if stats.ClickCount != 4 || stats.RuleClicks["app-store"] != 1 || stats.RuleClicks["rule2"] != 1 || stats.RuleClicks["default"] != 2 {
+		t.Errorf("Unexpected rule clicks: %d %v", stats.ClickCount, stats.RuleClicks)
+	}
+
+	// Test invalid rules
+	for _, body := range []string{
+		`{"url": "https://example.com", "rules": [{"url": "https://example.com/x"}]}`,
+		`{"url": "https://example.com", "rules": [{"os": "beos", "url": "https://example.com/x"}]}`,
+		`{"url": "https://example.com", "rules": [{"os": "ios", "url": "javascript://alert(1)"}]}`,
+		`{"url": "https://example.com", "rules": [{"name": "a", "os": "ios", "url": "https://a.example"}, {"name": "a", "os": "android", "url": "https://b.example"}]}`,
+	} {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{Body: body})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestGeoRouting(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	geoDB, err := geoip.Parse(strings.NewReader("1.0.0.0,1.0.0.255,AU\n2.16.0.0,2.16.255.255,FR\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse GeoIP data: %v", err)
+	}
+	handler.SetGeoDatabase(geoDB)
+
+	resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com/shop", "geo": {"us": "https://example.com/us", "FR": "https://example.fr/shop"}}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("ShortenURL failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+
+	visits := []struct {
+		header   string
+		sourceIP string
+		location string
+	}{
+		// CloudFront's header wins over the source IP
+		{"US", "2.16.0.1", "https://example.com/us"},
+		{"", "2.16.0.1", "https://example.fr/shop"},
+		{"", "1.0.0.1", "https://example.com/shop"},
+		{"", "192.0.2.1", "https://example.com/shop"},
+	}
+	for _, visit := range visits {
+		req := events.LambdaFunctionURLRequest{RawPath: "/" + code}
+		req.RequestContext.HTTP.SourceIP = visit.sourceIP
+		if visit.header != "" {
+			req.Headers = map[string]string{"cloudfront-viewer-country": visit.header}
+		}
+		resp, _ := handler.RedirectURL(context.Background(), req)
+		if resp.StatusCode != 302 || resp.Headers["Location"] != visit.location {
+			t.Errorf("Expected redirect to %s for %q/%s, got %d %s", visit.location, visit.header, visit.sourceIP, resp.StatusCode, resp.Headers["Location"])
+		}
+	}
+
+	// Test stats count clicks per country, leaving out unknown visitors
+	resp, _ = handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/stats/" + code,
+		Headers: map[string]string{"x-api-key": "secret"},
+	})
+	var stats model.StatsResponse
+	json.Unmarshal([]byte(resp.Body), &stats)
+	if stats.ClickCount != 4 || stats.Geo["US"] != "https://example.com/us" ||
+		stats.CountryClicks["US"] != 1 || stats.CountryClicks["FR"] != 1 || stats.CountryClicks["AU"] != 1 || len(stats.CountryClicks) != 3 {
+		t.Errorf("Unexpected country clicks: %d %v", stats.ClickCount, stats.CountryClicks)
+	}
+
+	// Test invalid geo rules
+	for _, body := range []string{
+		`{"url": "https://example.com", "geo": {"USA": "https://example.com/us"}}`,
+		`{"url": "https://example.com", "geo": {"US": "not a url"}}`,
+		`{"url": "https://example.com", "geo": {"us": "https://a.example", "US": "https://b.example"}}`,
+	} {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{Body: body})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	shorten := func(body string) string {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body: body,
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "sho.rt",
+			},
+		})
+		if resp.StatusCode != 201 {
+			t.Fatalf("ShortenURL failed: %d %s", resp.StatusCode, resp.Body)
+		}
+		var shortenResp model.ShortenResponse
+		json.Unmarshal([]byte(resp.Body), &shortenResp)
+		return strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+	}
+	visit := func(code, sourceIP string) string {
+		req := events.LambdaFunctionURLRequest{RawPath: "/" + code}
+		req.RequestContext.HTTP.SourceIP = sourceIP
+		resp, _ := handler.RedirectURL(context.Background(), req)
+		if resp.StatusCode != 302 {
+			t.Fatalf("Expected status code 302, got %d %s", resp.StatusCode, resp.Body)
+		}
+		return resp.Headers["Location"]
+	}
+
+	// Test weighted random choice; a zero weight pauses a variant
+	code := shorten(`{"url": "https://example.com", "variants": [
+		{"url": "https://example.com/a", "weight": 1},
+		{"url": "https://example.com/b", "weight": 0}
+	]}`)
+	for i := 0; i < 5; i++ {
+		if location := visit(code, "192.0.2.1"); location != "https://example.com/a" {
+			t.Errorf("Expected the weighted variant, got %s", location)
+		}
+	}
+
+	// Test sticky variants give each visitor the same destination and
+	// split visitors between them
+	code = shorten(`{"url": "https://example.com", "sticky": true, "variants": [
+		{"name": "control", "url": "https://example.com/a", "weight": 50},
+		{"name": "new", "url": "https://example.com/b", "weight": 50}
+	]}`)
+	seen := map[string]bool{}
+	for i := 0; i < 40; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		first := visit(code, ip)
+		if second := visit(code, ip); second != first {
+			t.Errorf("Expected sticky variant for %s, got %s then %s", ip, first, second)
+		}
+		seen[first] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Expected visitors to be split between both variants, got %v", seen)
+	}
+
+	// Test stats report the split
+	resp, _ := handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/stats/" + code,
+		Headers: map[string]string{"x-api-key": "secret"},
+	})
+	var stats model.StatsResponse
+	json.Unmarshal([]byte(resp.Body), &stats)
+	if !stats.Sticky || len(stats.Variants) != 2 || stats.Variants[0].Name != "control" || stats.Variants[1].Name != "new" {
+		t.Fatalf("Unexpected variant stats: %+v", stats.Variants)
+	}
+	control, treatment := stats.Variants[0], stats.Variants[1]
+	if control.Clicks+treatment.Clicks != 80 || control.Share+treatment.Share < 99.9 || control.Share+treatment.Share > 100.1 {
+		t.Errorf("Unexpected variant split: %+v", stats.Variants)
+	}
+
+	// Test invalid variants
+	for _, body := range []string{
+		`{"url": "https://example.com", "variants": [{"url": "https://example.com/a", "weight": 1}]}`,
+		`{"url": "https://example.com", "variants": [{"url": "https://example.com/a", "weight": 0}, {"url": "https://example.com/b", "weight": 0}]}`,
+		`{"url": "https://example.com", "variants": [{"url": "https://example.com/a", "weight": -1}, {"url": "https://example.com/b", "weight": 1}]}`,
+		`{"url": "https://example.com", "variants": [{"url": "nope", "weight": 1}, {"url": "https://example.com/b", "weight": 1}]}`,
+		`{"url": "https://example.com", "variants": [{"name": "x", "url": "https://example.com/a", "weight": 1}, {"name": "x", "url": "https://example.com/b", "weight": 1}]}`,
+	} {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{Body: body})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	// The event runs 09:00-17:00 New York time (UTC-5 in November)
+	resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com/event", "schedule": {"timezone": "America/New_York", "windows": [
+			{"from": "2026-11-02T17:00", "url": "https://example.com/recording"},
+			{"to": "2026-11-02T09:00", "url": "https://example.com/register"},
+			{"from": "2026-11-02T09:00", "to": "2026-11-02T17:00", "url": "https://example.com/live"}
+		]}}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("ShortenURL failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+
+	visits := []struct {
+		now      string
+		location string
+	}{
+		{"2026-11-02T13:59:59Z", "https://example.com/register"},
+		{"2026-11-02T14:00:00Z", "https://example.com/live"},
+		{"2026-11-02T21:59:59Z", "https://example.com/live"},
+		{"2026-11-02T22:00:00Z", "https://example.com/recording"},
+	}
+	for _, visit := range visits {
+		now, _ := time.Parse(time.RFC3339, visit.now)
+		handler.SetClock(func() time.Time { return now })
+		resp, _ := handler.RedirectURL(context.Background(), events.LambdaFunctionURLRequest{RawPath: "/" + code})
+		if resp.StatusCode != 302 || resp.Headers["Location"] != visit.location {
+			t.Errorf("Expected redirect to %s at %s, got %d %s", visit.location, visit.now, resp.StatusCode, resp.Headers["Location"])
+		}
+	}
+
+	// Test stats show the windows in time order and in the link's time zone
+	resp, _ = handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/stats/" + code,
+		Headers: map[string]string{"x-api-key": "secret"},
+	})
+	var stats model.StatsResponse
+	json.Unmarshal([]byte(resp.Body), &stats)
+	if stats.Schedule == nil || stats.Schedule.Timezone != "America/New_York" || len(stats.Schedule.Windows) != 3 ||
+		stats.Schedule.Windows[1].From != "2026-11-02T09:00:00-05:00" || stats.Schedule.Windows[1].URL != "https://example.com/live" {
+		t.Errorf("Unexpected schedule: %+v", stats.Schedule)
+	}
+
+	// Test invalid schedules
+	for _, body := range []string{
+		`{"url": "https://example.com", "schedule": {"timezone": "Mars/Olympus", "windows": [{"from": "2026-11-02T09:00", "url": "https://example.com/a"}]}}`,
+		`{"url": "https://example.com", "schedule": {"windows": [{"from": "next tuesday", "url": "https://example.com/a"}]}}`,
+		`{"url": "https://example.com", "schedule": {"windows": [{"url": "https://example.com/a"}]}}`,
+		`{"url": "https://example.com", "schedule": {"windows": [{"from": "2026-11-02T10:00", "to": "2026-11-02T09:00", "url": "https://example.com/a"}]}}`,
+		`{"url": "https://example.com", "schedule": {"windows": [
+			{"from": "2026-11-02T09:00", "to": "2026-11-02T12:00", "url": "https://example.com/a"},
+			{"from": "2026-11-02T11:00", "to": "2026-11-02T13:00", "url": "https://example.com/b"}
+		]}}`,
+		`{"url": "https://example.com", "schedule": {"windows": [
+			{"from": "2026-11-02T09:00", "url": "https://example.com/a"},
+			{"from": "2026-11-03T09:00", "to": "2026-11-03T12:00", "url": "https://example.com/b"}
+		]}}`,
+	} {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{Body: body})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, got %d %s", body, resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	// Setup mock database and a local receiver
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	t.Setenv("ADMIN_API_KEY", "secret")
+	receiver := webhooktest.NewReceiver("whsec_0123456789abcdef")
+	defer receiver.Close()
+	dispatcher := webhook.NewDispatcher(mockDB, webhook.DefaultOptions())
+	handler.SetWebhooks(dispatcher)
+	ctx := context.Background()
+	admin := map[string]string{"x-api-key": "secret"}
+
+	// Test subscribing
+	resp, _ := handler.CreateWebhook(ctx, events.LambdaFunctionURLRequest{
+		RawPath: "/webhooks",
+		Headers: admin,
+		Body:    fmt.Sprintf(`{"url": %q, "secret": "whsec_0123456789abcdef"}`, receiver.URL),
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("CreateWebhook failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	var subscription model.Webhook
+	json.Unmarshal([]byte(resp.Body), &subscription)
+	if !strings.HasPrefix(subscription.ID, "wh_") || subscription.Secret != "whsec_0123456789abcdef" {
+		t.Errorf("Unexpected webhook: %+v", subscription)
+	}
+
+	for _, body := range []string{
+		`{"url": "ftp://example.com"}`,
+		`{"url": "https://example.com", "events": ["link.visited"]}`,
+		`{"url": "https://example.com", "secret": "short"}`,
+	} {
+		resp, _ := handler.CreateWebhook(ctx, events.LambdaFunctionURLRequest{RawPath: "/webhooks", Headers: admin, Body: body})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected status code 400 for %s, got %d", body, resp.StatusCode)
+		}
+	}
+
+	// Test listing hides the secret
+	resp, _ = handler.ListWebhooks(ctx, events.LambdaFunctionURLRequest{RawPath: "/webhooks", Headers: admin})
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, subscription.ID) || strings.Contains(resp.Body, "whsec_") {
+		t.Errorf("Unexpected webhook list: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Create, click, expire and delete a link
+	resp, _ = handler.ShortenURL(ctx, events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com", "expires_at": "2030-01-01T00:00:00Z"}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	})
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+
+	handler.RedirectURL(ctx, events.LambdaFunctionURLRequest{RawPath: "/" + code})
+	handler.SetClock(func() time.Time { return time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC) })
+	for i := 0; i < 2; i++ {
+		if resp, _ := handler.RedirectURL(ctx, events.LambdaFunctionURLRequest{RawPath: "/" + code}); resp.StatusCode != 410 {
+			t.Errorf("Expected status code 410 for an expired link, got %d", resp.StatusCode)
+		}
+	}
+	// The stream processor sends link.expired once the visit marks the link
+	if urlItem, _ := mockDB.GetURL(ctx, code); urlItem == nil || !urlItem.ExpiryNotified {
+		t.Errorf("Expected the expired link to be marked, got %+v", urlItem)
+	}
+	resp, _ = handler.DeleteLink(ctx, events.LambdaFunctionURLRequest{RawPath: "/links/" + code, Headers: admin})
+	if resp.StatusCode != 204 {
+		t.Errorf("Expected status code 204 from DeleteLink, got %d %s", resp.StatusCode, resp.Body)
+	}
+	if err := dispatcher.Flush(ctx); err != nil {
+		t.Fatalf("Flush returned an error: %v", err)
+	}
+
+	// Delivery is asynchronous, so events may arrive in any order; each is
+	// sent once
+	received := map[string]webhook.Event{}
+	for _, event := range receiver.Events() {
+		if _, duplicate := received[event.Type]; duplicate {
+			t.Errorf("Received %s more than once", event.Type)
+		}
+		received[event.Type] = event
+	}
+	for _, eventType := range []string{webhook.EventLinkCreated, webhook.EventLinkClicked, webhook.EventLinkDeleted} {
+		if received[eventType].Link.ShortCode != code {
+			t.Errorf("Expected a %s event for %s, got %+v", eventType, code, received[eventType])
+		}
+	}
+	if click := received[webhook.EventLinkClicked].Click; click == nil || click.Destination != "https://example.com" {
+		t.Errorf("Unexpected click details: %+v", click)
+	}
+
+	// Test the delivery log
+	resp, _ = handler.ListWebhookDeliveries(ctx, events.LambdaFunctionURLRequest{
+		RawPath: "/webhooks/" + subscription.ID + "/deliveries",
+		Headers: admin,
+	})
+	var deliveries model.WebhookDeliveryListResponse
+	json.Unmarshal([]byte(resp.Body), &deliveries)
+	if resp.StatusCode != 200 || len(deliveries.Deliveries) != 3 || !deliveries.Deliveries[0].Success {
+		t.Errorf("Unexpected delivery log: %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test unsubscribing
+	deleteReq := events.LambdaFunctionURLRequest{RawPath: "/webhooks/" + subscription.ID, Headers: admin}
+	if resp, _ := handler.DeleteWebhook(ctx, deleteReq); resp.StatusCode != 204 {
+		t.Errorf("Expected status code 204 from DeleteWebhook, got %d", resp.StatusCode)
+	}
+	if resp, _ := handler.DeleteWebhook(ctx, deleteReq); resp.StatusCode != 404 {
+		t.Errorf("Expected status code 404 for a deleted webhook, got %d", resp.StatusCode)
+	}
+}
+
+func TestClickEvents(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	publisher := eventbus.NewMemoryPublisher()
+	handler.SetEventPublisher(publisher)
+	handler.SetClock(func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) })
+
+	resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+		Body: `{"url": "https://example.com/app", "campaign": "spring", "rules": [
+			{"name": "app-store", "os": "ios", "url": "https://apps.apple.com/app/id123"}
+		]}`,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			DomainName: "sho.rt",
+		},
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("ShortenURL failed: %d %s", resp.StatusCode, resp.Body)
+	}
+	var shortenResp model.ShortenResponse
+	json.Unmarshal([]byte(resp.Body), &shortenResp)
+	code := strings.TrimPrefix(shortenResp.ShortURL, "https://sho.rt/")
+
+	// Test a redirect publishes one event describing the click
+	userAgent := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 Version/17.4 Mobile/15E148 Safari/604.1"
+	req := events.LambdaFunctionURLRequest{
+		RawPath: "/" + code,
+		Headers: map[string]string{
+			"user-agent":                userAgent,
+			"referer":                   "https://news.example/post",
+			"cloudfront-viewer-country": "NL",
+		},
+	}
+	req.RequestContext.RequestID = "req-1"
+	resp, _ = handler.RedirectURL(context.Background(), req)
+	if resp.StatusCode != 302 {
+		t.Fatalf("Expected status code 302, got %d", resp.StatusCode)
+	}
+
+	published := publisher.Events()
+	if len(published) != 1 {
+		t.Fatalf("Expected 1 published event, got %d", len(published))
+	}
+	event := published[0]
+	if !strings.HasPrefix(event.ID, "clk_") {
+		t.Errorf("Expected a clk_ event ID, got %s", event.ID)
+	}
+	event.ID = ""
+	expected := eventbus.ClickEvent{
+		SchemaVersion: 1,
+		Type:          "link.clicked",
+		Timestamp:     "2026-03-01T12:00:00Z",
+		RequestID:     "req-1",
+		ShortCode:     code,
+		Campaign:      "spring",
+		Destination:   "https://apps.apple.com/app/id123",
+		Rule:          "app-store",
+		Country:       "NL",
+		OS:            "ios",
+		Device:        "mobile",
+		Browser:       "safari",
+		Referrer:      "https://news.example/post",
+		UserAgent:     userAgent,
+	}
+	if event != expected {
+		t.Errorf("Unexpected click event:\n got  %+v\n want %+v", event, expected)
+	}
+
+	// Test previews and unfurls are not clicks and publish nothing
+	req.RawPath = "/" + code + "+"
+	handler.RedirectURL(context.Background(), req)
+	req.RawPath = "/" + code
+	req.Headers = map[string]string{"user-agent": "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}
+	handler.RedirectURL(context.Background(), req)
+	if len(publisher.Events()) != 1 {
+		t.Errorf("Expected previews and unfurls not to publish, got %d events", len(publisher.Events()))
+	}
+
+	// Test an unknown code publishes nothing
+	handler.RedirectURL(context.Background(), events.LambdaFunctionURLRequest{RawPath: "/nope1"})
+	if len(publisher.Events()) != 1 {
+		t.Errorf("Expected a missing link not to publish, got %d events", len(publisher.Events()))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Setenv("OWNER_API_KEYS", "team-a=team-key")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	// Test requests pass untouched without a limiter
+	decision, _, allowed := handler.CheckRateLimit(context.Background(), events.LambdaFunctionURLRequest{}, ratelimit.ClassShorten)
+	if !allowed || decision != nil {
+		t.Fatalf("Expected no rate limiting without a limiter")
+	}
+
+	now := time.Unix(1700000000, 0)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		ratelimit.ClassShorten: {Requests: 2, Period: time.Minute},
+	})
+	limiter.SetClock(func() time.Time { return now })
+	handler.SetRateLimiter(limiter)
+
+	req := events.LambdaFunctionURLRequest{RawPath: "/shorten"}
+	req.RequestContext.HTTP.SourceIP = "192.0.2.1"
+
+	// Test allowed responses carry the RateLimit headers
+	decision, _, allowed = handler.CheckRateLimit(context.Background(), req, ratelimit.ClassShorten)
+	resp := WithRateLimitHeaders(jsonResponse(201, map[string]string{}), decision)
+	if !allowed || resp.Headers["RateLimit-Limit"] != "2" || resp.Headers["RateLimit-Remaining"] != "1" ||
+		resp.Headers["RateLimit-Reset"] != "30" || resp.Headers["RateLimit-Policy"] != "2;w=60" ||
+		resp.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected headers on an allowed response: %v", resp.Headers)
+	}
+
+	// Test the third request from the same IP is refused
+	handler.CheckRateLimit(context.Background(), req, ratelimit.ClassShorten)
+	_, resp, allowed = handler.CheckRateLimit(context.Background(), req, ratelimit.ClassShorten)
+	if allowed || resp.StatusCode != 429 || resp.Headers["Retry-After"] != "30" || resp.Headers["RateLimit-Remaining"] != "0" ||
+		!strings.Contains(resp.Body, `"rate_limited"`) {
+		t.Errorf("Expected 429 with Retry-After, got %d %v %s", resp.StatusCode, resp.Headers, resp.Body)
+	}
+
+	// Test a known API key gets its own bucket, even from the same IP, but
+	// a made-up one does not
+	req.Headers = map[string]string{"x-api-key": "made-up-key"}
+	if _, _, allowed = handler.CheckRateLimit(context.Background(), req, ratelimit.ClassShorten); allowed {
+		t.Errorf("Expected an unknown API key to share the IP's bucket")
+	}
+	req.Headers = map[string]string{"x-api-key": "team-key"}
+	if _, _, allowed = handler.CheckRateLimit(context.Background(), req, ratelimit.ClassShorten); !allowed {
+		t.Errorf("Expected a request with an API key to use its own bucket")
+	}
+
+	// Test classes without a limit are not limited
+	if decision, _, allowed = handler.CheckRateLimit(context.Background(), req, ratelimit.ClassRedirect); !allowed || decision != nil {
+		t.Errorf("Expected the redirect class not to be limited")
+	}
+}
+
+func TestEnumerationProtection(t *testing.T) {
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "abc12", OriginalURL: "https://example.com"})
+
+	now := time.Unix(1700000000, 0)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		ratelimit.ClassNotFound: {Requests: 6, Period: time.Hour, Burst: 6},
+	})
+	limiter.SetClock(func() time.Time { return now })
+	handler.SetRateLimiter(limiter)
+
+	lookup := func(path, ip string) events.LambdaFunctionURLResponse {
+		req := events.LambdaFunctionURLRequest{RawPath: path}
+		req.RequestContext.HTTP.SourceIP = ip
+		resp, _ := handler.RedirectURL(context.Background(), req)
+		return resp
+	}
+
+	// Test misses are answered normally until the budget is used
+	for i := 0; i < 6; i++ {
+		if resp := lookup(fmt.Sprintf("/miss%d", i), "192.0.2.1"); resp.StatusCode != 404 {
+			t.Fatalf("Expected 404 for miss %d, got %d", i+1, resp.StatusCode)
+		}
+	}
+
+	// Test the client is blocked once its budget is used, even for codes that
+	// exist, while other clients are not
+	resp := lookup("/abc12", "192.0.2.1")
+	if resp.StatusCode != 429 || resp.Headers["Retry-After"] != "600" || !strings.Contains(resp.Body, `"too_many_not_found"`) {
+		t.Errorf("Expected 429 after too many misses, got %d %v %s", resp.StatusCode, resp.Headers, resp.Body)
+	}
+	req := events.LambdaFunctionURLRequest{RawPath: "/stats/abc12"}
+	req.RequestContext.HTTP.SourceIP = "192.0.2.1"
+	if resp, _ = handler.GetURLStats(context.Background(), req); resp.StatusCode != 429 {
+		t.Errorf("Expected stats lookups to be blocked too, got %d", resp.StatusCode)
+	}
+	if resp = lookup("/abc12", "192.0.2.2"); resp.StatusCode != 302 {
+		t.Errorf("Expected another client to be redirected, got %d", resp.StatusCode)
+	}
+
+	// Test the block lifts as the budget refills
+	now = now.Add(10 * time.Minute)
+	if resp = lookup("/abc12", "192.0.2.1"); resp.StatusCode != 302 {
+		t.Errorf("Expected the client to be redirected after waiting, got %d", resp.StatusCode)
+	}
+}
+
+func TestStatsAccess(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+	t.Setenv("OWNER_API_KEYS", "team-a=team-a-key")
+	t.Setenv("PRIVATE_CODE_LENGTH", "20")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+
+	shorten := func(body string, headers map[string]string) (events.LambdaFunctionURLResponse, model.ShortenResponse) {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body:    body,
+			Headers: headers,
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+			},
+		})
+		var shortenResp model.ShortenResponse
+		json.Unmarshal([]byte(resp.Body), &shortenResp)
+		return resp, shortenResp
+	}
+	stats := func(code string, headers map[string]string) int {
+		resp, _ := handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{RawPath: "/stats/" + code, Headers: headers})
+		return resp.StatusCode
+	}
+
+	// Test an owner's key makes them the owner, and a made-up key is refused
+	_, created := shorten(`{"url": "https://example.com"}`, map[string]string{"x-api-key": "team-a-key"})
+	code := created.ShortURL[strings.LastIndex(created.ShortURL, "/")+1:]
+	if item, _ := mockDB.GetURL(context.Background(), code); item == nil || item.Owner != "team-a" {
+		t.Fatalf("Expected the link to belong to team-a, got %+v", item)
+	}
+	if resp, _ := shorten(`{"url": "https://example.com"}`, map[string]string{"x-api-key": "made-up-key"}); resp.StatusCode != 401 {
+		t.Errorf("Expected 401 for an unknown API key, got %d", resp.StatusCode)
+	}
+
+	// Test the stats token, owner key and admin key each grant access
+	if !strings.HasPrefix(created.StatsToken, "st_") {
+		t.Fatalf("Expected a stats token, got %q", created.StatsToken)
+	}
+	for _, headers := range []map[string]string{
+		{"x-stats-token": created.StatsToken},
+		{"x-api-key": "team-a-key"},
+		{"x-api-key": "secret"},
+	} {
+		if status := stats(code, headers); status != 200 {
+			t.Errorf("Expected stats with %v, got %d", headers, status)
+		}
+	}
+	if status := stats(code, map[string]string{"x-stats-token": "st_guess"}); status != 404 {
+		t.Errorf("Expected 404 for a wrong stats token, got %d", status)
+	}
+
+	// Test the admin can issue a token for a link created before stats
+	// tokens, and reissuing replaces the old token
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "legacy", OriginalURL: "https://example.com/legacy"})
+	issue := func(headers map[string]string) (int, model.StatsTokenResponse) {
+		resp, _ := handler.IssueStatsToken(context.Background(), events.LambdaFunctionURLRequest{RawPath: "/links/legacy/stats-token", Headers: headers})
+		var tokenResp model.StatsTokenResponse
+		json.Unmarshal([]byte(resp.Body), &tokenResp)
+		return resp.StatusCode, tokenResp
+	}
+	if status, _ := issue(map[string]string{"x-api-key": "team-a-key"}); status != 401 {
+		t.Errorf("Expected 401 for a non-admin key, got %d", status)
+	}
+	status, issued := issue(map[string]string{"x-api-key": "secret"})
+	if status != 200 || issued.ShortCode != "legacy" || !strings.HasPrefix(issued.StatsToken, "st_") {
+		t.Fatalf("Expected a stats token for the legacy link, got %d %+v", status, issued)
+	}
+	if status := stats("legacy", map[string]string{"x-stats-token": issued.StatsToken}); status != 200 {
+		t.Errorf("Expected stats with the issued token, got %d", status)
+	}
+	if _, reissued := issue(map[string]string{"x-api-key": "secret"}); stats("legacy", map[string]string{"x-stats-token": issued.StatsToken}) != 404 || stats("legacy", map[string]string{"x-stats-token": reissued.StatsToken}) != 200 {
+		t.Errorf("Expected a reissued token to replace the old one")
+	}
+
+	// Test private links get long codes and are flagged in stats
+	_, created = shorten(`{"url": "https://example.com/private", "private": true}`, nil)
+	code = created.ShortURL[strings.LastIndex(created.ShortURL, "/")+1:]
+	if len(code) != 20 {
+		t.Errorf("Expected a 20 character code for a private link, got %q", code)
+	}
+	resp, _ := handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/stats/" + code,
+		Headers: map[string]string{"x-stats-token": created.StatsToken},
+	})
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, `"private":true`) {
+		t.Errorf("Expected private stats, got %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestShortenCodeCollision(t *testing.T) {
+	t.Setenv("OWNER_API_KEYS", "team-a=team-a-key")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	handler.SetClock(func() time.Time { return now })
+
+	shorten := func(headers map[string]string) events.LambdaFunctionURLResponse {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body:    `{"url": "https://example.com/new"}`,
+			Headers: headers,
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+			},
+		})
+		return resp
+	}
+
+	// Test a taken code is never overwritten: another one is drawn, and the
+	// collision counts towards no one's usage
+	mockDB.SetTakenNext(2)
+	resp := shorten(map[string]string{"x-api-key": "team-a-key"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected a link to be created after collisions, got %d %s", resp.StatusCode, resp.Body)
+	}
+	if monthly, active, _ := mockDB.GetUsage(context.Background(), "team-a", now); monthly != 1 || active != 1 {
+		t.Errorf("Expected one link counted, got %d monthly and %d active", monthly, active)
+	}
+
+	// Test an existing link is left alone
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "taken", OriginalURL: "https://example.com/old", Owner: "team-b"})
+	if err := mockDB.CreateURLWithUsage(context.Background(), &model.URLItem{ShortCode: "taken", OriginalURL: "https://example.com/new", Owner: "team-a"}, model.Quota{}, now); err == nil || !strings.Contains(err.Error(), "URL already exists") {
+		t.Errorf("Expected a collision error, got %v", err)
+	}
+	if item, _ := mockDB.GetURL(context.Background(), "taken"); item.OriginalURL != "https://example.com/old" || item.Owner != "team-b" {
+		t.Errorf("Expected the existing link to be kept, got %+v", item)
+	}
+
+	// Test giving up once every code drawn is taken
+	mockDB.SetTakenNext(maxCodeAttempts)
+	if resp = shorten(nil); resp.StatusCode != 500 {
+		t.Errorf("Expected 500 once every code drawn is taken, got %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+	t.Setenv("OWNER_API_KEYS", "team-a=team-a-key,team-b=team-b-key")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	now := time.Date(2026, 10, 31, 23, 0, 0, 0, time.UTC)
+	handler.SetClock(func() time.Time { return now })
+	plans, err := quota.ParsePlans("small=3/2", "team-a=small", "")
+	if err != nil {
+		t.Fatalf("ParsePlans returned an error: %v", err)
+	}
+	handler.SetQuotas(plans)
+
+	shorten := func(key string) events.LambdaFunctionURLResponse {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body:    `{"url": "https://example.com"}`,
+			Headers: map[string]string{"x-api-key": key},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+			},
+		})
+		return resp
+	}
+	usage := func(headers map[string]string, query map[string]string) (events.LambdaFunctionURLResponse, model.UsageResponse) {
+		resp, _ := handler.GetUsage(context.Background(), events.LambdaFunctionURLRequest{
+			RawPath:               "/usage",
+			Headers:               headers,
+			QueryStringParameters: query,
+		})
+		var usageResp model.UsageResponse
+		json.Unmarshal([]byte(resp.Body), &usageResp)
+		return resp, usageResp
+	}
+
+	// Test the active link quota refuses a third link
+	var codes []string
+	for i := 0; i < 2; i++ {
+		resp := shorten("team-a-key")
+		if resp.StatusCode != 201 {
+			t.Fatalf("Expected link %d to be created, got %d %s", i+1, resp.StatusCode, resp.Body)
+		}
+		var shortenResp model.ShortenResponse
+		json.Unmarshal([]byte(resp.Body), &shortenResp)
+		codes = append(codes, shortenResp.ShortURL[strings.LastIndex(shortenResp.ShortURL, "/")+1:])
+	}
+	resp := shorten("team-a-key")
+	if resp.StatusCode != 403 || resp.Body != `{"error": "Active link quota exceeded", "code": "quota_exceeded", "quota": "active_links", "limit": 2}` {
+		t.Errorf("Expected 403 for the active link quota, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test deleting a link frees an active link but not the monthly quota
+	deleteReq := events.LambdaFunctionURLRequest{RawPath: "/links/" + codes[0], Headers: map[string]string{"x-api-key": "secret"}}
+	if resp, _ = handler.DeleteLink(context.Background(), deleteReq); resp.StatusCode != 204 {
+		t.Fatalf("Expected the link to be deleted, got %d %s", resp.StatusCode, resp.Body)
+	}
+	if resp = shorten("team-a-key"); resp.StatusCode != 201 {
+		t.Errorf("Expected a link to be created after deleting one, got %d %s", resp.StatusCode, resp.Body)
+	}
+	if resp = shorten("team-a-key"); resp.StatusCode != 403 || !strings.Contains(resp.Body, `"quota": "monthly_links"`) {
+		t.Errorf("Expected 403 for the monthly link quota, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test owners see their usage against their quota
+	resp, usageResp := usage(map[string]string{"x-api-key": "team-a-key"}, nil)
+	expected := model.UsageResponse{
+		Owner:        "team-a",
+		Plan:         "small",
+		Month:        "2026-10",
+		MonthlyLinks: model.UsageCounter{Used: 3, Limit: 3},
+		ActiveLinks:  model.UsageCounter{Used: 2, Limit: 2},
+	}
+	if resp.StatusCode != 200 || usageResp != expected {
+		t.Errorf("Unexpected usage: %d %+v", resp.StatusCode, usageResp)
+	}
+
+	// Test a new month starts a new monthly count
+	now = now.Add(2 * time.Hour)
+	if resp = shorten("team-a-key"); resp.StatusCode != 403 || !strings.Contains(resp.Body, `"quota": "active_links"`) {
+		t.Errorf("Expected only the active link quota to apply in November, got %d %s", resp.StatusCode, resp.Body)
+	}
+	if _, usageResp = usage(map[string]string{"x-api-key": "team-a-key"}, nil); usageResp.Month != "2026-11" || usageResp.MonthlyLinks.Used != 0 {
+		t.Errorf("Expected no links created in November, got %+v", usageResp)
+	}
+
+	// Test owners without a quota are counted but not capped, and the admin
+	// can see any owner's usage
+	for i := 0; i < 3; i++ {
+		shorten("team-b-key")
+	}
+	if _, usageResp = usage(map[string]string{"x-api-key": "secret"}, map[string]string{"owner": "team-b"}); usageResp.ActiveLinks != (model.UsageCounter{Used: 3}) {
+		t.Errorf("Expected 3 uncapped links for team-b, got %+v", usageResp)
+	}
+	if resp, _ = usage(map[string]string{"x-api-key": "secret"}, nil); resp.StatusCode != 400 {
+		t.Errorf("Expected 400 when the admin names no owner, got %d", resp.StatusCode)
+	}
+	if resp, _ = usage(nil, nil); resp.StatusCode != 401 {
+		t.Errorf("Expected 401 without an API key, got %d", resp.StatusCode)
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	t.Setenv("ADMIN_API_KEY", "secret")
+	t.Setenv("OWNER_API_KEYS", "team-a=team-a-key,team-b=team-b-key,team-c=team-c-key")
+
+	// Setup mock database
+	mockDB := database.NewMockDynamoDB().(*database.MockDynamoDB)
+	handler := NewHandler(mockDB)
+	directory, err := workspace.Parse(`[
+		{"name": "brand-a", "domains": ["go.brand-a.com", "links.brand-a.com"], "owners": ["team-a"]},
+		{"name": "brand-b", "domains": ["go.brand-b.com"], "owners": ["team-b"]}
+	]`)
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+	handler.SetWorkspaces(directory)
+
+	shorten := func(body, key string) (events.LambdaFunctionURLResponse, model.ShortenResponse) {
+		resp, _ := handler.ShortenURL(context.Background(), events.LambdaFunctionURLRequest{
+			Body:    body,
+			Headers: map[string]string{"x-api-key": key},
+			RequestContext: events.LambdaFunctionURLRequestContext{
+				DomainName: "test.lambda-url.us-east-1.amazonaws.com",
+			},
+		})
+		var shortenResp model.ShortenResponse
+		json.Unmarshal([]byte(resp.Body), &shortenResp)
+		return resp, shortenResp
+	}
+	redirect := func(host, code string) events.LambdaFunctionURLResponse {
+		resp, _ := handler.RedirectURL(context.Background(), events.LambdaFunctionURLRequest{
+			RawPath: "/" + code,
+			Headers: map[string]string{"host": host},
+		})
+		return resp
+	}
+
+	// Test links default to the first domain of the owner's workspace
+	resp, created := shorten(`{"url": "https://example.com/a"}`, "team-a-key")
+	if resp.StatusCode != 201 || !strings.HasPrefix(created.ShortURL, "https://go.brand-a.com/") {
+		t.Fatalf("Expected a link on go.brand-a.com, got %d %s", resp.StatusCode, resp.Body)
+	}
+	code := created.ShortURL[strings.LastIndex(created.ShortURL, "/")+1:]
+	if item, _ := mockDB.GetURL(context.Background(), database.LinkKey("go.brand-a.com", code)); item == nil {
+		t.Errorf("Expected the link to be stored under its domain")
+	}
+
+	// Test owners may pick another domain of their workspace, but not one
+	// of another workspace, and owners without a workspace use the default
+	if resp, created = shorten(`{"url": "https://example.com/a", "domain": "Links.Brand-A.com"}`, "team-a-key"); !strings.HasPrefix(created.ShortURL, "https://links.brand-a.com/") {
+		t.Errorf("Expected a link on links.brand-a.com, got %d %s", resp.StatusCode, resp.Body)
+	}
+	for _, key := range []string{"team-a-key", "team-c-key"} {
+		resp, _ = shorten(`{"url": "https://example.com/a", "domain": "go.brand-b.com"}`, key)
+		if resp.StatusCode != 403 || !strings.Contains(resp.Body, `"code": "domain_not_allowed"`) {
+			t.Errorf("Expected 403 for %s on go.brand-b.com, got %d %s", key, resp.StatusCode, resp.Body)
+		}
+	}
+	if _, created = shorten(`{"url": "https://example.com/c"}`, "team-c-key"); !strings.HasPrefix(created.ShortURL, "https://test.lambda-url.us-east-1.amazonaws.com/") {
+		t.Errorf("Expected a link on the default domain, got %s", created.ShortURL)
+	}
+	if resp, created = shorten(`{"url": "https://example.com/b", "domain": "go.brand-b.com"}`, "secret"); !strings.HasPrefix(created.ShortURL, "https://go.brand-b.com/") {
+		t.Errorf("Expected the admin to use go.brand-b.com, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test tags of a link on a workspace domain are indexed under its key
+	resp, created = shorten(`{"url": "https://example.com/tagged", "tags": ["launch"]}`, "team-a-key")
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected a tagged link, got %d %s", resp.StatusCode, resp.Body)
+	}
+	taggedKey := database.LinkKey("go.brand-a.com", created.ShortURL[strings.LastIndex(created.ShortURL, "/")+1:])
+	if tagged, _ := mockDB.QueryURLsByTag(context.Background(), "launch"); len(tagged) != 1 || tagged[0].ShortCode != taggedKey {
+		t.Errorf("Expected %s under the launch tag, got %+v", taggedKey, tagged)
+	}
+
+	// Test the same code resolves separately on each domain
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: "abc12", OriginalURL: "https://example.com/default"})
+	mockDB.CreateURL(context.Background(), &model.URLItem{ShortCode: database.LinkKey("go.brand-a.com", "abc12"), OriginalURL: "https://example.com/brand-a", Owner: "team-a"})
+	for host, expected := range map[string]string{
+		"test.lambda-url.us-east-1.amazonaws.com": "https://example.com/default",
+		"go.brand-a.com":     "https://example.com/brand-a",
+		"GO.BRAND-A.COM:443": "https://example.com/brand-a",
+	} {
+		if resp = redirect(host, "abc12"); resp.StatusCode != 302 || resp.Headers["Location"] != expected {
+			t.Errorf("Expected %s on %s, got %d %s", expected, host, resp.StatusCode, resp.Headers["Location"])
+		}
+	}
+	if resp = redirect("go.brand-b.com", "abc12"); resp.StatusCode != 404 {
+		t.Errorf("Expected 404 for a code of another domain, got %d", resp.StatusCode)
+	}
+
+	// Test X-Forwarded-Host is only trusted from the CDN
+	t.Setenv("FORWARDED_HOST_SECRET", "cdn-secret")
+	forwarded := func(headers map[string]string) events.LambdaFunctionURLResponse {
+		headers["host"] = "test.lambda-url.us-east-1.amazonaws.com"
+		headers["x-forwarded-host"] = "go.brand-a.com"
+		resp, _ := handler.RedirectURL(context.Background(), events.LambdaFunctionURLRequest{RawPath: "/abc12", Headers: headers})
+		return resp
+	}
+	if resp = forwarded(map[string]string{}); resp.Headers["Location"] != "https://example.com/default" {
+		t.Errorf("Expected X-Forwarded-Host to be ignored from clients, got %d %s", resp.StatusCode, resp.Headers["Location"])
+	}
+	if resp = forwarded(map[string]string{"x-origin-verify": "guess"}); resp.Headers["Location"] != "https://example.com/default" {
+		t.Errorf("Expected X-Forwarded-Host to be ignored with a wrong secret, got %d %s", resp.StatusCode, resp.Headers["Location"])
+	}
+	if resp = forwarded(map[string]string{"x-origin-verify": "cdn-secret"}); resp.Headers["Location"] != "https://example.com/brand-a" {
+		t.Errorf("Expected X-Forwarded-Host to be used from the CDN, got %d %s", resp.StatusCode, resp.Headers["Location"])
+	}
+
+	// Test stats on a workspace domain report the domain
+	resp, _ = handler.GetURLStats(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/stats/abc12",
+		Headers: map[string]string{"host": "go.brand-a.com", "x-api-key": "team-a-key"},
+	})
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, `"domain":"go.brand-a.com"`) {
+		t.Errorf("Expected stats for the brand-a link, got %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Test links on a workspace domain are managed as <domain>/<code>
+	resp, _ = handler.GetLink(context.Background(), events.LambdaFunctionURLRequest{
+		RawPath: "/links/go.brand-a.com/abc12",
+		Headers: map[string]string{"x-api-key": "secret"},
+	})
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, "https://example.com/brand-a") {
+		t.Errorf("Expected the brand-a link, got %d %s", resp.StatusCode, resp.Body)
+	}
+	for _, path := range []string{"/links//abc12", "/links/go.brand-a.com/", "/links/go.brand-a.com/a/b"} {
+		resp, _ = handler.GetLink(context.Background(), events.LambdaFunctionURLRequest{
+			RawPath: path,
+			Headers: map[string]string{"x-api-key": "secret"},
+		})
+		if resp.StatusCode != 400 {
+			t.Errorf("Expected 400 for %s, got %d %s", path, resp.StatusCode, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+)
+
+// Size limits for the descriptive details of a link
+const (
+	maxTitleLength         = 200
+	maxDescriptionLength   = 1000
+	maxTags                = 10
+	maxTagLength           = 50
+	maxMetadataEntries     = 20
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 512
+)
+
+// ListLinks returns the links carrying the tag given by GET /links?tag=...
+func (h *Handler) ListLinks(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	startTime := time.Now()
+
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	tags, err := normalizeTags([]string{req.QueryStringParameters["tag"]})
+	if err != nil {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "A valid tag query parameter is required"}`,
+		}, nil
+	}
+	tag := tags[0]
+
+	// Initialize monitoring client
+	metricClient, err := monitoring.NewClient(ctx)
+	if err != nil {
+		logger.Warn("Failed to initialize monitoring client", err)
+		// Continue without monitoring
+	}
+
+	logger.Info("Processing link search", map[string]interface{}{
+		"tag":       tag,
+		"requestId": req.RequestContext.RequestID,
+	})
+
+	urlItems, err := h.db.QueryURLsByTag(ctx, tag)
+	if err != nil {
+		logger.Error("Failed to query links by tag", map[string]interface{}{
+			"tag":   tag,
+			"error": err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "QueryURLsByTag")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to search links: " + err.Error()),
+		}, nil
+	}
+
+	response := model.LinkListResponse{
+		Tag:   tag,
+		Links: make([]model.LinkResponse, 0, len(urlItems)),
+	}
+	for i := range urlItems {
+		response.Links = append(response.Links, LinkResponse(&urlItems[i]))
+	}
+
+	// Record metrics
+	if metricClient != nil {
+		latencyMs := float64(time.Since(startTime).Milliseconds())
+		metricClient.RecordAPILatency(ctx, "/links", latencyMs)
+	}
+
+	return jsonResponse(http.StatusOK, response), nil
+}
+
+// GetLink returns the details of a single link for GET /links/{code}
+func (h *Handler) GetLink(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	code, resp, ok := linkCode(req)
+	if !ok {
+		return resp, nil
+	}
+
+	urlItem, resp, ok := h.loadLink(ctx, code)
+	if !ok {
+		return resp, nil
+	}
+	return jsonResponse(http.StatusOK, LinkResponse(urlItem)), nil
+}
+
+// UpdateLink edits the title, description, tags, metadata, Open Graph
+// overrides and routing rules of a link for PATCH /links/{code}
+func (h *Handler) UpdateLink(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	startTime := time.Now()
+
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	code, resp, ok := linkCode(req)
+	if !ok {
+		return resp, nil
+	}
+
+	var updateReq model.LinkUpdateRequest
+	if err := json.Unmarshal([]byte(req.Body), &updateReq); err != nil {
+		logger.Warn("Failed to parse link update request", map[string]interface{}{
+			"error": err.Error(),
+			"body":  req.Body,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Invalid request body"}`,
+		}, nil
+	}
+
+	urlItem, resp, ok := h.loadLink(ctx, code)
+	if !ok {
+		return resp, nil
+	}
+	previousTags := urlItem.Tags
+
+	if updateReq.Title != nil {
+		urlItem.Title = *updateReq.Title
+	}
+	if updateReq.Description != nil {
+		urlItem.Description = *updateReq.Description
+	}
+	if updateReq.Tags != nil {
+		tags, err := normalizeTags(*updateReq.Tags)
+		if err != nil {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       errorBody(err.Error()),
+			}, nil
+		}
+		urlItem.Tags = tags
+	}
+	if updateReq.Metadata != nil {
+		urlItem.Metadata = *updateReq.Metadata
+	}
+	if updateReq.OGTitle != nil {
+		urlItem.OGTitle = *updateReq.OGTitle
+	}
+	if updateReq.OGDescription != nil {
+		urlItem.OGDescription = *updateReq.OGDescription
+	}
+	if updateReq.OGImage != nil {
+		urlItem.OGImage = *updateReq.OGImage
+	}
+	err := validateDetails(urlItem.Title, urlItem.Description, urlItem.Metadata)
+	if err == nil {
+		err = validateOpenGraph(urlItem.OGTitle, urlItem.OGDescription, urlItem.OGImage)
+	}
+	if err == nil && updateReq.Rules != nil {
+		urlItem.Rules, err = validateRules(*updateReq.Rules)
+	}
+	if err == nil && updateReq.Geo != nil {
+		urlItem.Geo, err = validateGeoRules(*updateReq.Geo)
+	}
+	if err == nil && updateReq.Variants != nil {
+		urlItem.Variants, err = validateVariants(*updateReq.Variants)
+	}
+	if updateReq.Sticky != nil {
+		urlItem.Sticky = *updateReq.Sticky
+	}
+	if err == nil && updateReq.Schedule != nil {
+		urlItem.Schedule, urlItem.ScheduleTimezone, err = validateSchedule(updateReq.Schedule)
+	}
+	if err != nil {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(err.Error()),
+		}, nil
+	}
+
+	// Initialize monitoring client
+	metricClient, err := monitoring.NewClient(ctx)
+	if err != nil {
+		logger.Warn("Failed to initialize monitoring client", err)
+		// Continue without monitoring
+	}
+
+	if err := h.db.UpdateURL(ctx, urlItem); err != nil {
+		if strings.Contains(err.Error(), "URL not found") {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusNotFound,
+				Body:       `{"error": "URL not found"}`,
+			}, nil
+		}
+		logger.Error("Failed to update link", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "UpdateURL")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to update link: " + err.Error()),
+		}, nil
+	}
+
+	if updateReq.Tags != nil {
+		if err := h.db.SetURLTags(ctx, code, previousTags, urlItem.Tags); err != nil {
+			logger.Error("Failed to update tag index", map[string]interface{}{
+				"shortCode": code,
+				"error":     err.Error(),
+			})
+			if metricClient != nil {
+				metricClient.RecordDynamoDBError(ctx, "SetURLTags")
+			}
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusInternalServerError,
+				Body:       errorBody("Failed to update tag index: " + err.Error()),
+			}, nil
+		}
+	}
+
+	logger.Info("Updated link details", map[string]interface{}{
+		"shortCode": code,
+		"tags":      urlItem.Tags,
+	})
+
+	// Record metrics
+	if metricClient != nil {
+		latencyMs := float64(time.Since(startTime).Milliseconds())
+		metricClient.RecordAPILatency(ctx, "/links/{shortCode}", latencyMs)
+	}
+
+	return jsonResponse(http.StatusOK, LinkResponse(urlItem)), nil
+}
+
+// DeleteLink removes a link for DELETE /links/{code} and sends the
+// link.deleted webhook event
+func (h *Handler) DeleteLink(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	startTime := time.Now()
+
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	code, resp, ok := linkCode(req)
+	if !ok {
+		return resp, nil
+	}
+
+	// Load the link first so the event can describe it
+	urlItem, resp, ok := h.loadLink(ctx, code)
+	if !ok {
+		return resp, nil
+	}
+
+	// Initialize monitoring client
+	metricClient, err := monitoring.NewClient(ctx)
+	if err != nil {
+		logger.Warn("Failed to initialize monitoring client", err)
+		// Continue without monitoring
+	}
+
+	if err := h.db.DeleteURL(ctx, code); err != nil {
+		if strings.Contains(err.Error(), "URL not found") {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusNotFound,
+				Body:       `{"error": "URL not found"}`,
+			}, nil
+		}
+		logger.Error("Failed to delete link", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "DeleteURL")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to delete link: " + err.Error()),
+		}, nil
+	}
+
+	h.notify(webhook.EventLinkDeleted, urlItem, nil)
+
+	logger.Info("Deleted link", map[string]interface{}{
+		"shortCode": code,
+	})
+
+	// Record metrics
+	if metricClient != nil {
+		latencyMs := float64(time.Since(startTime).Milliseconds())
+		metricClient.RecordAPILatency(ctx, "/links/{shortCode}", latencyMs)
+	}
+
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+// IssueStatsToken gives a link a new stats token for POST
+// /links/{code}/stats-token. Links created before stats tokens existed get
+// their first one this way; for other links the old token stops working.
+func (h *Handler) IssueStatsToken(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	linkReq := req
+	linkReq.RawPath = strings.TrimSuffix(req.RawPath, "/stats-token")
+	code, resp, ok := linkCode(linkReq)
+	if !ok {
+		return resp, nil
+	}
+
+	urlItem, resp, ok := h.loadLink(ctx, code)
+	if !ok {
+		return resp, nil
+	}
+
+	statsToken, statsTokenHash, err := newStatsToken()
+	if err != nil {
+		logger.Error("Failed to generate stats token", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       `{"error": "Failed to generate stats token"}`,
+		}, nil
+	}
+	urlItem.StatsTokenHash = statsTokenHash
+
+	if err := h.db.UpdateURL(ctx, urlItem); err != nil {
+		if strings.Contains(err.Error(), "URL not found") {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusNotFound,
+				Body:       `{"error": "URL not found"}`,
+			}, nil
+		}
+		logger.Error("Failed to store stats token", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to update link: " + err.Error()),
+		}, nil
+	}
+
+	logger.Info("Issued stats token", map[string]interface{}{
+		"shortCode": code,
+	})
+	return jsonResponse(http.StatusOK, model.StatsTokenResponse{ShortCode: code, StatsToken: statsToken}), nil
+}
+
+// loadLink fetches a link for the management API, or the response to send
+// when it cannot be loaded
+func (h *Handler) loadLink(ctx context.Context, code string) (*model.URLItem, events.LambdaFunctionURLResponse, bool) {
+	urlItem, err := h.db.GetURL(ctx, code)
+	if err == nil {
+		return urlItem, events.LambdaFunctionURLResponse{}, true
+	}
+
+	if strings.Contains(err.Error(), "URL not found") {
+		return nil, events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       `{"error": "URL not found"}`,
+		}, false
+	}
+	logger.Error("Failed to retrieve link", map[string]interface{}{
+		"shortCode": code,
+		"error":     err.Error(),
+	})
+	return nil, events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusInternalServerError,
+		Body:       errorBody("Failed to retrieve URL: " + err.Error()),
+	}, false
+}
+
+// authorizeAdmin checks the X-Api-Key header against ADMIN_API_KEY. The link
+// management API is disabled while ADMIN_API_KEY is unset.
+func authorizeAdmin(req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, bool) {
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	if adminKey == "" {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusForbidden,
+			Body:       `{"error": "Link management is disabled"}`,
+		}, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(headerValue(req, "x-api-key")), []byte(adminKey)) != 1 {
+		logger.Warn("Rejected link management request", map[string]interface{}{
+			"path":   req.RawPath,
+			"source": req.RequestContext.HTTP.SourceIP,
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusUnauthorized,
+			Body:       `{"error": "Invalid API key"}`,
+		}, false
+	}
+	return events.LambdaFunctionURLResponse{}, true
+}
+
+// headerValue looks up a request header regardless of its case
+func headerValue(req events.LambdaFunctionURLRequest, name string) string {
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// linkCode extracts the short code from /links/{code}
+func linkCode(req events.LambdaFunctionURLRequest) (string, events.LambdaFunctionURLResponse, bool) {
+	code := strings.TrimPrefix(req.RawPath, "/links/")
+	// Links on a workspace domain are addressed as <domain>/<code>
+	_, bare := database.SplitLinkKey(code)
+	if code == req.RawPath || utils.ValidateShortCode(bare) != nil || strings.HasPrefix(code, "/") {
+		return "", events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Short code is required"}`,
+		}, false
+	}
+	return code, events.LambdaFunctionURLResponse{}, true
+}
+
+// LinkResponse converts a stored link to its management API representation
+func LinkResponse(urlItem *model.URLItem) model.LinkResponse {
+	return model.LinkResponse{
+		ShortCode:   urlItem.ShortCode,
+		Domain:      linkDomain(urlItem),
+		OriginalURL: urlItem.OriginalURL,
+		CreatedAt:   urlItem.CreatedAt,
+		ClickCount:  urlItem.ClickCount,
+		Disabled:    urlItem.Disabled,
+		Private:     urlItem.Private,
+		Campaign:    urlItem.Campaign,
+		Title:       urlItem.Title,
+		Description: urlItem.Description,
+		Tags:        urlItem.Tags,
+		Metadata:    urlItem.Metadata,
+
+		OGTitle:       urlItem.OGTitle,
+		OGDescription: urlItem.OGDescription,
+		OGImage:       urlItem.OGImage,
+
+		Rules: urlItem.Rules,
+		Geo:   urlItem.Geo,
+
+		Variants: urlItem.Variants,
+		Sticky:   urlItem.Sticky,
+
+		Schedule: scheduleResponse(urlItem),
+	}
+}
+
+// jsonResponse encodes value as a JSON response body
+func jsonResponse(status int, value interface{}) events.LambdaFunctionURLResponse {
+	responseJSON, _ := json.Marshal(value)
+	return events.LambdaFunctionURLResponse{
+		StatusCode: status,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(responseJSON),
+	}
+}
+
+// errorBody renders {"error": message} as JSON. Messages can quote user
+// input, such as a URL or tag, so they are always escaped by json.Marshal.
+func errorBody(message string) string {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	return string(body)
+}
+
+// normalizeTags lower-cases and de-duplicates tags, keeping their order.
+// Tags may contain letters, digits, '-', '_', '.' and ':'.
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tags must not be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		for _, r := range tag {
+			if !isTagRune(r) {
+				return nil, fmt.Errorf("tags may only contain letters, digits, '-', '_', '.' and ':'")
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("a link can have at most %d tags", maxTags)
+	}
+	return normalized, nil
+}
+
+func isTagRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || strings.ContainsRune("-_.:", r)
+}
+
+// validateDetails checks the title, description and metadata of a link
+// against the size limits
+func validateDetails(title, description string, metadata map[string]string) error {
+	if len(title) > maxTitleLength {
+		return fmt.Errorf("title must be at most %d characters", maxTitleLength)
+	}
+	if len(description) > maxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+	}
+	if len(metadata) > maxMetadataEntries {
+		return fmt.Errorf("metadata can have at most %d entries", maxMetadataEntries)
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata keys must be 1 to %d characters", maxMetadataKeyLength)
+		}
+		if len(value) > maxMetadataValueLength {
+			return fmt.Errorf("metadata values must be at most %d characters", maxMetadataValueLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"embed"
+	"html/template"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// pages holds the HTML pages served in place of a redirect, named by file
+var pages = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// pageResponse renders one of the embedded pages. Pages are never cached
+// since they depend on the link's current state.
+func pageResponse(name string, data interface{}, status int) events.LambdaFunctionURLResponse {
+	var body strings.Builder
+	if err := pages.ExecuteTemplate(&body, name, data); err != nil {
+		logger.Error("Failed to render page", map[string]interface{}{
+			"page":  name,
+			"error": err.Error(),
+		})
+	}
+
+	return events.LambdaFunctionURLResponse{
+		StatusCode: status,
+		Headers: map[string]string{
+			"Content-Type":  "text/html; charset=utf-8",
+			"Cache-Control": "no-store",
+		},
+		Body: body.String(),
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// previewRequest strips the preview markers from a redirect request, a "+"
+// after the code or a preview=1 query parameter, and reports whether either
+// was present
+func previewRequest(code, rawQuery string) (string, string, bool) {
+	code, preview := strings.CutSuffix(code, "+")
+
+	var kept []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		if key == "preview" {
+			if enabled, err := strconv.ParseBool(value); err == nil && enabled {
+				preview = true
+			}
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return code, strings.Join(kept, "&"), preview
+}
+
+// previewResponse shows where a link goes without following it. Continuing
+// goes through the short link itself, so the visit is counted as usual.
+func previewResponse(urlItem *model.URLItem, destination, suffix, rawQuery string) events.LambdaFunctionURLResponse {
+	_, code := database.SplitLinkKey(urlItem.ShortCode)
+	continueURL := "/" + code + suffix
+	if rawQuery != "" {
+		continueURL += "?" + rawQuery
+	}
+
+	created := urlItem.CreatedAt
+	if createdAt, err := time.Parse(time.RFC3339, urlItem.CreatedAt); err == nil {
+		created = createdAt.UTC().Format("January 2, 2006")
+	}
+
+	return pageResponse("preview.html", map[string]string{
+		"Title":       urlItem.Title,
+		"Destination": destination,
+		"Created":     created,
+		"Continue":    continueURL,
+	}, http.StatusOK)
+}
+
+// interstitialResponse shows the "you are leaving" page for links in
+// interstitial mode. The brand is BRAND_NAME, or the request's domain.
+func interstitialResponse(req events.LambdaFunctionURLRequest, urlItem *model.URLItem, destination string) events.LambdaFunctionURLResponse {
+	brand := os.Getenv("BRAND_NAME")
+	if brand == "" {
+		brand = req.RequestContext.DomainName
+	}
+
+	return pageResponse("interstitial.html", map[string]string{
+		"Brand":       brand,
+		"Title":       urlItem.Title,
+		"Destination": destination,
+	}, http.StatusOK)
+}
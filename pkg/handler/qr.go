@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/qr"
+)
+
+// How long browsers and CDNs may cache a QR code. The image only depends on
+// the short URL and the rendering options, so it never goes stale.
+const qrCacheControl = "public, max-age=31536000, immutable"
+
+// QRCode renders a QR code of the full short URL for GET /{code}/qr
+func (h *Handler) QRCode(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	startTime := time.Now()
+
+	code := strings.TrimSuffix(strings.TrimPrefix(req.RawPath, "/"), "/qr")
+	if code == "" || strings.Contains(code, "/") {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Short code is required"}`,
+		}, nil
+	}
+
+	opts, err := qr.ParseOptions(req.QueryStringParameters)
+	if err != nil {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(err.Error()),
+		}, nil
+	}
+
+	// Initialize monitoring client
+	metricClient, err := monitoring.NewClient(ctx)
+	if err != nil {
+		logger.Warn("Failed to initialize monitoring client", err)
+		// Continue without monitoring
+	}
+
+	logger.Info("Processing QR code request", map[string]interface{}{
+		"shortCode": code,
+		"format":    opts.Format,
+		"requestId": req.RequestContext.RequestID,
+	})
+
+	if resp, ok := h.checkLookups(ctx, req); !ok {
+		return resp, nil
+	}
+
+	// Only render codes for links that exist
+	key := h.linkKey(req, code)
+	if _, err := h.db.GetURL(ctx, key); err != nil {
+		if strings.Contains(err.Error(), "URL not found") {
+			return h.notFound(ctx, req, metricClient), nil
+		}
+
+		logger.Error("Failed to retrieve URL for QR code", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "GetURL")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to retrieve URL: " + err.Error()),
+		}, nil
+	}
+
+	image, err := qr.Render(linkShortURL(req, key), opts)
+	if err != nil {
+		logger.Error("Failed to render QR code", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to render QR code: " + err.Error()),
+		}, nil
+	}
+
+	sum := sha256.Sum256(image)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	headers := map[string]string{
+		"Cache-Control": qrCacheControl,
+		"ETag":          etag,
+	}
+
+	// Record metrics
+	if metricClient != nil {
+		latencyMs := float64(time.Since(startTime).Milliseconds())
+		metricClient.RecordAPILatency(ctx, "/{shortCode}/qr", latencyMs)
+	}
+
+	if headerValue(req, "if-none-match") == etag {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusNotModified,
+			Headers:    headers,
+		}, nil
+	}
+
+	headers["Content-Type"] = opts.Format.ContentType()
+	if opts.Format == qr.FormatSVG {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusOK,
+			Headers:    headers,
+			Body:       string(image),
+		}, nil
+	}
+	return events.LambdaFunctionURLResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         headers,
+		Body:            base64.StdEncoding.EncodeToString(image),
+		IsBase64Encoded: true,
+	}, nil
+}
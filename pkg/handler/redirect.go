@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+)
+
+// How long browsers and CDNs may cache permanent redirects
+const permanentRedirectMaxAge = 24 * 60 * 60
+
+// Query forwarding modes for a link
+const (
+	// ForwardQueryMerge adds incoming parameters the destination does not already have
+	ForwardQueryMerge = utils.ForwardQueryMerge
+	// ForwardQueryOverride lets incoming parameters replace the destination's
+	ForwardQueryOverride = utils.ForwardQueryOverride
+)
+
+// splitShortPath separates "/{code}/rest/of/path" into the short code and
+// the remaining path suffix ("/rest/of/path", or "" if there is none)
+func splitShortPath(path string) (string, string) {
+	code, suffix, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found {
+		return code, ""
+	}
+	return code, "/" + suffix
+}
+
+// buildDestination applies a link's passthrough options to its destination,
+// appending the incoming path suffix and forwarding the query string
+func buildDestination(urlItem *model.URLItem, suffix, rawQuery string) (string, error) {
+	if (suffix == "" || !urlItem.ForwardPath) && (rawQuery == "" || urlItem.ForwardQuery == "") {
+		return urlItem.OriginalURL, nil
+	}
+
+	destination, err := url.Parse(urlItem.OriginalURL)
+	if err != nil {
+		return "", err
+	}
+
+	if suffix != "" && urlItem.ForwardPath {
+		var segments []string
+		for _, segment := range strings.Split(suffix, "/") {
+			unescaped, err := url.PathUnescape(segment)
+			if err != nil {
+				return "", fmt.Errorf("invalid path suffix: %v", err)
+			}
+			// Dot segments could climb out of the destination's base path
+			if unescaped == "" || unescaped == "." || unescaped == ".." {
+				continue
+			}
+			segments = append(segments, unescaped)
+		}
+		destination = destination.JoinPath(segments...)
+		if strings.HasSuffix(suffix, "/") && !strings.HasSuffix(destination.Path, "/") {
+			destination.Path += "/"
+			if destination.RawPath != "" {
+				destination.RawPath += "/"
+			}
+		}
+	}
+
+	if rawQuery != "" && urlItem.ForwardQuery != "" {
+		incoming, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return "", fmt.Errorf("invalid query string: %v", err)
+		}
+
+		query := destination.Query()
+		for key, values := range incoming {
+			if _, exists := query[key]; exists && urlItem.ForwardQuery != ForwardQueryOverride {
+				continue
+			}
+			query[key] = values
+		}
+		destination.RawQuery = query.Encode()
+	}
+
+	return destination.String(), nil
+}
+
+// redirectStatus picks the status code for a link: its own redirect_type,
+// then DEFAULT_REDIRECT_TYPE, then 302 Found
+func redirectStatus(urlItem *model.URLItem) int {
+	if utils.IsValidRedirectType(urlItem.RedirectType) {
+		return urlItem.RedirectType
+	}
+
+	if value := os.Getenv("DEFAULT_REDIRECT_TYPE"); value != "" {
+		status, err := strconv.Atoi(value)
+		if err == nil && utils.IsValidRedirectType(status) {
+			return status
+		}
+		logger.Warn("Ignoring invalid DEFAULT_REDIRECT_TYPE", map[string]interface{}{
+			"value": value,
+		})
+	}
+	return http.StatusFound
+}
+
+// redirectResponse builds a redirect to location. Permanent redirects may be
+// cached by browsers and CDNs; temporary ones must come back to us every time
+// so clicks are counted and expiration is honoured.
+func redirectResponse(location string, status int) events.LambdaFunctionURLResponse {
+	cacheControl := "private, no-store"
+	if status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect {
+		cacheControl = "public, max-age=" + strconv.Itoa(permanentRedirectMaxAge)
+	}
+
+	return events.LambdaFunctionURLResponse{
+		StatusCode: status,
+		Headers: map[string]string{
+			"Location":      location,
+			"Cache-Control": cacheControl,
+		},
+		Body: "",
+	}
+}
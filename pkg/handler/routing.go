@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/useragent"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+)
+
+const (
+	// Most routing rules a link may have
+	maxRoutingRules = 20
+	// Rule name counted when no routing rule matched
+	defaultRuleName = "default"
+)
+
+// validateRules normalizes routing rules and checks that each one has at
+// least one known condition, a valid destination and a unique name
+func validateRules(rules []model.RoutingRule) ([]model.RoutingRule, error) {
+	if len(rules) > maxRoutingRules {
+		return nil, fmt.Errorf("a link can have at most %d routing rules", maxRoutingRules)
+	}
+
+	normalized := make([]model.RoutingRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		rule.OS = strings.ToLower(rule.OS)
+		rule.Device = strings.ToLower(rule.Device)
+		rule.Browser = strings.ToLower(rule.Browser)
+
+		if rule.OS == "" && rule.Device == "" && rule.Browser == "" {
+			return nil, fmt.Errorf("routing rule %d needs an os, device or browser", i+1)
+		}
+		if rule.OS != "" && !slices.Contains(useragent.OperatingSystems, rule.OS) {
+			return nil, fmt.Errorf("routing rule %d: os must be one of %s", i+1, strings.Join(useragent.OperatingSystems, ", "))
+		}
+		if rule.Device != "" && !slices.Contains(useragent.Devices, rule.Device) {
+			return nil, fmt.Errorf("routing rule %d: device must be one of %s", i+1, strings.Join(useragent.Devices, ", "))
+		}
+		if rule.Browser != "" && !slices.Contains(useragent.Browsers, rule.Browser) {
+			return nil, fmt.Errorf("routing rule %d: browser must be one of %s", i+1, strings.Join(useragent.Browsers, ", "))
+		}
+		if !isAppOrHTTPURL(rule.URL) {
+			return nil, fmt.Errorf("routing rule %d needs an absolute url", i+1)
+		}
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if len(rule.Name) > maxTagLength || rule.Name == defaultRuleName || names[rule.Name] {
+			return nil, fmt.Errorf("routing rule %d needs a unique name of at most %d characters other than %s", i+1, maxTagLength, defaultRuleName)
+		}
+		names[rule.Name] = true
+
+		normalized = append(normalized, rule)
+	}
+	return normalized, nil
+}
+
+// matchRule returns the first rule whose conditions all match the visitor,
+// or nil if none do
+func matchRule(rules []model.RoutingRule, visitor useragent.Info) *model.RoutingRule {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.OS != "" && rule.OS != visitor.OS {
+			continue
+		}
+		if rule.Device != "" && rule.Device != visitor.Device {
+			continue
+		}
+		if rule.Browser != "" && rule.Browser != visitor.Browser {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// requestUserAgent returns the visitor's User-Agent header
+func requestUserAgent(req events.LambdaFunctionURLRequest) string {
+	if userAgent := req.RequestContext.HTTP.UserAgent; userAgent != "" {
+		return userAgent
+	}
+	return headerValue(req, "user-agent")
+}
+
+// isAppOrHTTPURL accepts http(s) URLs as well as app deep links such as
+// "myapp://open" or "itms-apps://..."
+func isAppOrHTTPURL(s string) bool {
+	if utils.IsHTTPURL(s) {
+		return true
+	}
+	scheme, rest, found := strings.Cut(s, "://")
+	if !found || scheme == "" || rest == "" {
+		return false
+	}
+	switch strings.ToLower(scheme) {
+	case "javascript", "data", "vbscript", "file":
+		return false
+	}
+	for _, r := range scheme {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
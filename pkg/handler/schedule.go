@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	// Embed the time zone database; the Lambda runtime does not ship one
+	_ "time/tzdata"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// Most windows a link's schedule can have
+const maxScheduleWindows = 50
+
+// Local time layouts accepted for schedule windows, besides RFC 3339
+var scheduleTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// validateSchedule resolves a schedule's window times in its time zone
+// (UTC by default) and checks that each window has a valid destination,
+// ends after it starts and does not overlap another. The windows are
+// returned in time order.
+func validateSchedule(schedule *model.Schedule) ([]model.ScheduleWindow, string, error) {
+	if schedule == nil || len(schedule.Windows) == 0 {
+		return nil, "", nil
+	}
+	if len(schedule.Windows) > maxScheduleWindows {
+		return nil, "", fmt.Errorf("a schedule can have at most %d windows", maxScheduleWindows)
+	}
+
+	timezone := schedule.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, "", fmt.Errorf("timezone must be an IANA time zone such as Europe/London")
+	}
+
+	type indexedWindow struct {
+		model.ScheduleWindow
+		index int
+	}
+	windows := make([]indexedWindow, 0, len(schedule.Windows))
+	for i, window := range schedule.Windows {
+		from, err := parseScheduleTime(window.From, location)
+		if err != nil {
+			return nil, "", fmt.Errorf("schedule window %d: %v", i+1, err)
+		}
+		to, err := parseScheduleTime(window.To, location)
+		if err != nil {
+			return nil, "", fmt.Errorf("schedule window %d: %v", i+1, err)
+		}
+		if from == 0 && to == 0 {
+			return nil, "", fmt.Errorf("schedule window %d needs a from or to time", i+1)
+		}
+		if from != 0 && to != 0 && from >= to {
+			return nil, "", fmt.Errorf("schedule window %d must end after it starts", i+1)
+		}
+		if !isAppOrHTTPURL(window.URL) {
+			return nil, "", fmt.Errorf("schedule window %d needs an absolute url", i+1)
+		}
+		windows = append(windows, indexedWindow{model.ScheduleWindow{From: from, To: to, URL: window.URL}, i})
+	}
+
+	// An open start sorts first; each window must end by the time the next
+	// one starts
+	sort.SliceStable(windows, func(i, j int) bool {
+		return windows[i].From < windows[j].From
+	})
+	resolved := make([]model.ScheduleWindow, 0, len(windows))
+	for i, window := range windows {
+		if i > 0 {
+			previous := windows[i-1]
+			if previous.To == 0 || window.From < previous.To {
+				first, second := previous.index+1, window.index+1
+				if first > second {
+					first, second = second, first
+				}
+				return nil, "", fmt.Errorf("schedule windows %d and %d overlap", first, second)
+			}
+		}
+		resolved = append(resolved, window.ScheduleWindow)
+	}
+	return resolved, timezone, nil
+}
+
+// parseScheduleTime reads an RFC 3339 timestamp or a local time in
+// location, returning 0 for an empty value
+func parseScheduleTime(value string, location *time.Location) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	for _, layout := range scheduleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("times must be RFC 3339 or local times such as 2026-11-01T09:00")
+}
+
+// activeWindow returns the schedule window containing now, or nil
+func activeWindow(windows []model.ScheduleWindow, now time.Time) *model.ScheduleWindow {
+	unix := now.Unix()
+	for i := range windows {
+		window := &windows[i]
+		if (window.From == 0 || unix >= window.From) && (window.To == 0 || unix < window.To) {
+			return window
+		}
+	}
+	return nil
+}
+
+// scheduleResponse renders a link's schedule with times in its time zone
+func scheduleResponse(urlItem *model.URLItem) *model.Schedule {
+	if len(urlItem.Schedule) == 0 {
+		return nil
+	}
+	location, err := time.LoadLocation(urlItem.ScheduleTimezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	format := func(unix int64) string {
+		if unix == 0 {
+			return ""
+		}
+		return time.Unix(unix, 0).In(location).Format(time.RFC3339)
+	}
+	schedule := &model.Schedule{
+		Timezone: urlItem.ScheduleTimezone,
+		Windows:  make([]model.ScheduleWindowTimes, 0, len(urlItem.Schedule)),
+	}
+	for _, window := range urlItem.Schedule {
+		schedule.Windows = append(schedule.Windows, model.ScheduleWindowTimes{
+			From: format(window.From),
+			To:   format(window.To),
+			URL:  window.URL,
+		})
+	}
+	return schedule
+}
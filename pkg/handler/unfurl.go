@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+)
+
+// unfurlers are user agent fragments of the link preview bots used by chat
+// and social apps. Matching is case-insensitive.
+var unfurlers = []string{
+	"Slackbot",
+	"LinkedInBot",
+	"facebookexternalhit",
+	"Facebot",
+	"Twitterbot",
+	"Discordbot",
+	"TelegramBot",
+	"WhatsApp",
+	"SkypeUriPreview",
+	"MicrosoftPreview",
+	"redditbot",
+	"Pinterestbot",
+	"Embedly",
+	"Iframely",
+	"Mastodon",
+}
+
+// unfurlerAgent returns the unfurler a request comes from, or "" for any
+// other client
+func unfurlerAgent(req events.LambdaFunctionURLRequest) string {
+	userAgent := strings.ToLower(requestUserAgent(req))
+
+	for _, agent := range unfurlers {
+		if strings.Contains(userAgent, strings.ToLower(agent)) {
+			return agent
+		}
+	}
+	return ""
+}
+
+// unfurlResponse serves an Open Graph document describing a link, so
+// unfurlers can build a preview without following the redirect. The link's
+// og_* overrides win over its title and description.
+func unfurlResponse(req events.LambdaFunctionURLRequest, urlItem *model.URLItem, destination string) events.LambdaFunctionURLResponse {
+	title := firstNonEmpty(urlItem.OGTitle, urlItem.Title, destination)
+	description := firstNonEmpty(urlItem.OGDescription, urlItem.Description)
+
+	return pageResponse("unfurl.html", map[string]string{
+		"URL":         linkShortURL(req, urlItem.ShortCode),
+		"Title":       title,
+		"Description": description,
+		"Image":       urlItem.OGImage,
+		"Destination": destination,
+	}, http.StatusOK)
+}
+
+// validateOpenGraph checks the Open Graph overrides of a link
+func validateOpenGraph(title, description, image string) error {
+	if len(title) > maxTitleLength {
+		return fmt.Errorf("og_title must be at most %d characters", maxTitleLength)
+	}
+	if len(description) > maxDescriptionLength {
+		return fmt.Errorf("og_description must be at most %d characters", maxDescriptionLength)
+	}
+	if image != "" && !utils.IsHTTPURL(image) {
+		return fmt.Errorf("og_image must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+)
+
+const (
+	// How long an unlock cookie allows redirects for
+	unlockCookieTTL = 10 * time.Minute
+	// Wrong passwords allowed before unlocking is locked out
+	maxUnlockAttempts = 5
+	// How long unlocking is refused after too many wrong passwords
+	unlockLockout = 15 * time.Minute
+)
+
+// UnlockURL checks the password for a protected short URL and, if it is
+// correct, sets a signed cookie that lets the visitor through RedirectURL
+func (h *Handler) UnlockURL(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	code := strings.TrimSuffix(strings.TrimPrefix(req.RawPath, "/"), "/unlock")
+	if code == "" || strings.Contains(code, "/") {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Short code is required"}`,
+		}, nil
+	}
+
+	logger.Info("Processing unlock request", map[string]interface{}{
+		"shortCode": code,
+		"requestId": req.RequestContext.RequestID,
+	})
+
+	key := h.linkKey(req, code)
+	urlItem, err := h.db.GetURL(ctx, key)
+	if err != nil {
+		if strings.Contains(err.Error(), "URL not found") {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusNotFound,
+				Body:       `{"error": "URL not found"}`,
+			}, nil
+		}
+		logger.Error("Failed to retrieve URL for unlock", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to retrieve URL: " + err.Error()),
+		}, nil
+	}
+
+	if urlItem.PasswordHash == "" {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "URL is not password protected"}`,
+		}, nil
+	}
+	if unlockSecret() == nil {
+		logger.Error("Cannot unlock without UNLOCK_COOKIE_SECRET", map[string]interface{}{
+			"shortCode": code,
+		})
+		return passwordLinksDisabled(), nil
+	}
+
+	// Wrong passwords are counted per client, so nobody can lock a link for
+	// everyone else
+	now := h.now()
+	client := rateLimitClient(req)
+	failures, lockedUntil, err := h.db.GetUnlockFailures(ctx, key, client)
+	if err != nil {
+		logger.Error("Failed to get unlock failures", map[string]interface{}{
+			"shortCode": code,
+			"error":     err.Error(),
+		})
+	}
+	if lockedUntil > now.Unix() {
+		logger.Warn("Unlock attempted while locked out", map[string]interface{}{
+			"shortCode":   code,
+			"client":      client,
+			"lockedUntil": lockedUntil,
+		})
+		return tooManyUnlockAttempts(code, lockedUntil-now.Unix()), nil
+	}
+
+	if !utils.CheckPassword(urlItem.PasswordHash, formValue(req, "password")) {
+		failures, err := h.db.RecordUnlockFailure(ctx, key, client, now.Add(unlockLockout).Unix())
+		if err != nil {
+			logger.Error("Failed to record unlock failure", map[string]interface{}{
+				"shortCode": code,
+				"error":     err.Error(),
+			})
+		}
+		logger.Warn("Wrong password for protected URL", map[string]interface{}{
+			"shortCode": code,
+			"failures":  failures,
+			"client":    client,
+		})
+
+		if failures >= maxUnlockAttempts {
+			lockedUntil := now.Add(unlockLockout).Unix()
+			if err := h.db.ResetUnlockFailures(ctx, key, client, lockedUntil); err != nil {
+				logger.Error("Failed to lock out unlock attempts", map[string]interface{}{
+					"shortCode": code,
+					"error":     err.Error(),
+				})
+			}
+			return tooManyUnlockAttempts(code, int64(unlockLockout.Seconds())), nil
+		}
+		return passwordFormResponse(code, "Incorrect password, please try again.", http.StatusUnauthorized), nil
+	}
+
+	if failures > 0 || lockedUntil > 0 {
+		if err := h.db.ResetUnlockFailures(ctx, key, client, 0); err != nil {
+			logger.Warn("Failed to reset unlock failures", map[string]interface{}{
+				"shortCode": code,
+				"error":     err.Error(),
+			})
+		}
+	}
+
+	expires := now.Add(unlockCookieTTL)
+	cookie := &http.Cookie{
+		Name:     unlockCookieName(key),
+		Value:    signUnlock(key, expires.Unix()),
+		Path:     "/" + code,
+		MaxAge:   int(unlockCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	logger.Info("Unlocked protected URL", map[string]interface{}{
+		"shortCode": code,
+	})
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusSeeOther,
+		Headers: map[string]string{
+			"Location":      "/" + code,
+			"Cache-Control": "no-store",
+		},
+		Cookies: []string{cookie.String()},
+	}, nil
+}
+
+// passwordFormResponse renders the password prompt for a protected link
+func passwordFormResponse(code, message string, status int) events.LambdaFunctionURLResponse {
+	return pageResponse("unlock.html", map[string]string{
+		"Code":    code,
+		"Message": message,
+	}, status)
+}
+
+// tooManyUnlockAttempts answers an unlock attempt during a lockout
+func tooManyUnlockAttempts(code string, retryAfter int64) events.LambdaFunctionURLResponse {
+	resp := passwordFormResponse(code, "Too many incorrect attempts. Please try again later.", http.StatusTooManyRequests)
+	resp.Headers["Retry-After"] = strconv.FormatInt(retryAfter, 10)
+	return resp
+}
+
+// passwordLinksDisabled answers requests that need UNLOCK_COOKIE_SECRET
+// when it is not set
+func passwordLinksDisabled() events.LambdaFunctionURLResponse {
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusServiceUnavailable,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: `{"error": "Password protected links are not configured", "code": "unlock_secret_missing"}`,
+	}
+}
+
+// hasValidUnlockCookie reports whether the request carries an unexpired,
+// correctly signed unlock cookie for the link stored under key
+func hasValidUnlockCookie(req events.LambdaFunctionURLRequest, key string, now time.Time) bool {
+	if unlockSecret() == nil {
+		return false
+	}
+	name := unlockCookieName(key)
+	for _, header := range req.Cookies {
+		for _, part := range strings.Split(header, ";") {
+			cookieName, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || cookieName != name {
+				continue
+			}
+
+			expiresText, _, ok := strings.Cut(value, ".")
+			if !ok {
+				continue
+			}
+			expires, err := strconv.ParseInt(expiresText, 10, 64)
+			if err != nil || expires <= now.Unix() {
+				continue
+			}
+			if hmac.Equal([]byte(value), []byte(signUnlock(key, expires))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unlockCookieName is the cookie used to remember an unlocked link. Cookies
+// are kept per host, so the code alone tells links apart.
+func unlockCookieName(key string) string {
+	_, code := database.SplitLinkKey(key)
+	return "unlock_" + code
+}
+
+// signUnlock produces the cookie value "<expires>.<signature>" for a link,
+// signing its full key so the cookie is no use on another domain
+func signUnlock(key string, expires int64) string {
+	mac := hmac.New(sha256.New, unlockSecret())
+	fmt.Fprintf(mac, "%s|%d", key, expires)
+	return fmt.Sprintf("%d.%s", expires, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// unlockSecret returns the key used to sign unlock cookies, or nil when
+// UNLOCK_COOKIE_SECRET is unset. Every Lambda instance must sign with the
+// same key, so password protected links need it to be configured.
+func unlockSecret() []byte {
+	if secret := os.Getenv("UNLOCK_COOKIE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return nil
+}
+
+// formValue reads a field from an application/x-www-form-urlencoded body
+func formValue(req events.LambdaFunctionURLRequest, name string) string {
+	body := req.Body
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return ""
+		}
+		body = string(decoded)
+	}
+
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return ""
+	}
+	return values.Get(name)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+)
+
+// GetUsage reports an owner's quota consumption for the current month.
+// Owners see their own usage; the admin names the owner with ?owner=.
+func (h *Handler) GetUsage(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	owner := requestOwner(req)
+	if owner == "" {
+		if !isAdmin(req) {
+			logger.Warn("Rejected usage request", map[string]interface{}{
+				"source": req.RequestContext.HTTP.SourceIP,
+			})
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusUnauthorized,
+				Body:       `{"error": "Invalid API key"}`,
+			}, nil
+		}
+		owner = req.QueryStringParameters["owner"]
+		if owner == "" {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       `{"error": "owner is required"}`,
+			}, nil
+		}
+	}
+
+	now := h.now()
+	monthly, active, err := h.db.GetUsage(ctx, owner, now)
+	if err != nil {
+		logger.Error("Failed to get usage", map[string]interface{}{
+			"owner": owner,
+			"error": err.Error(),
+		})
+		if metricClient, _ := monitoring.NewClient(ctx); metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "GetUsage")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to get usage: " + err.Error()),
+		}, nil
+	}
+
+	// Links created before usage was counted are still released when they
+	// are removed, which can take the count below zero
+	if active < 0 {
+		active = 0
+	}
+
+	quota := h.quotas.For(owner)
+	return jsonResponse(http.StatusOK, model.UsageResponse{
+		Owner:        owner,
+		Plan:         quota.Plan,
+		Month:        now.UTC().Format("2006-01"),
+		MonthlyLinks: model.UsageCounter{Used: monthly, Limit: quota.MonthlyLinks},
+		ActiveLinks:  model.UsageCounter{Used: active, Limit: quota.ActiveLinks},
+	}), nil
+}
+
+// quotaExceededResponse refuses a new link that would take its owner past
+// a quota
+func quotaExceededResponse(err *database.QuotaExceededError) events.LambdaFunctionURLResponse {
+	message := "Monthly link quota exceeded"
+	if err.Quota == database.QuotaActiveLinks {
+		message = "Active link quota exceeded"
+	}
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusForbidden,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: fmt.Sprintf(`{"error": "%s", "code": "quota_exceeded", "quota": "%s", "limit": %d}`, message, err.Quota, err.Limit),
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+const (
+	// Most destinations a link can split traffic between
+	maxVariants = 10
+	// Largest weight a single variant can have
+	maxVariantWeight = 1000
+)
+
+// validateVariants names unnamed variants "a", "b", ... and checks that
+// there are at least two, each with a valid destination, a weight and a
+// unique name, and that the weights do not add up to zero
+func validateVariants(variants []model.Variant) ([]model.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, fmt.Errorf("a link needs between 2 and %d variants", maxVariants)
+	}
+
+	normalized := make([]model.Variant, 0, len(variants))
+	names := make(map[string]bool, len(variants))
+	total := 0
+	for i, variant := range variants {
+		if !isAppOrHTTPURL(variant.URL) {
+			return nil, fmt.Errorf("variant %d needs an absolute url", i+1)
+		}
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			return nil, fmt.Errorf("variant %d needs a weight between 0 and %d", i+1, maxVariantWeight)
+		}
+		if variant.Name == "" {
+			variant.Name = string(rune('a' + i))
+		}
+		if len(variant.Name) > maxTagLength || names[variant.Name] {
+			return nil, fmt.Errorf("variant %d needs a unique name of at most %d characters", i+1, maxTagLength)
+		}
+		names[variant.Name] = true
+		total += variant.Weight
+
+		normalized = append(normalized, variant)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one variant needs a positive weight")
+	}
+	return normalized, nil
+}
+
+// pickVariant chooses one of the link's variants in proportion to their
+// weights. Sticky links hash the visitor so repeat visits get the same
+// variant; others pick at random.
+func pickVariant(urlItem *model.URLItem, req events.LambdaFunctionURLRequest) *model.Variant {
+	total := 0
+	for _, variant := range urlItem.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	var n int
+	if urlItem.Sticky {
+		hash := fnv.New64a()
+		hash.Write([]byte(urlItem.ShortCode + "\x00" + visitorIdentity(req)))
+		n = int(hash.Sum64() % uint64(total))
+	} else {
+		n = rand.IntN(total)
+	}
+
+	for i := range urlItem.Variants {
+		variant := &urlItem.Variants[i]
+		if n < variant.Weight {
+			return variant
+		}
+		n -= variant.Weight
+	}
+	return nil
+}
+
+// visitorIdentity identifies a visitor for sticky variants by source IP
+// and user agent, so no cookie or stored state is needed
+func visitorIdentity(req events.LambdaFunctionURLRequest) string {
+	return req.RequestContext.HTTP.SourceIP + "\x00" + requestUserAgent(req)
+}
+
+// variantStats reports each variant's clicks and share of variant clicks,
+// as a percentage rounded to one decimal place
+func variantStats(urlItem *model.URLItem) []model.VariantStats {
+	if len(urlItem.Variants) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, variant := range urlItem.Variants {
+		total += urlItem.VariantClicks[variant.Name]
+	}
+
+	stats := make([]model.VariantStats, 0, len(urlItem.Variants))
+	for _, variant := range urlItem.Variants {
+		clicks := urlItem.VariantClicks[variant.Name]
+		share := 0.0
+		if total > 0 {
+			share = math.Round(float64(clicks)*1000/float64(total)) / 10
+		}
+		stats = append(stats, model.VariantStats{
+			Name:   variant.Name,
+			URL:    variant.URL,
+			Weight: variant.Weight,
+			Clicks: clicks,
+			Share:  share,
+		})
+	}
+	return stats
+}
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/monitoring"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/utils"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+)
+
+const (
+	// Most webhook subscriptions allowed
+	maxWebhooks = 25
+	// Shortest signing secret accepted from a request
+	minWebhookSecretLength = 16
+	// Delivery log entries returned by default and at most
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 100
+)
+
+// CreateWebhook subscribes a URL to link events for POST /webhooks. The
+// response is the only time the signing secret is shown.
+func (h *Handler) CreateWebhook(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	startTime := time.Now()
+
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	var webhookReq model.WebhookRequest
+	if err := json.Unmarshal([]byte(req.Body), &webhookReq); err != nil {
+		logger.Warn("Failed to parse webhook request", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Invalid request body"}`,
+		}, nil
+	}
+	if err := validateWebhook(&webhookReq); err != nil {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       errorBody(err.Error()),
+		}, nil
+	}
+
+	// Initialize monitoring client
+	metricClient, err := monitoring.NewClient(ctx)
+	if err != nil {
+		logger.Warn("Failed to initialize monitoring client", err)
+		// Continue without monitoring
+	}
+
+	existing, err := h.db.ListWebhooks(ctx)
+	if err == nil && len(existing) >= maxWebhooks {
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusConflict,
+			Body:       errorBody(fmt.Sprintf("at most %d webhooks can be subscribed", maxWebhooks)),
+		}, nil
+	}
+
+	secret := webhookReq.Secret
+	if secret == "" {
+		secret = webhook.NewSecret()
+	}
+	subscription := &model.Webhook{
+		ID:        webhook.NewID(),
+		URL:       webhookReq.URL,
+		Secret:    secret,
+		Events:    webhookReq.Events,
+		CreatedAt: h.now().UTC().Format(time.RFC3339),
+	}
+	if err == nil {
+		err = h.db.CreateWebhook(ctx, subscription)
+	}
+	if err != nil {
+		logger.Error("Failed to create webhook", map[string]interface{}{
+			"error": err.Error(),
+		})
+		if metricClient != nil {
+			metricClient.RecordDynamoDBError(ctx, "CreateWebhook")
+		}
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to create webhook: " + err.Error()),
+		}, nil
+	}
+	if h.webhooks != nil {
+		h.webhooks.Invalidate()
+	}
+
+	logger.Info("Created webhook", map[string]interface{}{
+		"webhookId": subscription.ID,
+		"url":       subscription.URL,
+		"events":    subscription.Events,
+	})
+
+	// Record metrics
+	if metricClient != nil {
+		latencyMs := float64(time.Since(startTime).Milliseconds())
+		metricClient.RecordAPILatency(ctx, "/webhooks", latencyMs)
+	}
+
+	return jsonResponse(http.StatusCreated, subscription), nil
+}
+
+// ListWebhooks returns every webhook subscription, without secrets, for
+// GET /webhooks
+func (h *Handler) ListWebhooks(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	webhooks, err := h.db.ListWebhooks(ctx)
+	if err != nil {
+		logger.Error("Failed to list webhooks", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to list webhooks: " + err.Error()),
+		}, nil
+	}
+
+	response := model.WebhookListResponse{Webhooks: make([]model.Webhook, 0, len(webhooks))}
+	for _, subscription := range webhooks {
+		subscription.Secret = ""
+		response.Webhooks = append(response.Webhooks, subscription)
+	}
+	return jsonResponse(http.StatusOK, response), nil
+}
+
+// DeleteWebhook unsubscribes a webhook for DELETE /webhooks/{id}
+func (h *Handler) DeleteWebhook(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	id, resp, ok := webhookID(req, "")
+	if !ok {
+		return resp, nil
+	}
+
+	if err := h.db.DeleteWebhook(ctx, id); err != nil {
+		if strings.Contains(err.Error(), "webhook not found") {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusNotFound,
+				Body:       `{"error": "Webhook not found"}`,
+			}, nil
+		}
+		logger.Error("Failed to delete webhook", map[string]interface{}{
+			"webhookId": id,
+			"error":     err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to delete webhook: " + err.Error()),
+		}, nil
+	}
+	if h.webhooks != nil {
+		h.webhooks.Invalidate()
+	}
+
+	logger.Info("Deleted webhook", map[string]interface{}{
+		"webhookId": id,
+	})
+	return events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+// ListWebhookDeliveries returns a webhook's most recent delivery attempts
+// for GET /webhooks/{id}/deliveries?limit=...
+func (h *Handler) ListWebhookDeliveries(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if resp, ok := authorizeAdmin(req); !ok {
+		return resp, nil
+	}
+
+	id, resp, ok := webhookID(req, "/deliveries")
+	if !ok {
+		return resp, nil
+	}
+
+	limit := defaultDeliveryLimit
+	if value := req.QueryStringParameters["limit"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveryLimit {
+			return events.LambdaFunctionURLResponse{
+				StatusCode: http.StatusBadRequest,
+				Body:       errorBody(fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit)),
+			}, nil
+		}
+		limit = n
+	}
+
+	deliveries, err := h.db.ListWebhookDeliveries(ctx, id, limit)
+	if err != nil {
+		logger.Error("Failed to list webhook deliveries", map[string]interface{}{
+			"webhookId": id,
+			"error":     err.Error(),
+		})
+		return events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       errorBody("Failed to list webhook deliveries: " + err.Error()),
+		}, nil
+	}
+	if deliveries == nil {
+		deliveries = []model.WebhookDelivery{}
+	}
+
+	return jsonResponse(http.StatusOK, model.WebhookDeliveryListResponse{
+		WebhookID:  id,
+		Deliveries: deliveries,
+	}), nil
+}
+
+// notify sends a link event to the webhook subscriptions in the
+// background, if webhooks are enabled
+func (h *Handler) notify(eventType string, urlItem *model.URLItem, click *webhook.Click) {
+	if h.webhooks == nil {
+		return
+	}
+	event := webhook.NewEvent(eventType, LinkResponse(urlItem), h.now())
+	event.Click = click
+	h.webhooks.Notify(event)
+}
+
+// notifyExpired marks a link as expired the first time it is visited after
+// expiry. The stream processor sees the change and sends link.expired, as
+// it does for links that TTL removes without a visit.
+func (h *Handler) notifyExpired(ctx context.Context, urlItem *model.URLItem) {
+	if h.webhooks == nil || urlItem.ExpiryNotified {
+		return
+	}
+	if _, err := h.db.MarkExpiryNotified(ctx, urlItem.ShortCode); err != nil {
+		logger.Warn("Failed to record expiry notification", map[string]interface{}{
+			"shortCode": urlItem.ShortCode,
+			"error":     err.Error(),
+		})
+	}
+}
+
+// validateWebhook checks a subscription request's URL, events and secret
+func validateWebhook(webhookReq *model.WebhookRequest) error {
+	if !utils.IsHTTPURL(webhookReq.URL) {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, eventType := range webhookReq.Events {
+		if !slices.Contains(webhook.EventTypes, eventType) {
+			return fmt.Errorf("events must be among %s", strings.Join(webhook.EventTypes, ", "))
+		}
+	}
+	if webhookReq.Secret != "" && len(webhookReq.Secret) < minWebhookSecretLength {
+		return fmt.Errorf("secret must be at least %d characters", minWebhookSecretLength)
+	}
+	return nil
+}
+
+// webhookID extracts the subscription ID from /webhooks/{id}{suffix}
+func webhookID(req events.LambdaFunctionURLRequest, suffix string) (string, events.LambdaFunctionURLResponse, bool) {
+	id := strings.TrimSuffix(strings.TrimPrefix(req.RawPath, "/webhooks/"), suffix)
+	if id == "" || id == req.RawPath || strings.Contains(id, "/") {
+		return "", events.LambdaFunctionURLResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error": "Webhook ID is required"}`,
+		}, false
+	}
+	return id, events.LambdaFunctionURLResponse{}, true
+}
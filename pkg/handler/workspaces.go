@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// requestHost returns the host a visitor used, without a port. Behind a CDN
+// that cannot pass Host through to the function, X-Forwarded-Host carries
+// it, but clients can set that header too, so it is only trusted on
+// requests from the CDN.
+func requestHost(req events.LambdaFunctionURLRequest) string {
+	var host string
+	if fromTrustedProxy(req) {
+		host = headerValue(req, "x-forwarded-host")
+	}
+	if host == "" {
+		host = headerValue(req, "host")
+	}
+	if host == "" {
+		host = req.RequestContext.DomainName
+	}
+	host, _, _ = strings.Cut(host, ",")
+	host = strings.ToLower(strings.TrimSpace(host))
+	if withoutPort, _, err := net.SplitHostPort(host); err == nil {
+		host = withoutPort
+	}
+	return host
+}
+
+// fromTrustedProxy reports whether a request came through the CDN, which
+// sends FORWARDED_HOST_SECRET in X-Origin-Verify
+func fromTrustedProxy(req events.LambdaFunctionURLRequest) bool {
+	secret := os.Getenv("FORWARDED_HOST_SECRET")
+	return secret != "" && subtle.ConstantTimeCompare([]byte(headerValue(req, "x-origin-verify")), []byte(secret)) == 1
+}
+
+// linkKey returns the stored key of the link a code names on the requested
+// host: the code alone, unless the host is a workspace domain
+func (h *Handler) linkKey(req events.LambdaFunctionURLRequest, code string) string {
+	host := requestHost(req)
+	if h.workspaces.ForDomain(host) == nil {
+		return code
+	}
+	return database.LinkKey(host, code)
+}
+
+// linkShortURL builds the public short URL of a stored link key. Links on
+// a workspace domain always use that domain.
+func linkShortURL(req events.LambdaFunctionURLRequest, key string) string {
+	domain, code := database.SplitLinkKey(key)
+	if domain == "" {
+		return shortURLFor(req, code)
+	}
+	return fmt.Sprintf("https://%s/%s", domain, code)
+}
+
+// linkDomain returns the workspace domain of a link, or "" for the default
+// domain
+func linkDomain(urlItem *model.URLItem) string {
+	domain, _ := database.SplitLinkKey(urlItem.ShortCode)
+	return domain
+}
+
+// shortenDomain picks the domain of a new link. Callers in a workspace get
+// its first domain unless they ask for another of its domains; the admin
+// may use any workspace's domain. Without a workspace, links use the
+// default domain, returned as "".
+func (h *Handler) shortenDomain(req events.LambdaFunctionURLRequest, owner, requested string) (string, events.LambdaFunctionURLResponse, bool) {
+	requested = strings.ToLower(strings.TrimSpace(requested))
+	ws := h.workspaces.ForOwner(owner)
+	if requested == "" {
+		if ws == nil {
+			return "", events.LambdaFunctionURLResponse{}, true
+		}
+		return ws.Domains[0], events.LambdaFunctionURLResponse{}, true
+	}
+
+	if ws != nil && slices.Contains(ws.Domains, requested) {
+		return requested, events.LambdaFunctionURLResponse{}, true
+	}
+	if owner == "" && isAdmin(req) && h.workspaces.ForDomain(requested) != nil {
+		return requested, events.LambdaFunctionURLResponse{}, true
+	}
+
+	logger.Warn("Rejected domain outside the caller's workspace", map[string]interface{}{
+		"domain": requested,
+		"owner":  owner,
+	})
+	return "", events.LambdaFunctionURLResponse{
+		StatusCode: http.StatusForbidden,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: `{"error": "Domain does not belong to your workspace", "code": "domain_not_allowed"}`,
+	}, false
+}
@@ -3,6 +3,7 @@ package logger
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"time"
@@ -31,6 +32,16 @@ type LogEntry struct {
 	Data      interface{} `json:"data,omitempty"`
 }
 
+// output is where log entries are written (stdout by default so Lambda
+// forwards them to CloudWatch)
+var output io.Writer = os.Stdout
+
+// SetOutput changes the destination of log entries. Command line tools use
+// this to keep logs out of data written to stdout.
+func SetOutput(w io.Writer) {
+	output = w
+}
+
 // log creates and outputs a log entry
 func log(level LogLevel, message string, data interface{}) {
 	// Get caller information
@@ -61,8 +72,8 @@ func log(level LogLevel, message string, data interface{}) {
 		return
 	}
 
-	// Print to stdout (Lambda will capture this for CloudWatch)
-	fmt.Println(string(jsonBytes))
+	// Print to the configured output (Lambda will capture stdout for CloudWatch)
+	fmt.Fprintln(output, string(jsonBytes))
 
 	// If fatal, exit the program
 	if level == FATAL {
@@ -7,17 +7,173 @@ type URLItem struct {
 	CreatedAt   string `json:"createdAt" dynamodbav:"createdAt"`
 	Expiration  int64  `json:"expiration,omitempty" dynamodbav:"expiration,omitempty"`
 	ClickCount  int    `json:"clickCount" dynamodbav:"clickCount"`
+	Disabled    bool   `json:"disabled,omitempty" dynamodbav:"disabled,omitempty"`
+	ActiveFrom  int64  `json:"activeFrom,omitempty" dynamodbav:"activeFrom,omitempty"`
+	FallbackURL string `json:"fallbackURL,omitempty" dynamodbav:"fallbackURL,omitempty"`
+	MaxClicks   int    `json:"maxClicks,omitempty" dynamodbav:"maxClicks,omitempty"`
+
+	// HTTP status used for the redirect (301, 302, 307 or 308); 0 uses the default
+	RedirectType int `json:"redirectType,omitempty" dynamodbav:"redirectType,omitempty"`
+
+	// Passthrough of the incoming request: query forwarding mode ("merge" or
+	// "override") and whether a path suffix after the code is appended
+	ForwardQuery string `json:"forwardQuery,omitempty" dynamodbav:"forwardQuery,omitempty"`
+	ForwardPath  bool   `json:"forwardPath,omitempty" dynamodbav:"forwardPath,omitempty"`
+
+	// Campaign groups links for reporting and is indexed for lookups
+	Campaign string `json:"campaign,omitempty" dynamodbav:"campaign,omitempty"`
+
+	// Owner is the team or user the link belongs to, for per-owner reporting
+	Owner string `json:"owner,omitempty" dynamodbav:"owner,omitempty"`
+
+	// Private links get long codes that cannot be guessed. Stats are shown to
+	// the admin, the owner, or whoever holds the token hashed here.
+	Private        bool   `json:"private,omitempty" dynamodbav:"private,omitempty"`
+	StatsTokenHash string `json:"statsTokenHash,omitempty" dynamodbav:"statsTokenHash,omitempty"`
+
+	// Open Graph overrides served to link unfurlers such as Slackbot
+	OGTitle       string `json:"ogTitle,omitempty" dynamodbav:"ogTitle,omitempty"`
+	OGDescription string `json:"ogDescription,omitempty" dynamodbav:"ogDescription,omitempty"`
+	OGImage       string `json:"ogImage,omitempty" dynamodbav:"ogImage,omitempty"`
+
+	// Device routing: the first matching rule picks the destination, and
+	// clicks are counted per rule name ("default" when none matched)
+	Rules      []RoutingRule  `json:"rules,omitempty" dynamodbav:"rules,omitempty"`
+	RuleClicks map[string]int `json:"ruleClicks,omitempty" dynamodbav:"ruleClicks,omitempty"`
+
+	// Geo targeting: destinations keyed by ISO country code, and clicks
+	// counted per visitor country
+	Geo           map[string]string `json:"geo,omitempty" dynamodbav:"geo,omitempty"`
+	CountryClicks map[string]int    `json:"countryClicks,omitempty" dynamodbav:"countryClicks,omitempty"`
+
+	// A/B testing: weighted destinations that replace OriginalURL, picked at
+	// random or by hashing the visitor when sticky, with clicks per variant
+	Variants      []Variant      `json:"variants,omitempty" dynamodbav:"variants,omitempty"`
+	Sticky        bool           `json:"sticky,omitempty" dynamodbav:"sticky,omitempty"`
+	VariantClicks map[string]int `json:"variantClicks,omitempty" dynamodbav:"variantClicks,omitempty"`
+
+	// Schedule: time windows that each send visitors to their own URL, and
+	// the IANA time zone the windows were written in
+	Schedule         []ScheduleWindow `json:"schedule,omitempty" dynamodbav:"schedule,omitempty"`
+	ScheduleTimezone string           `json:"scheduleTimezone,omitempty" dynamodbav:"scheduleTimezone,omitempty"`
+
+	// Show a "you are leaving" page instead of redirecting straight away
+	Interstitial bool `json:"interstitial,omitempty" dynamodbav:"interstitial,omitempty"`
+
+	// Descriptive details; tags are also written to the tag index table
+	Title       string            `json:"title,omitempty" dynamodbav:"title,omitempty"`
+	Description string            `json:"description,omitempty" dynamodbav:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty" dynamodbav:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty" dynamodbav:"metadata,omitempty"`
+
+	// Password protection: a bcrypt hash. Failed unlocks are tracked per
+	// client in the aggregates table.
+	PasswordHash string `json:"passwordHash,omitempty" dynamodbav:"passwordHash,omitempty"`
+
+	// Set once a visit finds the link expired, for the link.expired event
+	ExpiryNotified bool `json:"expiryNotified,omitempty" dynamodbav:"expiryNotified,omitempty"`
+}
+
+// RoutingRule sends visitors matching every condition it sets to URL.
+// Conditions use the values recognised by the useragent package.
+type RoutingRule struct {
+	Name    string `json:"name,omitempty" dynamodbav:"name,omitempty"`
+	OS      string `json:"os,omitempty" dynamodbav:"os,omitempty"`
+	Device  string `json:"device,omitempty" dynamodbav:"device,omitempty"`
+	Browser string `json:"browser,omitempty" dynamodbav:"browser,omitempty"`
+	URL     string `json:"url" dynamodbav:"url"`
+}
+
+// Variant is one of a link's weighted destinations. A variant is picked
+// with probability Weight divided by the sum of all weights.
+type Variant struct {
+	Name   string `json:"name,omitempty" dynamodbav:"name,omitempty"`
+	URL    string `json:"url" dynamodbav:"url"`
+	Weight int    `json:"weight" dynamodbav:"weight"`
+}
+
+// ScheduleWindow sends visitors to URL from From (inclusive) until To
+// (exclusive), both Unix timestamps. A zero From or To leaves the window
+// open at that end.
+type ScheduleWindow struct {
+	From int64  `json:"from,omitempty" dynamodbav:"from,omitempty"`
+	To   int64  `json:"to,omitempty" dynamodbav:"to,omitempty"`
+	URL  string `json:"url" dynamodbav:"url"`
+}
+
+// Schedule is a link's schedule as written in API requests and responses.
+// Window times are RFC 3339 timestamps or local times such as
+// "2026-11-01T09:00" in Timezone.
+type Schedule struct {
+	Timezone string                `json:"timezone,omitempty"`
+	Windows  []ScheduleWindowTimes `json:"windows"`
+}
+
+// ScheduleWindowTimes is a ScheduleWindow with times written as text
+type ScheduleWindowTimes struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	URL  string `json:"url"`
 }
 
 // ShortenRequest represents the request body for creating a new short URL
 type ShortenRequest struct {
 	URL          string `json:"url"`
 	ExpireInDays int    `json:"expire_in_days,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	ExpireIn     string `json:"expire_in,omitempty"`
+	ActiveFrom   string `json:"active_from,omitempty"`
+	FallbackURL  string `json:"fallback_url,omitempty"`
+	MaxClicks    int    `json:"max_clicks,omitempty"`
+	Password     string `json:"password,omitempty"`
+	RedirectType int    `json:"redirect_type,omitempty"`
+	ForwardQuery string `json:"forward_query,omitempty"`
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+	UTM          *UTM   `json:"utm,omitempty"`
+	Campaign     string `json:"campaign,omitempty"`
+	Interstitial bool   `json:"interstitial,omitempty"`
+	Private      bool   `json:"private,omitempty"`
+	Domain       string `json:"domain,omitempty"`
+
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
+
+	Rules []RoutingRule     `json:"rules,omitempty"`
+	Geo   map[string]string `json:"geo,omitempty"`
+
+	Variants []Variant `json:"variants,omitempty"`
+	Sticky   bool      `json:"sticky,omitempty"`
+
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// UTM holds the standard campaign tracking parameters added to a destination
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
 }
 
 // ShortenResponse represents the response for creating a new short URL
 type ShortenResponse struct {
 	ShortURL string `json:"short_url"`
+	QRURL    string `json:"qr_url"`
+	// Sent as X-Stats-Token to read the link's stats; only returned once
+	StatsToken string `json:"stats_token"`
+}
+
+// StatsTokenResponse returns a stats token issued for an existing link
+type StatsTokenResponse struct {
+	ShortCode  string `json:"short_code"`
+	StatsToken string `json:"stats_token"`
 }
 
 // StatsResponse represents the analytics response for a short URL
@@ -26,4 +182,196 @@ type StatsResponse struct {
 	CreatedAt   string `json:"created_at"`
 	Expiration  int64  `json:"expiration,omitempty"`
 	ClickCount  int    `json:"click_count"`
+	Disabled    bool   `json:"disabled,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	ActiveFrom  string `json:"active_from,omitempty"`
+	Expired     bool   `json:"expired,omitempty"`
+	FallbackURL string `json:"fallback_url,omitempty"`
+	MaxClicks   int    `json:"max_clicks,omitempty"`
+
+	RedirectType      int    `json:"redirect_type"`
+	ForwardQuery      string `json:"forward_query,omitempty"`
+	ForwardPath       bool   `json:"forward_path,omitempty"`
+	PasswordProtected bool   `json:"password_protected,omitempty"`
+	Campaign          string `json:"campaign,omitempty"`
+	Interstitial      bool   `json:"interstitial,omitempty"`
+	Private           bool   `json:"private,omitempty"`
+	Domain            string `json:"domain,omitempty"`
+	Owner             string `json:"owner,omitempty"`
+
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
+
+	Rules      []RoutingRule  `json:"rules,omitempty"`
+	RuleClicks map[string]int `json:"rule_clicks,omitempty"`
+
+	Geo           map[string]string `json:"geo,omitempty"`
+	CountryClicks map[string]int    `json:"country_clicks,omitempty"`
+
+	Sticky   bool           `json:"sticky,omitempty"`
+	Variants []VariantStats `json:"variants,omitempty"`
+
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// VariantStats reports a variant's clicks and its share of all variant clicks
+type VariantStats struct {
+	Name   string  `json:"name"`
+	URL    string  `json:"url"`
+	Weight int     `json:"weight"`
+	Clicks int     `json:"clicks"`
+	Share  float64 `json:"share"`
+}
+
+// CampaignStatsResponse adds up the analytics of every link in a campaign
+type CampaignStatsResponse struct {
+	Campaign   string              `json:"campaign"`
+	LinkCount  int                 `json:"link_count"`
+	ClickCount int                 `json:"click_count"`
+	Links      []CampaignLinkStats `json:"links"`
+}
+
+// CampaignLinkStats is the per-link breakdown in a CampaignStatsResponse
+type CampaignLinkStats struct {
+	ShortCode   string `json:"short_code"`
+	OriginalURL string `json:"original_url"`
+	ClickCount  int    `json:"click_count"`
+}
+
+// LinkResponse describes a link in the link management API
+type LinkResponse struct {
+	ShortCode   string            `json:"short_code"`
+	Domain      string            `json:"domain,omitempty"`
+	OriginalURL string            `json:"original_url"`
+	CreatedAt   string            `json:"created_at"`
+	ClickCount  int               `json:"click_count"`
+	Disabled    bool              `json:"disabled,omitempty"`
+	Private     bool              `json:"private,omitempty"`
+	Campaign    string            `json:"campaign,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+
+	OGTitle       string `json:"og_title,omitempty"`
+	OGDescription string `json:"og_description,omitempty"`
+	OGImage       string `json:"og_image,omitempty"`
+
+	Rules []RoutingRule     `json:"rules,omitempty"`
+	Geo   map[string]string `json:"geo,omitempty"`
+
+	Variants []Variant `json:"variants,omitempty"`
+	Sticky   bool      `json:"sticky,omitempty"`
+
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// LinkListResponse is the result of searching links by tag
+type LinkListResponse struct {
+	Tag   string         `json:"tag"`
+	Links []LinkResponse `json:"links"`
+}
+
+// LinkUpdateRequest is the body of PATCH /links/{code}. Fields left out are
+// unchanged; an empty value clears the field.
+type LinkUpdateRequest struct {
+	Title       *string            `json:"title,omitempty"`
+	Description *string            `json:"description,omitempty"`
+	Tags        *[]string          `json:"tags,omitempty"`
+	Metadata    *map[string]string `json:"metadata,omitempty"`
+
+	OGTitle       *string `json:"og_title,omitempty"`
+	OGDescription *string `json:"og_description,omitempty"`
+	OGImage       *string `json:"og_image,omitempty"`
+
+	Rules *[]RoutingRule     `json:"rules,omitempty"`
+	Geo   *map[string]string `json:"geo,omitempty"`
+
+	Variants *[]Variant `json:"variants,omitempty"`
+	Sticky   *bool      `json:"sticky,omitempty"`
+
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// Webhook is a subscription to link events, stored in the webhook table.
+// Payloads are signed with Secret; an empty Events list receives every event.
+type Webhook struct {
+	ID        string   `json:"id" dynamodbav:"id"`
+	URL       string   `json:"url" dynamodbav:"url"`
+	Secret    string   `json:"secret,omitempty" dynamodbav:"secret"`
+	Events    []string `json:"events,omitempty" dynamodbav:"events,omitempty"`
+	CreatedAt string   `json:"created_at" dynamodbav:"createdAt"`
+}
+
+// WebhookRequest is the body of POST /webhooks. A secret is generated when
+// none is given.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// WebhookListResponse lists webhook subscriptions without their secrets
+type WebhookListResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook.
+// Delivery IDs sort by time, and Expiration lets DynamoDB TTL prune the log.
+type WebhookDelivery struct {
+	WebhookID  string `json:"webhook_id" dynamodbav:"webhookId"`
+	DeliveryID string `json:"delivery_id" dynamodbav:"deliveryId"`
+	EventID    string `json:"event_id" dynamodbav:"eventId"`
+	EventType  string `json:"event_type" dynamodbav:"eventType"`
+	Attempt    int    `json:"attempt" dynamodbav:"attempt"`
+	StatusCode int    `json:"status_code,omitempty" dynamodbav:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	Success    bool   `json:"success" dynamodbav:"success"`
+	DurationMs int64  `json:"duration_ms" dynamodbav:"durationMs"`
+	CreatedAt  string `json:"created_at" dynamodbav:"createdAt"`
+	Expiration int64  `json:"-" dynamodbav:"expiration,omitempty"`
+}
+
+// WebhookDeliveryListResponse is the delivery log of a webhook, newest first
+type WebhookDeliveryListResponse struct {
+	WebhookID  string            `json:"webhook_id"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// Quota caps the links an owner may create each calendar month (UTC) and
+// the links it may hold at once. A limit of 0 means no cap.
+type Quota struct {
+	Plan         string `json:"plan,omitempty"`
+	MonthlyLinks int    `json:"monthly_links,omitempty"`
+	ActiveLinks  int    `json:"active_links,omitempty"`
+}
+
+// UsageResponse reports an owner's consumption of its quotas
+type UsageResponse struct {
+	Owner        string       `json:"owner"`
+	Plan         string       `json:"plan,omitempty"`
+	Month        string       `json:"month"`
+	MonthlyLinks UsageCounter `json:"monthly_links"`
+	ActiveLinks  UsageCounter `json:"active_links"`
+}
+
+// UsageCounter is the use of one quota; Limit is left out when there is no
+// cap
+type UsageCounter struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit,omitempty"`
+}
+
+// Workspace groups owners with the short domains their links may use, such
+// as go.brand-a.com. The first domain is the default for new links.
+type Workspace struct {
+	Name    string   `json:"name"`
+	Domains []string `json:"domains"`
+	Owners  []string `json:"owners"`
 }
\ No newline at end of file
@@ -21,6 +21,11 @@ const (
 	MetricURLCreated        = "URLCreated"
 	MetricURLRedirected     = "URLRedirected"
 	MetricURLNotFound       = "URLNotFound"
+	MetricLookupsBlocked    = "LookupsBlocked"
+	MetricURLExpired        = "URLExpired"
+	MetricFallbackRedirect  = "FallbackRedirect"
+	MetricClickLimitReached = "ClickLimitReached"
+	MetricUnfurlServed      = "UnfurlServed"
 	MetricURLStatsRetrieved = "URLStatsRetrieved"
 	MetricDynamoDBError     = "DynamoDBError"
 	MetricAPILatency        = "APILatency"
@@ -30,6 +35,8 @@ const (
 const (
 	DimensionOperation = "Operation"
 	DimensionEndpoint  = "Endpoint"
+	DimensionReason    = "Reason"
+	DimensionAgent     = "Agent"
 )
 
 // Client is a wrapper for CloudWatch client
@@ -105,6 +112,47 @@ func (c *Client) RecordURLNotFound(ctx context.Context) error {
 	})
 }
 
+// RecordLookupsBlocked records a client being blocked after looking up too
+// many unknown short codes
+func (c *Client) RecordLookupsBlocked(ctx context.Context) error {
+	return c.PutMetric(ctx, MetricLookupsBlocked, 1.0, types.Dimension{
+		Name:  aws.String(DimensionOperation),
+		Value: aws.String("LookupURL"),
+	})
+}
+
+// RecordURLExpired records a redirect attempt for an expired URL
+func (c *Client) RecordURLExpired(ctx context.Context) error {
+	return c.PutMetric(ctx, MetricURLExpired, 1.0, types.Dimension{
+		Name:  aws.String(DimensionOperation),
+		Value: aws.String("RedirectURL"),
+	})
+}
+
+// RecordClickLimitReached records a redirect refused because of max_clicks
+func (c *Client) RecordClickLimitReached(ctx context.Context) error {
+	return c.PutMetric(ctx, MetricClickLimitReached, 1.0, types.Dimension{
+		Name:  aws.String(DimensionOperation),
+		Value: aws.String("RedirectURL"),
+	})
+}
+
+// RecordUnfurlServed records an Open Graph document served to a link unfurler
+func (c *Client) RecordUnfurlServed(ctx context.Context, agent string) error {
+	return c.PutMetric(ctx, MetricUnfurlServed, 1.0, types.Dimension{
+		Name:  aws.String(DimensionAgent),
+		Value: aws.String(agent),
+	})
+}
+
+// RecordFallbackRedirect records a redirect to a fallback destination
+func (c *Client) RecordFallbackRedirect(ctx context.Context, reason string) error {
+	return c.PutMetric(ctx, MetricFallbackRedirect, 1.0, types.Dimension{
+		Name:  aws.String(DimensionReason),
+		Value: aws.String(reason),
+	})
+}
+
 // RecordURLStatsRetrieved records a URL stats retrieval event
 func (c *Client) RecordURLStatsRetrieved(ctx context.Context) error {
 	return c.PutMetric(ctx, MetricURLStatsRetrieved, 1.0, types.Dimension{
@@ -0,0 +1,187 @@
+// Package qr renders QR codes for short links as PNG or SVG images
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Format is the image format of a rendered QR code
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+// Limits and defaults for rendering options
+const (
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+// Options controls how a QR code is rendered
+type Options struct {
+	Format Format
+	// Width and height of the image in pixels
+	Size int
+	// Quiet zone around the code, in modules
+	Margin int
+	// Error correction level: L, M, Q or H
+	ECC string
+}
+
+// DefaultOptions returns the options used when a request sets none
+func DefaultOptions() Options {
+	return Options{
+		Format: FormatPNG,
+		Size:   DefaultSize,
+		Margin: DefaultMargin,
+		ECC:    "M",
+	}
+}
+
+// ParseOptions reads rendering options from query parameters, falling back
+// to DefaultOptions for any that are missing
+func ParseOptions(params map[string]string) (Options, error) {
+	opts := DefaultOptions()
+
+	if format := strings.ToLower(params["format"]); format != "" {
+		opts.Format = Format(format)
+		if opts.Format != FormatPNG && opts.Format != FormatSVG {
+			return opts, fmt.Errorf("format must be png or svg")
+		}
+	}
+	if size := params["size"]; size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < MinSize || n > MaxSize {
+			return opts, fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+		}
+		opts.Size = n
+	}
+	if margin := params["margin"]; margin != "" {
+		n, err := strconv.Atoi(margin)
+		if err != nil || n < 0 || n > MaxMargin {
+			return opts, fmt.Errorf("margin must be between 0 and %d", MaxMargin)
+		}
+		opts.Margin = n
+	}
+	if ecc := strings.ToUpper(params["ecc"]); ecc != "" {
+		if _, err := recoveryLevel(ecc); err != nil {
+			return opts, err
+		}
+		opts.ECC = ecc
+	}
+	return opts, nil
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Render encodes content as a QR code image
+func Render(content string, opts Options) ([]byte, error) {
+	level, err := recoveryLevel(opts.ECC)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	modules := withMargin(code.Bitmap(), opts.Margin)
+
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts.Size), nil
+	}
+	return renderPNG(modules, opts.Size)
+}
+
+// recoveryLevel maps an ECC letter to the encoder's recovery level
+func recoveryLevel(ecc string) (qrcode.RecoveryLevel, error) {
+	switch ecc {
+	case "L":
+		return qrcode.Low, nil
+	case "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, fmt.Errorf("ecc must be L, M, Q or H")
+}
+
+// withMargin surrounds the module grid with margin light modules on each side
+func withMargin(bitmap [][]bool, margin int) [][]bool {
+	total := len(bitmap) + 2*margin
+	modules := make([][]bool, total)
+	for y := range modules {
+		modules[y] = make([]bool, total)
+	}
+	for y, row := range bitmap {
+		copy(modules[y+margin][margin:], row)
+	}
+	return modules
+}
+
+// renderPNG draws the modules into a size x size two-colour PNG
+func renderPNG(modules [][]bool, size int) ([]byte, error) {
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	total := len(modules)
+	for y := 0; y < size; y++ {
+		row := modules[y*total/size]
+		for x := 0; x < size; x++ {
+			if row[x*total/size] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderSVG draws the modules as a single path, one rectangle per run of
+// dark modules in a row
+func renderSVG(modules [][]bool, size int) []byte {
+	total := len(modules)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+	for y, row := range modules {
+		for x := 0; x < total; x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < total && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
@@ -0,0 +1,76 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions(nil)
+	if err != nil || opts != DefaultOptions() {
+		t.Errorf("Expected default options, got %+v %v", opts, err)
+	}
+
+	opts, err = ParseOptions(map[string]string{"format": "SVG", "size": "512", "margin": "0", "ecc": "h"})
+	if err != nil {
+		t.Fatalf("ParseOptions returned an error: %v", err)
+	}
+	if opts.Format != FormatSVG || opts.Size != 512 || opts.Margin != 0 || opts.ECC != "H" {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+
+	invalid := []map[string]string{
+		{"format": "gif"},
+		{"size": "10"},
+		{"size": "big"},
+		{"margin": "-1"},
+		{"margin": "17"},
+		{"ecc": "X"},
+	}
+	for _, params := range invalid {
+		if _, err := ParseOptions(params); err == nil {
+			t.Errorf("Expected an error for %v", params)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	// Test PNG output at the requested size
+	opts := DefaultOptions()
+	opts.Size = 300
+	data, err := Render("https://sho.rt/aB3xY", opts)
+	if err != nil {
+		t.Fatalf("Render returned an error: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Render produced an invalid PNG: %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 300 || bounds.Dy() != 300 {
+		t.Errorf("Expected a 300x300 image, got %v", bounds)
+	}
+
+	// The quiet zone is light and the finder pattern corner is dark. The URL
+	// needs a version 2 code: 25 modules plus the margin on each side.
+	total := 25 + 2*DefaultMargin
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Errorf("Expected a light margin")
+	}
+	corner := (2*DefaultMargin + 1) * 300 / (2 * total)
+	if r, _, _, _ := img.At(corner, corner).RGBA(); r != 0 {
+		t.Errorf("Expected the finder pattern to be dark")
+	}
+
+	// Test SVG output
+	opts.Format = FormatSVG
+	data, err = Render("https://sho.rt/aB3xY", opts)
+	if err != nil {
+		t.Fatalf("Render returned an error: %v", err)
+	}
+	svg := string(data)
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, `width="300"`) || !strings.Contains(svg, `viewBox="0 0 33 33"`) {
+		t.Errorf("Unexpected SVG: %.200s", svg)
+	}
+}
@@ -0,0 +1,125 @@
+// Package quota decides how many links each owner may create per month and
+// hold at once. Quotas come from named plans, which owners are assigned to,
+// or are set for an owner directly.
+package quota
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// Plans maps owners to their quotas
+type Plans struct {
+	plans    map[string]model.Quota
+	owners   map[string]model.Quota
+	fallback model.Quota
+}
+
+// ParsePlans reads plans written as "name=monthly/active" separated by
+// commas, such as "free=100/500,pro=10000/0", where 0 means no cap. Owners
+// are written as "owner=plan" or "owner=monthly/active". Owners not listed
+// get defaultPlan, or no quota when it is empty.
+func ParsePlans(plans, owners, defaultPlan string) (*Plans, error) {
+	p := &Plans{plans: map[string]model.Quota{}, owners: map[string]model.Quota{}}
+
+	err := parseEntries(plans, func(name, value string) error {
+		quota, err := parseQuota(value)
+		if err != nil {
+			return fmt.Errorf("plan %s: %w", name, err)
+		}
+		quota.Plan = name
+		p.plans[name] = quota
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = parseEntries(owners, func(owner, value string) error {
+		if quota, ok := p.plans[value]; ok {
+			p.owners[owner] = quota
+			return nil
+		}
+		quota, err := parseQuota(value)
+		if err != nil {
+			return fmt.Errorf("owner %s: %q is neither a plan nor monthly/active", owner, value)
+		}
+		p.owners[owner] = quota
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if defaultPlan != "" {
+		quota, ok := p.plans[defaultPlan]
+		if !ok {
+			return nil, fmt.Errorf("default plan %q is not defined", defaultPlan)
+		}
+		p.fallback = quota
+	}
+	return p, nil
+}
+
+// parseEntries calls fn with the name and value of each "name=value" entry
+// in a comma separated list
+func parseEntries(spec string, fn func(name, value string) error) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return fmt.Errorf("quota entry %q must look like name=value", entry)
+		}
+		if err := fn(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseQuota reads "monthly/active"
+func parseQuota(value string) (model.Quota, error) {
+	monthly, active, ok := strings.Cut(value, "/")
+	if !ok {
+		return model.Quota{}, fmt.Errorf("%q must look like monthly/active", value)
+	}
+
+	var quota model.Quota
+	var err error
+	if quota.MonthlyLinks, err = strconv.Atoi(monthly); err != nil || quota.MonthlyLinks < 0 {
+		return model.Quota{}, fmt.Errorf("monthly links must be a number of 0 or more, got %q", monthly)
+	}
+	if quota.ActiveLinks, err = strconv.Atoi(active); err != nil || quota.ActiveLinks < 0 {
+		return model.Quota{}, fmt.Errorf("active links must be a number of 0 or more, got %q", active)
+	}
+	return quota, nil
+}
+
+// For returns an owner's quota. Without plans nobody has a quota.
+func (p *Plans) For(owner string) model.Quota {
+	if p == nil {
+		return model.Quota{}
+	}
+	if quota, ok := p.owners[owner]; ok {
+		return quota
+	}
+	return p.fallback
+}
+
+// FromEnv builds plans from QUOTA_PLANS, OWNER_QUOTAS and QUOTA_DEFAULT_PLAN
+// (see ParsePlans). It returns nil when none of them is set.
+func FromEnv() (*Plans, error) {
+	plans, owners, defaultPlan := os.Getenv("QUOTA_PLANS"), os.Getenv("OWNER_QUOTAS"), os.Getenv("QUOTA_DEFAULT_PLAN")
+	if plans == "" && owners == "" && defaultPlan == "" {
+		return nil, nil
+	}
+	return ParsePlans(plans, owners, defaultPlan)
+}
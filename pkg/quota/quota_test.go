@@ -0,0 +1,43 @@
+package quota
+
+import (
+	"testing"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+func TestParsePlans(t *testing.T) {
+	plans, err := ParsePlans("free=100/500, pro=10000/0", "team-a=pro, team-b=5/10", "free")
+	if err != nil {
+		t.Fatalf("ParsePlans returned an error: %v", err)
+	}
+
+	// Test owners get their plan, their own quota or the default plan
+	if quota := plans.For("team-a"); quota != (model.Quota{Plan: "pro", MonthlyLinks: 10000}) {
+		t.Errorf("Unexpected quota for team-a: %+v", quota)
+	}
+	if quota := plans.For("team-b"); quota != (model.Quota{MonthlyLinks: 5, ActiveLinks: 10}) {
+		t.Errorf("Unexpected quota for team-b: %+v", quota)
+	}
+	if quota := plans.For("team-c"); quota != (model.Quota{Plan: "free", MonthlyLinks: 100, ActiveLinks: 500}) {
+		t.Errorf("Expected team-c to get the free plan, got %+v", quota)
+	}
+
+	// Test nobody has a quota without plans
+	var none *Plans
+	if quota := none.For("team-a"); quota != (model.Quota{}) {
+		t.Errorf("Expected no quota without plans, got %+v", quota)
+	}
+
+	for _, spec := range [][3]string{
+		{"free=100", "", ""},
+		{"free=-1/5", "", ""},
+		{"free=100/500", "team-a=gold", ""},
+		{"free=100/500", "", "pro"},
+		{"=100/500", "", ""},
+	} {
+		if _, err := ParsePlans(spec[0], spec[1], spec[2]); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
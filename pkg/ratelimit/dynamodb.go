@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attempts at updating a bucket that other requests keep changing
+const maxTakeAttempts = 3
+
+// DynamoDBAPI is the part of the DynamoDB client used by DynamoDBStore
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBStore keeps buckets in a DynamoDB table with a string partition
+// key "key". Each item holds "tokens", "updatedAt" in Unix milliseconds,
+// "version", which every write raises by one, and "expiration", for TTL,
+// set to when the bucket would be full again.
+type DynamoDBStore struct {
+	client    DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore creates a store for the given table
+func NewDynamoDBStore(client DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+// Take reads the bucket, takes a token and writes it back on condition that
+// nobody else updated it in between, trying again if they did. Writes are
+// told apart by the bucket's version rather than its updatedAt, which two
+// writes in the same millisecond would share. Every
+// attempt refills the bucket up to the time it is made. A bucket that keeps
+// changing is under a burst of requests, so once the attempts run out the
+// request is refused rather than let through.
+func (s *DynamoDBStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		bucket, version, err := s.get(ctx, key)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		now := now.Add(time.Since(start))
+
+		bucket, decision := Take(bucket, limit, now)
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(s.tableName),
+			Item: map[string]types.AttributeValue{
+				"key":        &types.AttributeValueMemberS{Value: key},
+				"tokens":     &types.AttributeValueMemberN{Value: strconv.FormatFloat(bucket.Tokens, 'f', -1, 64)},
+				"updatedAt":  &types.AttributeValueMemberN{Value: strconv.FormatInt(bucket.UpdatedAt.UnixMilli(), 10)},
+				"version":    &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)},
+				"expiration": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(decision.Reset).Unix()+1, 10)},
+			},
+		}
+		// Version 0 is a bucket that does not exist yet, or one written
+		// before buckets had versions
+		if version == 0 {
+			input.ConditionExpression = aws.String("attribute_not_exists(version)")
+		} else {
+			input.ConditionExpression = aws.String("version = :version")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+			}
+		}
+
+		_, err = s.client.PutItem(ctx, input)
+		if err == nil {
+			return decision, nil
+		}
+		var condErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &condErr) {
+			return Decision{}, err
+		}
+		if attempt == maxTakeAttempts {
+			return contendedDecision(limit), nil
+		}
+	}
+}
+
+// contendedDecision refuses a request whose bucket kept changing, asking
+// the client to wait for one token's worth of refill
+func contendedDecision(limit Limit) Decision {
+	decision := decide(0, limit)
+	decision.Allowed = false
+	return decision
+}
+
+// Peek reads the bucket without taking a token
+func (s *DynamoDBStore) Peek(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	bucket, _, err := s.get(ctx, key)
+	if err != nil {
+		return Decision{}, err
+	}
+	return Peek(bucket, limit, now), nil
+}
+
+// get reads the bucket under key along with its version, which is 0 when
+// there is no bucket yet
+func (s *DynamoDBStore) get(ctx context.Context, key string) (Bucket, int64, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Bucket{}, 0, err
+	}
+
+	var bucket Bucket
+	updatedAt, ok := result.Item["updatedAt"].(*types.AttributeValueMemberN)
+	if !ok {
+		return bucket, 0, nil
+	}
+	ms, _ := strconv.ParseInt(updatedAt.Value, 10, 64)
+	bucket.UpdatedAt = time.UnixMilli(ms)
+	if tokens, ok := result.Item["tokens"].(*types.AttributeValueMemberN); ok {
+		bucket.Tokens, _ = strconv.ParseFloat(tokens.Value, 64)
+	}
+	var version int64
+	if v, ok := result.Item["version"].(*types.AttributeValueMemberN); ok {
+		version, _ = strconv.ParseInt(v.Value, 10, 64)
+	}
+	return bucket, version, nil
+}
@@ -0,0 +1,314 @@
+// Package ratelimit limits how often a client may call each class of route
+// using token buckets. A bucket holds up to Burst tokens and refills at
+// Requests per Period; every request takes one token.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Route classes with their own limits
+const (
+	ClassShorten  = "shorten"
+	ClassRedirect = "redirect"
+	ClassStats    = "stats"
+	ClassUnlock   = "unlock"
+	ClassAdmin    = "admin"
+	// Lookups of short codes that do not exist, counted per client to
+	// slow down guessing codes
+	ClassNotFound = "notfound"
+)
+
+// DefaultLimits apply to classes RATE_LIMITS does not mention
+var DefaultLimits = map[string]Limit{
+	ClassShorten:  {Requests: 10, Period: time.Minute, Burst: 20},
+	ClassRedirect: {Requests: 300, Period: time.Minute, Burst: 100},
+	ClassStats:    {Requests: 60, Period: time.Minute, Burst: 30},
+	ClassUnlock:   {Requests: 10, Period: time.Minute, Burst: 10},
+	ClassAdmin:    {Requests: 120, Period: time.Minute, Burst: 60},
+	ClassNotFound: {Requests: 30, Period: time.Hour, Burst: 20},
+}
+
+// Limit allows Requests per Period on average, and up to Burst at once
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	// Bucket size; 0 means Requests
+	Burst int
+}
+
+// Capacity returns the bucket size
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// perSecond is the refill rate in tokens per second
+func (l Limit) perSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Decision is the outcome of taking a token
+type Decision struct {
+	Allowed bool
+	Limit   Limit
+	// Whole tokens left after this request
+	Remaining int
+	// How long until a token is available, when the request was refused
+	RetryAfter time.Duration
+	// How long until the bucket is full again
+	Reset time.Duration
+}
+
+// Bucket is the stored state of a token bucket
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Take refills a bucket for the time since it was last updated and takes a
+// token if a whole one is available. A zero bucket is full.
+func Take(bucket Bucket, limit Limit, now time.Time) (Bucket, Decision) {
+	tokens := refill(bucket, limit, now)
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	decision := decide(tokens, limit)
+	decision.Allowed = allowed
+	if allowed {
+		decision.RetryAfter = 0
+	}
+	return Bucket{Tokens: tokens, UpdatedAt: now}, decision
+}
+
+// Peek reports whether a token is available without taking it
+func Peek(bucket Bucket, limit Limit, now time.Time) Decision {
+	tokens := refill(bucket, limit, now)
+	decision := decide(tokens, limit)
+	decision.Allowed = tokens >= 1
+	return decision
+}
+
+// refill returns the tokens in a bucket once refilled up to now
+func refill(bucket Bucket, limit Limit, now time.Time) float64 {
+	capacity := float64(limit.Capacity())
+	if bucket.UpdatedAt.IsZero() {
+		return capacity
+	}
+	elapsed := now.Sub(bucket.UpdatedAt).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(capacity, bucket.Tokens+elapsed*limit.perSecond())
+}
+
+// decide describes a bucket holding tokens
+func decide(tokens float64, limit Limit) Decision {
+	rate := limit.perSecond()
+	decision := Decision{Limit: limit, Remaining: int(tokens)}
+	if tokens < 1 {
+		decision.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	decision.Reset = seconds((float64(limit.Capacity()) - tokens) / rate)
+	return decision
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps token buckets
+type Store interface {
+	// Take atomically applies Take to the bucket under key
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error)
+	// Peek applies Peek to the bucket under key
+	Peek(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error)
+}
+
+// MemoryStore keeps buckets in memory. Each Lambda instance has its own,
+// so it suits tests and local runs rather than production.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	buckets map[string]Bucket
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]Bucket{}}
+}
+
+// Take takes a token from the bucket under key
+func (m *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	bucket, decision := Take(m.buckets[key], limit, now)
+	m.buckets[key] = bucket
+	return decision, nil
+}
+
+// Peek looks at the bucket under key without taking a token
+func (m *MemoryStore) Peek(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return Peek(m.buckets[key], limit, now), nil
+}
+
+// Limiter applies the limit of a route class to a client
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+	now    func() time.Time
+}
+
+// NewLimiter creates a limiter. Classes missing from limits are not limited.
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits, now: time.Now}
+}
+
+// SetClock replaces the limiter's clock, for tests
+func (l *Limiter) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+// Allow takes a token from the client's bucket for a route class. It
+// returns nil if the class is not limited.
+func (l *Limiter) Allow(ctx context.Context, class, client string) (*Decision, error) {
+	limit, ok := l.limits[class]
+	if !ok {
+		return nil, nil
+	}
+	decision, err := l.store.Take(ctx, class+"#"+client, limit, l.now())
+	if err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// Check looks at the client's bucket for a route class without taking a
+// token. It returns nil if the class is not limited.
+func (l *Limiter) Check(ctx context.Context, class, client string) (*Decision, error) {
+	limit, ok := l.limits[class]
+	if !ok {
+		return nil, nil
+	}
+	decision, err := l.store.Peek(ctx, class+"#"+client, limit, l.now())
+	if err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// ParseLimits reads limits written as "class=requests/period[:burst]"
+// separated by commas, such as "shorten=10/m:20,redirect=5/s". The period
+// is a duration or s, m, h or d, and "class=off" removes a class's limit.
+// Classes not mentioned keep their DefaultLimits.
+func ParseLimits(spec string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for class, limit := range DefaultLimits {
+		limits[class] = limit
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(entry, "=")
+		class = strings.TrimSpace(class)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("rate limit %q must look like class=requests/period", entry)
+		}
+		if strings.TrimSpace(value) == "off" {
+			delete(limits, class)
+			continue
+		}
+
+		limit, err := parseLimit(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("rate limit for %s: %w", class, err)
+		}
+		limits[class] = limit
+	}
+	return limits, nil
+}
+
+func parseLimit(value string) (Limit, error) {
+	rate, burst, hasBurst := strings.Cut(value, ":")
+	requests, period, ok := strings.Cut(rate, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%q must look like requests/period", value)
+	}
+
+	var limit Limit
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests < 1 {
+		return Limit{}, fmt.Errorf("requests must be a positive number, got %q", requests)
+	}
+	switch period {
+	case "s":
+		limit.Period = time.Second
+	case "m":
+		limit.Period = time.Minute
+	case "h":
+		limit.Period = time.Hour
+	case "d":
+		limit.Period = 24 * time.Hour
+	default:
+		if limit.Period, err = time.ParseDuration(period); err != nil || limit.Period <= 0 {
+			return Limit{}, fmt.Errorf("period must be s, m, h, d or a duration, got %q", period)
+		}
+	}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return Limit{}, fmt.Errorf("burst must be a positive number, got %q", burst)
+		}
+	}
+	return limit, nil
+}
+
+// FromEnv builds a limiter from RATE_LIMIT_STORE ("dynamodb" or "memory")
+// and RATE_LIMITS (see ParseLimits). The DynamoDB table is named by
+// RATE_LIMIT_TABLE_NAME. It returns nil when RATE_LIMIT_STORE is unset.
+func FromEnv(ctx context.Context) (*Limiter, error) {
+	kind := strings.ToLower(os.Getenv("RATE_LIMIT_STORE"))
+	if kind == "" {
+		return nil, nil
+	}
+	limits, err := ParseLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "memory":
+		return NewLimiter(NewMemoryStore(), limits), nil
+	case "dynamodb":
+		tableName := os.Getenv("RATE_LIMIT_TABLE_NAME")
+		if tableName == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_TABLE_NAME is required for the dynamodb rate limit store")
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewLimiter(NewDynamoDBStore(dynamodb.NewFromConfig(cfg), tableName), limits), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", kind)
+	}
+}
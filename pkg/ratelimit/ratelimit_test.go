@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestTake(t *testing.T) {
+	limit := Limit{Requests: 6, Period: time.Minute, Burst: 3}
+	now := time.Unix(1700000000, 0)
+
+	// Test the burst is allowed and the next request refused
+	var bucket Bucket
+	var decision Decision
+	for i := 0; i < 3; i++ {
+		bucket, decision = Take(bucket, limit, now)
+		if !decision.Allowed || decision.Remaining != 2-i {
+			t.Fatalf("Expected request %d to be allowed with %d left, got %+v", i+1, 2-i, decision)
+		}
+	}
+	bucket, decision = Take(bucket, limit, now)
+	if decision.Allowed || decision.RetryAfter != 10*time.Second || decision.Reset != 30*time.Second {
+		t.Errorf("Expected a refusal with 10s to wait and 30s to full, got %+v", decision)
+	}
+
+	// Test a token comes back every 10 seconds, never beyond the burst
+	if _, decision = Take(bucket, limit, now.Add(10*time.Second)); !decision.Allowed || decision.Remaining != 0 {
+		t.Errorf("Expected one token after 10s, got %+v", decision)
+	}
+	if _, decision = Take(bucket, limit, now.Add(time.Hour)); !decision.Allowed || decision.Remaining != 2 {
+		t.Errorf("Expected a full bucket after an hour, got %+v", decision)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("shorten=5/m:10, redirect=2/s, stats=off, export=100/1h")
+	if err != nil {
+		t.Fatalf("ParseLimits returned an error: %v", err)
+	}
+	if limits[ClassShorten] != (Limit{Requests: 5, Period: time.Minute, Burst: 10}) ||
+		limits[ClassRedirect] != (Limit{Requests: 2, Period: time.Second}) ||
+		limits["export"] != (Limit{Requests: 100, Period: time.Hour}) {
+		t.Errorf("Unexpected limits: %+v", limits)
+	}
+	if _, ok := limits[ClassStats]; ok {
+		t.Errorf("Expected stats=off to remove the stats limit")
+	}
+	if limits[ClassAdmin] != DefaultLimits[ClassAdmin] {
+		t.Errorf("Expected classes not mentioned to keep their default, got %+v", limits[ClassAdmin])
+	}
+
+	for _, spec := range []string{"shorten", "shorten=10", "shorten=0/m", "shorten=10/week", "shorten=10/m:0"} {
+		if _, err := ParseLimits(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewLimiter(NewMemoryStore(), map[string]Limit{
+		ClassShorten: {Requests: 1, Period: time.Minute},
+	})
+	limiter.SetClock(func() time.Time { return now })
+	ctx := context.Background()
+
+	// Test each client and class has its own bucket
+	if decision, _ := limiter.Allow(ctx, ClassShorten, "ip:192.0.2.1"); !decision.Allowed {
+		t.Errorf("Expected the first request to be allowed")
+	}
+	if decision, _ := limiter.Allow(ctx, ClassShorten, "ip:192.0.2.1"); decision.Allowed {
+		t.Errorf("Expected the second request to be refused")
+	}
+	if decision, _ := limiter.Allow(ctx, ClassShorten, "ip:192.0.2.2"); !decision.Allowed {
+		t.Errorf("Expected another client to be allowed")
+	}
+	// Test checking a bucket does not take a token
+	if decision, _ := limiter.Check(ctx, ClassShorten, "ip:192.0.2.3"); !decision.Allowed || decision.Remaining != 1 {
+		t.Errorf("Expected a full bucket to be reported, got %+v", decision)
+	}
+	if decision, _ := limiter.Check(ctx, ClassShorten, "ip:192.0.2.1"); decision.Allowed || decision.RetryAfter != time.Minute {
+		t.Errorf("Expected an empty bucket to be reported, got %+v", decision)
+	}
+	if decision, _ := limiter.Allow(ctx, ClassShorten, "ip:192.0.2.3"); !decision.Allowed {
+		t.Errorf("Expected the checked client to still have its token")
+	}
+	if decision, err := limiter.Allow(ctx, ClassRedirect, "ip:192.0.2.1"); decision != nil || err != nil {
+		t.Errorf("Expected a class without a limit to return nil, got %+v %v", decision, err)
+	}
+}
+
+// fakeDynamoDB stores items by key and lets another writer sneak in
+// between a read and a write
+type fakeDynamoDB struct {
+	items      map[string]map[string]types.AttributeValue
+	interfere  int
+	conditions []string
+	beforePut  func()
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	key := params.Key["key"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.items[key]}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if f.beforePut != nil {
+		f.beforePut()
+	}
+	key := params.Item["key"].(*types.AttributeValueMemberS).Value
+	f.conditions = append(f.conditions, *params.ConditionExpression)
+	existing := f.items[key]
+	if f.interfere > 0 {
+		f.interfere--
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	current, versioned := existing["version"].(*types.AttributeValueMemberN)
+	if version, ok := params.ExpressionAttributeValues[":version"]; ok {
+		if !versioned || current.Value != version.(*types.AttributeValueMemberN).Value {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	} else if versioned {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	f.items[key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	client := &fakeDynamoDB{items: map[string]map[string]types.AttributeValue{}}
+	store := NewDynamoDBStore(client, "UrlShortenerRateLimits")
+	limit := Limit{Requests: 60, Period: time.Minute, Burst: 2}
+	now := time.Unix(1700000000, 0)
+	ctx := context.Background()
+
+	// Test a new bucket is created only if nobody else created it
+	decision, err := store.Take(ctx, "shorten#ip:192.0.2.1", limit, now)
+	if err != nil || !decision.Allowed || decision.Remaining != 1 || client.conditions[0] != "attribute_not_exists(version)" {
+		t.Fatalf("Unexpected first take: %+v %v %v", decision, err, client.conditions)
+	}
+	item := client.items["shorten#ip:192.0.2.1"]
+	expiration, _ := strconv.ParseInt(item["expiration"].(*types.AttributeValueMemberN).Value, 10, 64)
+	if expiration != now.Unix()+2 {
+		t.Errorf("Expected the item to expire when the bucket is full, got %d", expiration)
+	}
+
+	// Test a conflicting write is retried against the fresh bucket
+	client.interfere = 1
+	decision, err = store.Take(ctx, "shorten#ip:192.0.2.1", limit, now)
+	if err != nil || !decision.Allowed || decision.Remaining != 0 || client.conditions[2] != "version = :version" {
+		t.Fatalf("Unexpected retried take: %+v %v %v", decision, err, client.conditions)
+	}
+	if decision, _ = store.Take(ctx, "shorten#ip:192.0.2.1", limit, now); decision.Allowed {
+		t.Errorf("Expected the empty bucket to refuse")
+	}
+
+	// Test peeking reads the bucket without writing it
+	writes := len(client.conditions)
+	if decision, err = store.Peek(ctx, "shorten#ip:192.0.2.1", limit, now.Add(time.Second)); err != nil || !decision.Allowed || len(client.conditions) != writes {
+		t.Errorf("Expected a refilled token without a write, got %+v %v", decision, err)
+	}
+
+	// Test writes in the same millisecond still conflict, so the last
+	// token is spent once
+	later := now.Add(2 * time.Second)
+	if decision, _ = store.Take(ctx, "shorten#ip:192.0.2.1", limit, later); !decision.Allowed || decision.Remaining != 1 {
+		t.Fatalf("Expected one token left to spend, got %+v", decision)
+	}
+	var racing Decision
+	client.beforePut = func() {
+		client.beforePut = nil
+		racing, _ = store.Take(ctx, "shorten#ip:192.0.2.1", limit, later)
+	}
+	decision, err = store.Take(ctx, "shorten#ip:192.0.2.1", limit, later)
+	if err != nil || !racing.Allowed || decision.Allowed {
+		t.Errorf("Expected only one of two racing takes to be allowed, got %+v and %+v %v", racing, decision, err)
+	}
+
+	// Test a bucket written before versions is taken over
+	item = client.items["shorten#ip:192.0.2.1"]
+	delete(item, "version")
+	if decision, err = store.Take(ctx, "shorten#ip:192.0.2.1", limit, now.Add(time.Minute)); err != nil || !decision.Allowed {
+		t.Errorf("Expected an unversioned bucket to be updated, got %+v %v", decision, err)
+	}
+
+	// Test endless conflicts refuse the request instead of failing open
+	client.interfere = maxTakeAttempts
+	decision, err = store.Take(ctx, "shorten#ip:192.0.2.1", limit, now.Add(time.Minute))
+	if err != nil || decision.Allowed || decision.RetryAfter != time.Second {
+		t.Errorf("Expected a refusal after %d conflicts, got %+v %v", maxTakeAttempts, decision, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Package useragent classifies User-Agent headers by operating system,
+// device class and browser for routing decisions
+package useragent
+
+import "strings"
+
+// Operating systems
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+)
+
+// Device classes
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// Browsers
+const (
+	BrowserChrome  = "chrome"
+	BrowserSafari  = "safari"
+	BrowserFirefox = "firefox"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+)
+
+// Valid values for each field, used to validate routing rules
+var (
+	OperatingSystems = []string{OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux, OSChromeOS}
+	Devices          = []string{DeviceMobile, DeviceTablet, DeviceDesktop, DeviceBot}
+	Browsers         = []string{BrowserChrome, BrowserSafari, BrowserFirefox, BrowserEdge, BrowserOpera, BrowserSamsung}
+)
+
+// Info is what could be recognised from a user agent. Fields are empty when
+// they are unknown.
+type Info struct {
+	OS      string
+	Device  string
+	Browser string
+}
+
+// Parse classifies a User-Agent header. It looks for well known tokens
+// rather than fully parsing the header, which is enough for routing.
+func Parse(userAgent string) Info {
+	ua := strings.ToLower(userAgent)
+	info := Info{
+		OS:      parseOS(ua),
+		Browser: parseBrowser(ua),
+	}
+	info.Device = parseDevice(ua, info.OS)
+	return info
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return OSiOS
+	case strings.Contains(ua, "android"):
+		return OSAndroid
+	case strings.Contains(ua, "cros"):
+		return OSChromeOS
+	case strings.Contains(ua, "windows"):
+		return OSWindows
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		return OSMacOS
+	case strings.Contains(ua, "linux"):
+		return OSLinux
+	}
+	return ""
+}
+
+func parseDevice(ua, os string) string {
+	switch {
+	case strings.Contains(ua, "bot"), strings.Contains(ua, "crawler"), strings.Contains(ua, "spider"):
+		return DeviceBot
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"):
+		return DeviceTablet
+	case os == OSAndroid && !strings.Contains(ua, "mobile"):
+		// Android tablets leave "Mobile" out of their user agent
+		return DeviceTablet
+	case os == OSiOS, os == OSAndroid, strings.Contains(ua, "mobile"):
+		return DeviceMobile
+	case os != "":
+		return DeviceDesktop
+	}
+	return ""
+}
+
+func parseBrowser(ua string) string {
+	// Order matters: most browsers also claim to be Chrome and Safari
+	switch {
+	case strings.Contains(ua, "edg/"), strings.Contains(ua, "edga/"), strings.Contains(ua, "edgios/"):
+		return BrowserEdge
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		return BrowserOpera
+	case strings.Contains(ua, "samsungbrowser"):
+		return BrowserSamsung
+	case strings.Contains(ua, "firefox/"), strings.Contains(ua, "fxios/"):
+		return BrowserFirefox
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"):
+		return BrowserChrome
+	case strings.Contains(ua, "safari/"):
+		return BrowserSafari
+	}
+	return ""
+}
@@ -2,12 +2,21 @@ package utils
 
 import (
 	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	// Characters used in the random short code
 	charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// MaxShortCodeLength is the longest short code a link may have
+	MaxShortCodeLength = 64
 )
 
 // GenerateShortCode generates a random short code of specified length
@@ -26,10 +35,167 @@ func GenerateShortCode(length int) (string, error) {
 	return string(buffer), nil
 }
 
+// ValidateShortCode checks a short code chosen by hand or imported from
+// elsewhere. Codes are letters, digits, "-" and "_", so they are safe in a
+// URL path and never contain the "/" of a workspace domain's link key.
+func ValidateShortCode(code string) error {
+	if code == "" {
+		return fmt.Errorf("short code is required")
+	}
+	if len(code) > MaxShortCodeLength {
+		return fmt.Errorf("short code must be at most %d characters", MaxShortCodeLength)
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(charset, c) && c != '-' && c != '_' {
+			return fmt.Errorf("short code %q may only contain letters, digits, - and _", code)
+		}
+	}
+	return nil
+}
+
+// IsHTTPURL reports whether s is an absolute http or https URL
+func IsHTTPURL(s string) bool {
+	parsed, err := url.Parse(s)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Query forwarding modes for a link
+const (
+	// ForwardQueryMerge adds incoming parameters the destination does not already have
+	ForwardQueryMerge = "merge"
+	// ForwardQueryOverride lets incoming parameters replace the destination's
+	ForwardQueryOverride = "override"
+)
+
+// IsValidForwardQuery reports whether mode is a supported query forwarding
+// mode, where "" means off
+func IsValidForwardQuery(mode string) bool {
+	return mode == "" || mode == ForwardQueryMerge || mode == ForwardQueryOverride
+}
+
+// IsValidRedirectType reports whether status is a redirect a link may use
+func IsValidRedirectType(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
 // CalculateExpirationTime calculates the expiration timestamp based on days
 func CalculateExpirationTime(days int) int64 {
 	if days <= 0 {
 		return 0 // No expiration
 	}
 	return time.Now().AddDate(0, 0, days).Unix()
-}
\ No newline at end of file
+}
+
+// IsExpired reports whether an expiration timestamp has passed. DynamoDB TTL
+// can take up to 48 hours to delete expired items, so reads must check this.
+func IsExpired(expiration int64, now time.Time) bool {
+	return expiration > 0 && expiration <= now.Unix()
+}
+
+// ResolveExpirationTime works out the expiration timestamp from the three ways
+// a client can ask for one: an absolute RFC 3339 time, a Go duration such as
+// "36h", or a whole number of days. At most one may be given; 0 means no
+// expiration.
+func ResolveExpirationTime(expiresAt, expireIn string, days int, now time.Time) (int64, error) {
+	given := 0
+	for _, set := range []bool{expiresAt != "", expireIn != "", days > 0} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return 0, fmt.Errorf("only one of expires_at, expire_in and expire_in_days may be set")
+	}
+
+	switch {
+	case expiresAt != "":
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return 0, fmt.Errorf("expires_at must be an RFC 3339 timestamp")
+		}
+		if !t.After(now) {
+			return 0, fmt.Errorf("expires_at must be in the future")
+		}
+		return t.Unix(), nil
+
+	case expireIn != "":
+		d, err := time.ParseDuration(expireIn)
+		if err != nil {
+			return 0, fmt.Errorf("expire_in must be a duration such as 36h")
+		}
+		if d <= 0 {
+			return 0, fmt.Errorf("expire_in must be positive")
+		}
+		return now.Add(d).Unix(), nil
+
+	case days > 0:
+		return now.AddDate(0, 0, days).Unix(), nil
+	}
+
+	return 0, nil
+}
+
+// ParseActivationTime converts an optional RFC 3339 "active from" time into a
+// Unix timestamp, returning 0 when it is empty
+func ParseActivationTime(activeFrom string) (int64, error) {
+	if activeFrom == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, activeFrom)
+	if err != nil {
+		return 0, fmt.Errorf("active_from must be an RFC 3339 timestamp")
+	}
+	return t.Unix(), nil
+}
+
+// FormatTimestamp renders a Unix timestamp as RFC 3339, or "" for 0
+func FormatTimestamp(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// HashPassword hashes a link password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches a hash from HashPassword
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// SetQueryParams sets the given query parameters on rawURL, replacing any
+// existing values for the same keys and escaping them correctly. Empty values
+// are skipped.
+func SetQueryParams(rawURL string, params map[string]string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	changed := false
+	for key, value := range params {
+		if value == "" {
+			continue
+		}
+		query.Set(key, value)
+		changed = true
+	}
+	if !changed {
+		return rawURL, nil
+	}
+
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -69,4 +70,64 @@ func TestCalculateExpirationTime(t *testing.T) {
 	if diff < -5 || diff > 5 {
 		t.Errorf("Expected expiration around %d, got %d (diff: %d)", expectedExpiration, expiration, diff)
 	}
-}
\ No newline at end of file
+}
+
+func TestResolveExpirationTime(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Absolute timestamp
+	expiration, err := ResolveExpirationTime("2025-01-02T00:00:00Z", "", 0, now)
+	if err != nil || expiration != now.Add(24*time.Hour).Unix() {
+		t.Errorf("Expected expires_at to resolve to %d, got %d (err: %v)", now.Add(24*time.Hour).Unix(), expiration, err)
+	}
+
+	// Duration
+	expiration, err = ResolveExpirationTime("", "36h", 0, now)
+	if err != nil || expiration != now.Add(36*time.Hour).Unix() {
+		t.Errorf("Expected expire_in to resolve to %d, got %d (err: %v)", now.Add(36*time.Hour).Unix(), expiration, err)
+	}
+
+	// Days, counted from now rather than the wall clock
+	expiration, err = ResolveExpirationTime("", "", 7, now)
+	if err != nil || expiration != now.AddDate(0, 0, 7).Unix() {
+		t.Errorf("Expected expire_in_days to resolve to %d, got %d (err: %v)", now.AddDate(0, 0, 7).Unix(), expiration, err)
+	}
+
+	// Nothing set means no expiration
+	expiration, err = ResolveExpirationTime("", "", 0, now)
+	if err != nil || expiration != 0 {
+		t.Errorf("Expected no expiration, got %d (err: %v)", expiration, err)
+	}
+
+	// Invalid combinations and values
+	invalid := []struct {
+		expiresAt string
+		expireIn  string
+		days      int
+	}{
+		{"2025-01-02T00:00:00Z", "1h", 0},
+		{"", "1h", 7},
+		{"2024-12-31T00:00:00Z", "", 0},
+		{"tomorrow", "", 0},
+		{"", "-1h", 0},
+		{"", "1 day", 0},
+	}
+	for _, tc := range invalid {
+		if _, err := ResolveExpirationTime(tc.expiresAt, tc.expireIn, tc.days, now); err == nil {
+			t.Errorf("Expected an error for %+v", tc)
+		}
+	}
+}
+
+func TestValidateShortCode(t *testing.T) {
+	for _, code := range []string{"abc12", "Launch-2025_v2", strings.Repeat("a", MaxShortCodeLength)} {
+		if err := ValidateShortCode(code); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", code, err)
+		}
+	}
+	for _, code := range []string{"", "go.brand.com/abc", "has space", "café", strings.Repeat("a", MaxShortCodeLength+1)} {
+		if err := ValidateShortCode(code); err == nil {
+			t.Errorf("Expected %q to be invalid", code)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package webhook delivers signed link events to subscribed HTTP endpoints
+// in the background, retrying failures with exponential backoff and
+// recording every attempt in a delivery log
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/logger"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// Event types
+const (
+	EventLinkCreated = "link.created"
+	EventLinkClicked = "link.clicked"
+	EventLinkExpired = "link.expired"
+	EventLinkDeleted = "link.deleted"
+)
+
+// EventTypes lists every event a webhook can subscribe to
+var EventTypes = []string{EventLinkCreated, EventLinkClicked, EventLinkExpired, EventLinkDeleted}
+
+// Request headers sent with every delivery
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// How far a signed timestamp may be from the receiver's clock
+const SignatureTolerance = 5 * time.Minute
+
+// Event is the JSON payload of a delivery
+type Event struct {
+	ID        string             `json:"id"`
+	Type      string             `json:"type"`
+	CreatedAt string             `json:"created_at"`
+	Link      model.LinkResponse `json:"link"`
+	Click     *Click             `json:"click,omitempty"`
+}
+
+// Click describes the visit behind a link.clicked event
+type Click struct {
+	Destination string `json:"destination"`
+	Country     string `json:"country,omitempty"`
+	Rule        string `json:"rule,omitempty"`
+	Variant     string `json:"variant,omitempty"`
+}
+
+// NewEvent creates an event of the given type with a random ID
+func NewEvent(eventType string, link model.LinkResponse, now time.Time) Event {
+	return Event{
+		ID:        "evt_" + randomHex(12),
+		Type:      eventType,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		Link:      link,
+	}
+}
+
+// NewSecret generates a signing secret for a webhook
+func NewSecret() string {
+	return "whsec_" + randomHex(24)
+}
+
+// NewID generates a webhook subscription ID
+func NewID() string {
+	return "wh_" + randomHex(8)
+}
+
+// Sign returns the signature header value for a payload sent at timestamp:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp is within
+// SignatureTolerance of now. Receivers should call it before trusting a
+// payload.
+func Verify(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s header", HeaderTimestamp)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return fmt.Errorf("timestamp outside the tolerance window")
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// Store loads subscriptions and records delivery attempts
+type Store interface {
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	RecordWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+}
+
+// Options controls delivery timing
+type Options struct {
+	// Attempts per event and subscription, including the first
+	MaxAttempts int
+	// Delay before the first retry, doubled for each later one up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout of a single HTTP request
+	Timeout time.Duration
+	// How long the subscription list is cached
+	CacheTTL time.Duration
+	// How long delivery log entries are kept
+	LogRetention time.Duration
+}
+
+// DefaultOptions keeps all retries of a delivery within about 8 seconds
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts:  5,
+		BaseDelay:    500 * time.Millisecond,
+		MaxDelay:     4 * time.Second,
+		Timeout:      5 * time.Second,
+		CacheTTL:     time.Minute,
+		LogRetention: 30 * 24 * time.Hour,
+	}
+}
+
+// Dispatcher sends events to matching subscriptions. Notify returns at
+// once and delivery happens on background goroutines; Flush waits for
+// them. On Lambda, call Flush before returning from each invocation:
+// goroutines do not run while the environment is frozen.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	opts   Options
+
+	// Deliveries run under runCtx, which a Flush that runs out of time
+	// cancels so they do not carry on into a later invocation. idle is
+	// closed once no event is pending.
+	runMutex  sync.Mutex
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	pending   int
+	idle      chan struct{}
+
+	mutex    sync.Mutex
+	webhooks []model.Webhook
+	loadedAt time.Time
+}
+
+// NewDispatcher creates a dispatcher that reads subscriptions from store
+func NewDispatcher(store Store, opts Options) *Dispatcher {
+	return &Dispatcher{
+		store:  store,
+		client: &http.Client{Timeout: opts.Timeout},
+		opts:   opts,
+	}
+}
+
+// Notify queues an event for delivery to every subscription that wants it
+func (d *Dispatcher) Notify(event Event) {
+	ctx := d.begin()
+	go func() {
+		defer d.end()
+		d.dispatch(ctx, event)
+	}()
+}
+
+// Flush waits until every queued event has been delivered or has used up
+// its retries. If ctx is done first, deliveries still in progress are
+// abandoned and ctx's error is returned.
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	d.runMutex.Lock()
+	idle := d.idle
+	d.runMutex.Unlock()
+	if idle == nil {
+		return nil
+	}
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		d.abandon()
+		return ctx.Err()
+	}
+}
+
+// begin counts a pending event and returns the context to deliver it under
+func (d *Dispatcher) begin() context.Context {
+	d.runMutex.Lock()
+	defer d.runMutex.Unlock()
+	if d.runCtx == nil {
+		d.runCtx, d.cancelRun = context.WithCancel(context.Background())
+	}
+	if d.pending == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.pending++
+	return d.runCtx
+}
+
+// end marks a pending event as done
+func (d *Dispatcher) end() {
+	d.runMutex.Lock()
+	defer d.runMutex.Unlock()
+	d.pending--
+	if d.pending == 0 {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// abandon cancels the deliveries in progress; later ones get a new context
+func (d *Dispatcher) abandon() {
+	d.runMutex.Lock()
+	defer d.runMutex.Unlock()
+	if d.cancelRun != nil {
+		d.cancelRun()
+	}
+	d.runCtx, d.cancelRun = nil, nil
+}
+
+// Invalidate drops the cached subscription list so the next event reloads it
+func (d *Dispatcher) Invalidate() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.webhooks = nil
+	d.loadedAt = time.Time{}
+}
+
+// dispatch delivers an event to each interested subscription in parallel
+func (d *Dispatcher) dispatch(ctx context.Context, event Event) {
+	webhooks, err := d.subscriptions(ctx)
+	if err != nil {
+		logger.Error("Failed to load webhook subscriptions", map[string]interface{}{
+			"eventId": event.ID,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to encode webhook event", map[string]interface{}{
+			"eventId": event.ID,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, webhook := range webhooks {
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Type) {
+			continue
+		}
+		wg.Add(1)
+		go func(webhook model.Webhook) {
+			defer wg.Done()
+			d.deliver(ctx, webhook, event, body)
+		}(webhook)
+	}
+	wg.Wait()
+}
+
+// subscriptions returns the cached subscription list, reloading it once it
+// is older than CacheTTL
+func (d *Dispatcher) subscriptions(ctx context.Context) ([]model.Webhook, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.webhooks != nil && time.Since(d.loadedAt) < d.opts.CacheTTL {
+		return d.webhooks, nil
+	}
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if webhooks == nil {
+		webhooks = []model.Webhook{}
+	}
+	d.webhooks = webhooks
+	d.loadedAt = time.Now()
+	return webhooks, nil
+}
+
+// deliver posts an event to one subscription, retrying network errors,
+// 429s and 5xx responses with exponential backoff and full jitter
+func (d *Dispatcher) deliver(ctx context.Context, webhook model.Webhook, event Event, body []byte) {
+	delay := d.opts.BaseDelay
+	for attempt := 1; attempt <= d.opts.MaxAttempts; attempt++ {
+		delivery := d.attempt(ctx, webhook, event, body, attempt)
+		if ctx.Err() != nil {
+			// Abandoned by Flush; an interrupted attempt is not an outcome
+			logger.Warn("Abandoned webhook delivery", map[string]interface{}{
+				"webhookId": webhook.ID,
+				"eventId":   event.ID,
+				"attempt":   attempt,
+			})
+			return
+		}
+		if err := d.store.RecordWebhookDelivery(ctx, delivery); err != nil {
+			logger.Warn("Failed to record webhook delivery", map[string]interface{}{
+				"webhookId": webhook.ID,
+				"error":     err.Error(),
+			})
+		}
+		if delivery.Success || !retryable(delivery.StatusCode) {
+			return
+		}
+		if attempt == d.opts.MaxAttempts {
+			logger.Error("Giving up on webhook delivery", map[string]interface{}{
+				"webhookId": webhook.ID,
+				"eventId":   event.ID,
+				"attempts":  attempt,
+			})
+			return
+		}
+
+		select {
+		case <-time.After(delay/2 + mathrand.N(delay/2+1)):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, d.opts.MaxDelay)
+	}
+}
+
+// attempt makes a single signed request and describes its outcome
+func (d *Dispatcher) attempt(ctx context.Context, webhook model.Webhook, event Event, body []byte, attempt int) *model.WebhookDelivery {
+	start := time.Now()
+	delivery := &model.WebhookDelivery{
+		WebhookID:  webhook.ID,
+		DeliveryID: fmt.Sprintf("%s#%s#%d", start.UTC().Format("20060102T150405.000000000Z"), event.ID, attempt),
+		EventID:    event.ID,
+		EventType:  event.Type,
+		Attempt:    attempt,
+		CreatedAt:  start.UTC().Format(time.RFC3339),
+		Expiration: start.Add(d.opts.LogRetention).Unix(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhooks/1.0")
+	req.Header.Set(HeaderID, event.ID)
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(start.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, start.Unix(), body))
+
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = resp.Status
+	}
+	return delivery
+}
+
+// retryable reports whether a failed attempt may succeed later. Status 0
+// means the request never got a response.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,140 @@
+package webhook_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/database"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook/webhooktest"
+)
+
+func testOptions() webhook.Options {
+	opts := webhook.DefaultOptions()
+	opts.BaseDelay = time.Millisecond
+	opts.MaxDelay = 4 * time.Millisecond
+	opts.MaxAttempts = 3
+	return opts
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id": "evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	header := http.Header{}
+	header.Set(webhook.HeaderTimestamp, "1700000000")
+	header.Set(webhook.HeaderSignature, webhook.Sign("secret", now.Unix(), body))
+
+	if err := webhook.Verify("secret", header, body, now); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+	if err := webhook.Verify("other", header, body, now); err == nil {
+		t.Errorf("Expected a signature mismatch for the wrong secret")
+	}
+	if err := webhook.Verify("secret", header, []byte(`{"id": "evt_2"}`), now); err == nil {
+		t.Errorf("Expected a signature mismatch for a changed body")
+	}
+	if err := webhook.Verify("secret", header, body, now.Add(10*time.Minute)); err == nil {
+		t.Errorf("Expected a stale timestamp to be rejected")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	receiver := webhooktest.NewReceiver("whsec_test")
+	defer receiver.Close()
+
+	db := database.NewMockDynamoDB()
+	ctx := context.Background()
+	db.CreateWebhook(ctx, &model.Webhook{ID: "wh_all", URL: receiver.URL, Secret: "whsec_test"})
+	db.CreateWebhook(ctx, &model.Webhook{ID: "wh_deleted", URL: receiver.URL, Secret: "whsec_test", Events: []string{webhook.EventLinkDeleted}})
+
+	dispatcher := webhook.NewDispatcher(db, testOptions())
+
+	// The first two attempts fail and are retried; only wh_all wants clicks
+	receiver.FailNext(2)
+	event := webhook.NewEvent(webhook.EventLinkClicked, model.LinkResponse{ShortCode: "aB3xY"}, time.Now())
+	event.Click = &webhook.Click{Destination: "https://example.com"}
+	dispatcher.Notify(event)
+	if err := dispatcher.Flush(ctx); err != nil {
+		t.Fatalf("Flush returned an error: %v", err)
+	}
+
+	events := receiver.Events()
+	if len(events) != 1 || events[0].ID != event.ID || events[0].Link.ShortCode != "aB3xY" || events[0].Click.Destination != "https://example.com" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+	if receiver.Requests() != 3 {
+		t.Errorf("Expected 3 requests, got %d", receiver.Requests())
+	}
+
+	// Test the delivery log records each attempt, newest first
+	deliveries, _ := db.ListWebhookDeliveries(ctx, "wh_all", 10)
+	if len(deliveries) != 3 || !deliveries[0].Success || deliveries[0].Attempt != 3 ||
+		deliveries[2].Success || deliveries[2].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected delivery log: %+v", deliveries)
+	}
+	if deliveries, _ := db.ListWebhookDeliveries(ctx, "wh_deleted", 10); len(deliveries) != 0 {
+		t.Errorf("Expected no deliveries to a webhook without the event, got %d", len(deliveries))
+	}
+
+	// Test retries stop after MaxAttempts
+	receiver.FailNext(10)
+	dispatcher.Notify(webhook.NewEvent(webhook.EventLinkDeleted, model.LinkResponse{ShortCode: "aB3xY"}, time.Now()))
+	dispatcher.Flush(ctx)
+	if requests := receiver.Requests(); requests != 3+2*3 {
+		t.Errorf("Expected 3 attempts per subscription, got %d requests in total", requests)
+	}
+
+	// Test a wrong secret is rejected by the receiver and not retried
+	db.CreateWebhook(ctx, &model.Webhook{ID: "wh_wrong", URL: receiver.URL, Secret: "whsec_wrong"})
+	dispatcher.Invalidate()
+	receiver.FailNext(0)
+	dispatcher.Notify(webhook.NewEvent(webhook.EventLinkCreated, model.LinkResponse{ShortCode: "aB3xY"}, time.Now()))
+	dispatcher.Flush(ctx)
+	if receiver.Rejected() != 1 {
+		t.Errorf("Expected 1 rejected delivery, got %d", receiver.Rejected())
+	}
+	if deliveries, _ := db.ListWebhookDeliveries(ctx, "wh_wrong", 10); len(deliveries) != 1 || deliveries[0].StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected delivery log for the wrong secret: %+v", deliveries)
+	}
+}
+
+func TestDispatcherFlushDeadline(t *testing.T) {
+	receiver := webhooktest.NewReceiver("whsec_test")
+	defer receiver.Close()
+
+	db := database.NewMockDynamoDB()
+	ctx := context.Background()
+	db.CreateWebhook(ctx, &model.Webhook{ID: "wh_all", URL: receiver.URL, Secret: "whsec_test"})
+
+	opts := testOptions()
+	opts.BaseDelay = time.Hour
+	opts.MaxDelay = time.Hour
+	dispatcher := webhook.NewDispatcher(db, opts)
+
+	// Test a Flush that runs out of time abandons the retry it was waiting for
+	receiver.FailNext(10)
+	dispatcher.Notify(webhook.NewEvent(webhook.EventLinkCreated, model.LinkResponse{ShortCode: "aB3xY"}, time.Now()))
+	flushCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := dispatcher.Flush(flushCtx); err == nil {
+		t.Fatalf("Expected Flush to run out of time")
+	}
+	doneCtx, cancelDone := context.WithTimeout(ctx, time.Second)
+	defer cancelDone()
+	if err := dispatcher.Flush(doneCtx); err != nil {
+		t.Errorf("Expected the abandoned delivery to stop, got %v", err)
+	}
+	if receiver.Requests() != 1 {
+		t.Errorf("Expected 1 request, got %d", receiver.Requests())
+	}
+
+	// Test later events are still delivered
+	receiver.FailNext(0)
+	dispatcher.Notify(webhook.NewEvent(webhook.EventLinkCreated, model.LinkResponse{ShortCode: "aB3xY"}, time.Now()))
+	if err := dispatcher.Flush(ctx); err != nil || len(receiver.Events()) != 1 {
+		t.Errorf("Expected a later event to be delivered, got %v and %d events", err, len(receiver.Events()))
+	}
+}
@@ -0,0 +1,129 @@
+// Package webhooktest provides a local webhook receiver for tests. It
+// verifies signatures, records the events it accepts and can be told to
+// fail requests to exercise retries.
+package webhooktest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/webhook"
+)
+
+// Receiver is an HTTP server that accepts signed webhook deliveries
+type Receiver struct {
+	// URL is the address to subscribe
+	URL string
+
+	server *httptest.Server
+	secret string
+
+	mutex    sync.Mutex
+	events   []webhook.Event
+	requests int
+	rejected int
+	failures int
+	arrived  chan struct{}
+}
+
+// NewReceiver starts a receiver that checks signatures against secret.
+// Call Close when done.
+func NewReceiver(secret string) *Receiver {
+	r := &Receiver{
+		secret:  secret,
+		arrived: make(chan struct{}, 1),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	r.URL = r.server.URL
+	return r
+}
+
+// Close shuts the receiver down
+func (r *Receiver) Close() {
+	r.server.Close()
+}
+
+// FailNext makes the next n deliveries fail with 503 Service Unavailable
+func (r *Receiver) FailNext(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failures = n
+}
+
+// Events returns the events accepted so far, in arrival order
+func (r *Receiver) Events() []webhook.Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]webhook.Event(nil), r.events...)
+}
+
+// Requests returns how many deliveries arrived, including failed and
+// rejected ones
+func (r *Receiver) Requests() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests
+}
+
+// Rejected returns how many deliveries had an invalid signature
+func (r *Receiver) Rejected() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rejected
+}
+
+// WaitForEvents waits until at least n events have been accepted or the
+// timeout passes, and returns the events accepted so far
+func (r *Receiver) WaitForEvents(n int, timeout time.Duration) []webhook.Event {
+	deadline := time.After(timeout)
+	for {
+		if events := r.Events(); len(events) >= n {
+			return events
+		}
+		select {
+		case <-r.arrived:
+		case <-deadline:
+			return r.Events()
+		}
+	}
+}
+
+func (r *Receiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests++
+
+	if err := webhook.Verify(r.secret, req.Header, body, time.Now()); err != nil {
+		r.rejected++
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	var event webhook.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.events = append(r.events, event)
+	w.WriteHeader(http.StatusNoContent)
+
+	select {
+	case r.arrived <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,84 @@
+// Package workspace maps owners and short domains to the workspaces they
+// belong to, so each brand can shorten links on its own domain.
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yusupscopes/aws-url-shortener-api/pkg/model"
+)
+
+// Directory looks up workspaces by owner and by domain
+type Directory struct {
+	byOwner  map[string]*model.Workspace
+	byDomain map[string]*model.Workspace
+}
+
+// Parse reads workspaces from a JSON array such as
+// [{"name": "brand-a", "domains": ["go.brand-a.com"], "owners": ["team-a"]}].
+// Domains are matched case-insensitively. A domain or owner may belong to
+// only one workspace.
+func Parse(data string) (*Directory, error) {
+	var workspaces []model.Workspace
+	if err := json.Unmarshal([]byte(data), &workspaces); err != nil {
+		return nil, fmt.Errorf("workspaces must be a JSON array: %w", err)
+	}
+
+	d := &Directory{byOwner: map[string]*model.Workspace{}, byDomain: map[string]*model.Workspace{}}
+	for i := range workspaces {
+		ws := &workspaces[i]
+		if ws.Name == "" {
+			return nil, fmt.Errorf("workspace %d has no name", i+1)
+		}
+		if len(ws.Domains) == 0 {
+			return nil, fmt.Errorf("workspace %s has no domains", ws.Name)
+		}
+		for j, domain := range ws.Domains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if domain == "" || strings.ContainsAny(domain, "/: ") {
+				return nil, fmt.Errorf("workspace %s: %q is not a domain name", ws.Name, ws.Domains[j])
+			}
+			if other, ok := d.byDomain[domain]; ok {
+				return nil, fmt.Errorf("domain %s belongs to both %s and %s", domain, other.Name, ws.Name)
+			}
+			ws.Domains[j] = domain
+			d.byDomain[domain] = ws
+		}
+		for _, owner := range ws.Owners {
+			if other, ok := d.byOwner[owner]; ok {
+				return nil, fmt.Errorf("owner %s belongs to both %s and %s", owner, other.Name, ws.Name)
+			}
+			d.byOwner[owner] = ws
+		}
+	}
+	return d, nil
+}
+
+// ForOwner returns the workspace an owner belongs to, or nil
+func (d *Directory) ForOwner(owner string) *model.Workspace {
+	if d == nil || owner == "" {
+		return nil
+	}
+	return d.byOwner[owner]
+}
+
+// ForDomain returns the workspace that owns a domain, or nil
+func (d *Directory) ForDomain(domain string) *model.Workspace {
+	if d == nil {
+		return nil
+	}
+	return d.byDomain[strings.ToLower(domain)]
+}
+
+// FromEnv reads workspaces from WORKSPACES (see Parse). It returns nil when
+// WORKSPACES is unset.
+func FromEnv() (*Directory, error) {
+	data := os.Getenv("WORKSPACES")
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	return Parse(data)
+}
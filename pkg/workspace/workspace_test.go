@@ -0,0 +1,45 @@
+package workspace
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	directory, err := Parse(`[
+		{"name": "brand-a", "domains": ["Go.Brand-A.com", "links.brand-a.com"], "owners": ["team-a", "team-a2"]},
+		{"name": "brand-b", "domains": ["go.brand-b.com"], "owners": ["team-b"]}
+	]`)
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+
+	// Test lookups by owner and by domain, regardless of case
+	if ws := directory.ForOwner("team-a2"); ws == nil || ws.Name != "brand-a" || ws.Domains[0] != "go.brand-a.com" {
+		t.Errorf("Expected team-a2 to belong to brand-a, got %+v", ws)
+	}
+	if ws := directory.ForDomain("GO.BRAND-B.COM"); ws == nil || ws.Name != "brand-b" {
+		t.Errorf("Expected go.brand-b.com to belong to brand-b, got %+v", ws)
+	}
+	if directory.ForOwner("team-c") != nil || directory.ForDomain("example.com") != nil {
+		t.Errorf("Expected unknown owners and domains to have no workspace")
+	}
+
+	// Test a missing directory has no workspaces
+	var none *Directory
+	if none.ForOwner("team-a") != nil || none.ForDomain("go.brand-a.com") != nil {
+		t.Errorf("Expected no workspaces without a directory")
+	}
+
+	for _, data := range []string{
+		`{"name": "brand-a"}`,
+		`[{"domains": ["go.brand-a.com"]}]`,
+		`[{"name": "brand-a", "domains": []}]`,
+		`[{"name": "brand-a", "domains": ["https://go.brand-a.com"]}]`,
+		`[{"name": "a", "domains": ["go.brand.com"]}, {"name": "b", "domains": ["GO.brand.com"]}]`,
+		`[{"name": "a", "domains": ["a.com"], "owners": ["team"]}, {"name": "b", "domains": ["b.com"], "owners": ["team"]}]`,
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}